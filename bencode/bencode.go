@@ -0,0 +1,169 @@
+// Package bencode implements the encoding used by torrent files and tracker
+// responses, as described in BEP 3.
+//
+// Values decode into interface{} as int64, string, []interface{} and
+// map[string]interface{}. Struct fields are matched against dictionary keys
+// with the `bencode:"key"` tag, or the field name when the tag is missing. The
+// ",omitempty" option skips empty fields when encoding and "-" ignores the field.
+// A map field with string keys and the ",remain" option collects the keys
+// matching no other field, and its entries are encoded along the other fields.
+// Integers decode into bools, non-zero being true, and bools encode as 0 or 1
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrMaxDepth = errors.New("maximum nesting depth exceeded")
+	ErrMaxSize  = errors.New("maximum size exceeded")
+)
+
+// SyntaxError describes malformed input and where it was found
+type SyntaxError struct {
+	// Offset is the number of bytes read before the error
+	Offset int64
+	Msg    string
+	// Err is the underlying error, such as ErrMaxDepth or io.ErrUnexpectedEOF
+	Err error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.Err
+}
+
+// UnmarshalTypeError describes a value which cannot be stored in the Go value it is decoded into
+type UnmarshalTypeError struct {
+	// Value is the kind of bencode value: integer, string, list or dictionary
+	Value  string
+	Type   reflect.Type
+	Offset int64
+	// Field is the dotted path of the struct field holding the value, if any
+	Field string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if len(e.Field) > 0 {
+		return fmt.Sprintf("bencode: cannot decode %s into field %s of type %s at offset %d", e.Value, e.Field, e.Type, e.Offset)
+	}
+
+	return fmt.Sprintf("bencode: cannot decode %s into Go value of type %s at offset %d", e.Value, e.Type, e.Offset)
+}
+
+// UnsupportedTypeError is returned by Marshal for values which have no bencode representation
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("bencode: unsupported type %s", e.Type)
+}
+
+// Marshaler is implemented by types encoding themselves into valid bencode
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// Unmarshaler is implemented by types decoding a bencoded value themselves, the
+// data is a copy of the raw value
+type Unmarshaler interface {
+	UnmarshalBencode(data []byte) error
+}
+
+// RawMessage is a raw encoded value, it delays decoding or keeps the exact
+// encoding of a value, such as the info dictionary the info hash is computed over
+type RawMessage []byte
+
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("bencode: empty RawMessage")
+	}
+
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// field is a struct field mapped to a dictionary key
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields are the fields of a struct type mapped to dictionary keys
+type structFields struct {
+	// list is sorted by key
+	list []field
+	// remain is the index of the map field collecting the keys matching no other field
+	remain []int
+}
+
+var fieldCache sync.Map // map[reflect.Type]*structFields
+
+// cachedFields returns the fields of a struct type
+func cachedFields(t reflect.Type) *structFields {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(*structFields)
+	}
+
+	fields := &structFields{}
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+
+		tag := sf.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "remain" && sf.Type.Kind() == reflect.Map && sf.Type.Key().Kind() == reflect.String {
+			fields.remain = sf.Index
+			continue
+		}
+
+		if len(name) == 0 {
+			name = sf.Name
+		}
+
+		fields.list = append(fields.list, field{
+			name:      name,
+			index:     sf.Index,
+			omitEmpty: opts == "omitempty",
+		})
+	}
+
+	slices.SortFunc(fields.list, func(a, b field) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	f, _ := fieldCache.LoadOrStore(t, fields)
+	return f.(*structFields)
+}
+
+// byKey finds the field of a key, keys are case sensitive
+func (s *structFields) byKey(key string) (field, bool) {
+	i, found := slices.BinarySearchFunc(s.list, key, func(f field, key string) int {
+		return strings.Compare(f.name, key)
+	})
+	if found {
+		return s.list[i], true
+	}
+
+	return field{}, false
+}
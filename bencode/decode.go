@@ -0,0 +1,603 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+const (
+	// DefaultMaxDepth is the deepest nesting of lists and dictionaries accepted by default
+	DefaultMaxDepth = 64
+	// DefaultMaxSize is the largest encoded value accepted by default
+	DefaultMaxSize = 64 << 20 // 64MiB
+)
+
+var (
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+)
+
+// Decoder reads bencoded values from a stream. It may read past the end of
+// the value, InputOffset reports how many bytes were actually used
+type Decoder struct {
+	// MaxDepth is the deepest nesting of lists and dictionaries accepted
+	MaxDepth int
+	// MaxSize is the largest number of bytes a single value may span
+	MaxSize int64
+
+	r     *bufio.Reader
+	off   int64
+	start int64
+	depth int
+	field string
+	// rec holds the bytes read while recording a raw value
+	rec       []byte
+	recording bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		MaxDepth: DefaultMaxDepth,
+		MaxSize:  DefaultMaxSize,
+		r:        bufio.NewReader(r),
+	}
+}
+
+// Unmarshal decodes a single bencoded value filling the whole data into v
+func Unmarshal(data []byte, v interface{}) error {
+	d := NewDecoder(bytes.NewReader(data))
+	if err := d.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return &SyntaxError{0, "empty input", io.ErrUnexpectedEOF}
+		}
+		return err
+	}
+
+	if d.off != int64(len(data)) {
+		return &SyntaxError{d.off, "trailing data after value", nil}
+	}
+
+	return nil
+}
+
+// InputOffset returns the number of bytes consumed by the decoded values
+func (d *Decoder) InputOffset() int64 {
+	return d.off
+}
+
+// Decode reads the next value into v, which must be a non-nil pointer. It
+// returns io.EOF when the stream ends before a value starts
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: Decode requires a non-nil pointer, got %T", v)
+	}
+
+	if _, err := d.r.Peek(1); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+
+	d.start = d.off
+	d.depth = 0
+	d.field = ""
+	return d.value(rv.Elem())
+}
+
+func (d *Decoder) syntaxError(msg string, err error) error {
+	return &SyntaxError{d.off, msg, err}
+}
+
+func (d *Decoder) peek() (byte, error) {
+	b, err := d.r.Peek(1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, d.syntaxError("unexpected end of input", io.ErrUnexpectedEOF)
+		}
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.peek()
+	if err != nil {
+		return 0, err
+	}
+
+	if d.off-d.start >= d.MaxSize {
+		return 0, d.syntaxError(ErrMaxSize.Error(), ErrMaxSize)
+	}
+
+	d.advance()
+	return c, nil
+}
+
+// advance consumes a peeked delimiter. Delimiters are not counted against the
+// maximum size as their number is bounded by the maximum depth
+func (d *Decoder) advance() {
+	c, _ := d.r.ReadByte()
+	d.off++
+	if d.recording {
+		d.rec = append(d.rec, c)
+	}
+}
+
+// readUntil reads the bytes up to the delimiter, which is consumed but not returned
+func (d *Decoder) readUntil(delim byte) ([]byte, error) {
+	var buf []byte
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if c == delim {
+			return buf, nil
+		}
+
+		// no integer or string length needs that many digits
+		if len(buf) > 20 {
+			return nil, d.syntaxError(fmt.Sprintf("missing %q", delim), nil)
+		}
+		buf = append(buf, c)
+	}
+}
+
+func (d *Decoder) readInt() (int64, error) {
+	start := d.off
+	if _, err := d.readByte(); err != nil {
+		return 0, err
+	}
+
+	digits, err := d.readUntil('e')
+	if err != nil {
+		return 0, err
+	}
+
+	if !validInt(digits) {
+		return 0, &SyntaxError{start, fmt.Sprintf("invalid integer %q", digits), nil}
+	}
+
+	n, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return 0, &SyntaxError{start, fmt.Sprintf("integer %s out of range", digits), err}
+	}
+
+	return n, nil
+}
+
+// validInt reports whether the digits are a canonical integer, without leading zeros or negative zero
+func validInt(digits []byte) bool {
+	if len(digits) > 0 && digits[0] == '-' {
+		digits = digits[1:]
+		if len(digits) > 0 && digits[0] == '0' {
+			return false
+		}
+	}
+
+	if len(digits) == 0 || (digits[0] == '0' && len(digits) > 1) {
+		return false
+	}
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *Decoder) readString() ([]byte, error) {
+	start := d.off
+	digits, err := d.readUntil(':')
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil || length < 0 || !validInt(digits) {
+		return nil, &SyntaxError{start, fmt.Sprintf("invalid string length %q", digits), nil}
+	}
+
+	// the length is checked before allocating so hostile lengths cannot exhaust memory
+	if d.off-d.start+length > d.MaxSize {
+		return nil, &SyntaxError{start, fmt.Sprintf("string of %d bytes: %s", length, ErrMaxSize), ErrMaxSize}
+	}
+
+	buf := make([]byte, length)
+	n, err := io.ReadFull(d.r, buf)
+	d.off += int64(n)
+	if d.recording {
+		d.rec = append(d.rec, buf[:n]...)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, d.syntaxError("unexpected end of input", io.ErrUnexpectedEOF)
+		}
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func (d *Decoder) enter() error {
+	d.depth++
+	if d.depth > d.MaxDepth {
+		return d.syntaxError(ErrMaxDepth.Error(), ErrMaxDepth)
+	}
+
+	return nil
+}
+
+func (d *Decoder) leave() {
+	d.depth--
+}
+
+// raw reads the next value without decoding it and returns its encoding
+func (d *Decoder) raw() ([]byte, error) {
+	if d.recording {
+		// nested recording, the outer recorder already holds the bytes
+		start := len(d.rec)
+		if err := d.skip(); err != nil {
+			return nil, err
+		}
+		return bytes.Clone(d.rec[start:]), nil
+	}
+
+	d.recording = true
+	d.rec = d.rec[:0]
+	err := d.skip()
+	d.recording = false
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.Clone(d.rec), nil
+}
+
+// skip reads the next value and discards it
+func (d *Decoder) skip() error {
+	_, err := d.generic(false)
+	return err
+}
+
+// generic decodes the next value into its interface{} representation, or
+// only validates it when keep is false
+func (d *Decoder) generic(keep bool) (interface{}, error) {
+	c, err := d.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c == 'i':
+		return d.readInt()
+	case c >= '0' && c <= '9':
+		s, err := d.readString()
+		if err != nil || !keep {
+			return nil, err
+		}
+		return string(s), nil
+	case c == 'l':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+
+		d.advance()
+		list := []interface{}{}
+		for {
+			c, err := d.peek()
+			if err != nil {
+				return nil, err
+			}
+
+			if c == 'e' {
+				d.advance()
+				return list, nil
+			}
+
+			v, err := d.generic(keep)
+			if err != nil {
+				return nil, err
+			}
+
+			if keep {
+				list = append(list, v)
+			}
+		}
+	case c == 'd':
+		if err := d.enter(); err != nil {
+			return nil, err
+		}
+		defer d.leave()
+
+		d.advance()
+		dict := map[string]interface{}{}
+		for {
+			key, end, err := d.key()
+			if err != nil {
+				return nil, err
+			}
+
+			if end {
+				return dict, nil
+			}
+
+			v, err := d.generic(keep)
+			if err != nil {
+				return nil, err
+			}
+
+			if keep {
+				dict[key] = v
+			}
+		}
+	default:
+		return nil, d.syntaxError(fmt.Sprintf("unexpected %q", c), nil)
+	}
+}
+
+// key reads the next dictionary key, end is set when the dictionary is over
+func (d *Decoder) key() (key string, end bool, err error) {
+	c, err := d.peek()
+	if err != nil {
+		return "", false, err
+	}
+
+	if c == 'e' {
+		d.advance()
+		return "", true, nil
+	}
+
+	if c < '0' || c > '9' {
+		return "", false, d.syntaxError("dictionary keys must be strings", nil)
+	}
+
+	k, err := d.readString()
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(k), false, nil
+}
+
+func (d *Decoder) typeError(value string, t reflect.Type, offset int64) error {
+	return &UnmarshalTypeError{value, t, offset, d.field}
+}
+
+func (d *Decoder) value(v reflect.Value) error {
+	// the unmarshaler of a pointer is used when the pointer is addressable
+	if v.Kind() != reflect.Pointer && v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		data, err := d.raw()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(data)
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.value(v.Elem())
+	}
+
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		g, err := d.generic(true)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(g))
+		return nil
+	}
+
+	c, err := d.peek()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == 'i':
+		return d.intValue(v)
+	case c >= '0' && c <= '9':
+		return d.stringValue(v)
+	case c == 'l':
+		return d.listValue(v)
+	case c == 'd':
+		return d.dictValue(v)
+	default:
+		return d.syntaxError(fmt.Sprintf("unexpected %q", c), nil)
+	}
+}
+
+func (d *Decoder) intValue(v reflect.Value) error {
+	start := d.off
+	n, err := d.readInt()
+	if err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			return d.typeError("integer "+strconv.FormatInt(n, 10), v.Type(), start)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return d.typeError("integer "+strconv.FormatInt(n, 10), v.Type(), start)
+		}
+		v.SetUint(uint64(n))
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	default:
+		return d.typeError("integer", v.Type(), start)
+	}
+
+	return nil
+}
+
+func (d *Decoder) stringValue(v reflect.Value) error {
+	start := d.off
+	s, err := d.readString()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(s))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(s)
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.Len() != len(s) {
+			return d.typeError(fmt.Sprintf("string of %d bytes", len(s)), v.Type(), start)
+		}
+		reflect.Copy(v, reflect.ValueOf(s))
+	default:
+		return d.typeError("string", v.Type(), start)
+	}
+
+	return nil
+}
+
+func (d *Decoder) listValue(v reflect.Value) error {
+	start := d.off
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return d.typeError("list", v.Type(), start)
+	}
+
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	d.advance()
+	field := d.field
+	i := 0
+	for {
+		c, err := d.peek()
+		if err != nil {
+			return err
+		}
+
+		if c == 'e' {
+			d.advance()
+			break
+		}
+
+		d.field = fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case v.Kind() == reflect.Slice:
+			if i >= v.Len() {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+			err = d.value(v.Index(i))
+		case i < v.Len():
+			err = d.value(v.Index(i))
+		default:
+			return d.typeError(fmt.Sprintf("list of more than %d elements", v.Len()), v.Type(), start)
+		}
+		if err != nil {
+			return err
+		}
+		i++
+	}
+	d.field = field
+
+	switch {
+	case v.Kind() == reflect.Slice && i < v.Len():
+		v.SetLen(i)
+	case v.Kind() == reflect.Slice && v.IsNil():
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	case v.Kind() == reflect.Array && i < v.Len():
+		return d.typeError(fmt.Sprintf("list of %d elements", i), v.Type(), start)
+	}
+
+	return nil
+}
+
+func (d *Decoder) dictValue(v reflect.Value) error {
+	start := d.off
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	case v.Kind() == reflect.Struct:
+	default:
+		return d.typeError("dictionary", v.Type(), start)
+	}
+
+	if err := d.enter(); err != nil {
+		return err
+	}
+	defer d.leave()
+
+	d.advance()
+	field := d.field
+	for {
+		key, end, err := d.key()
+		if err != nil {
+			return err
+		}
+
+		if end {
+			break
+		}
+
+		d.field = key
+		if len(field) > 0 {
+			d.field = field + "." + key
+		}
+
+		if v.Kind() == reflect.Map {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err = d.value(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+			continue
+		}
+
+		fields := cachedFields(v.Type())
+		f, ok := fields.byKey(key)
+		if !ok && fields.remain != nil {
+			remain := v.FieldByIndex(fields.remain)
+			if remain.IsNil() {
+				remain.Set(reflect.MakeMap(remain.Type()))
+			}
+
+			elem := reflect.New(remain.Type().Elem()).Elem()
+			if err = d.value(elem); err != nil {
+				return err
+			}
+			remain.SetMapIndex(reflect.ValueOf(key).Convert(remain.Type().Key()), elem)
+			continue
+		}
+
+		if !ok {
+			if err = d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = d.value(v.FieldByIndex(f.index)); err != nil {
+			return err
+		}
+	}
+	d.field = field
+
+	return nil
+}
@@ -0,0 +1,351 @@
+package bencode_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+)
+
+type testFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+type testTorrent struct {
+	Announce string             `bencode:"announce"`
+	Info     bencode.RawMessage `bencode:"info"`
+	Private  bool               `bencode:"private"`
+	Files    []testFile         `bencode:"files"`
+	Hash     [4]byte            `bencode:"hash"`
+	Ignored  string             `bencode:"-"`
+	Comment  string
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		output interface{}
+		fails  bool
+	}{
+		{
+			name:   "integer",
+			input:  "i-42e",
+			output: int64(-42),
+		},
+		{
+			name:   "string",
+			input:  "4:spam",
+			output: "spam",
+		},
+		{
+			name:   "list",
+			input:  "l4:spami42ee",
+			output: []interface{}{"spam", int64(42)},
+		},
+		{
+			name:   "dictionary",
+			input:  "d3:cow3:moo4:spaml1:a1:bee",
+			output: map[string]interface{}{"cow": "moo", "spam": []interface{}{"a", "b"}},
+		},
+		{
+			name:   "empty list and dictionary",
+			input:  "lledee",
+			output: []interface{}{[]interface{}{}, map[string]interface{}{}},
+		},
+		{
+			name:  "error on leading zero",
+			input: "i03e",
+			fails: true,
+		},
+		{
+			name:  "error on negative zero",
+			input: "i-0e",
+			fails: true,
+		},
+		{
+			name:  "error on empty integer",
+			input: "ie",
+			fails: true,
+		},
+		{
+			name:  "error on unterminated list",
+			input: "l4:spam",
+			fails: true,
+		},
+		{
+			name:  "error on string exceeding input",
+			input: "10:spam",
+			fails: true,
+		},
+		{
+			name:  "error on non string key",
+			input: "di1ei2ee",
+			fails: true,
+		},
+		{
+			name:  "error on trailing data",
+			input: "i1ei2e",
+			fails: true,
+		},
+		{
+			name:  "error on empty input",
+			input: "",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got interface{}
+			err := bencode.Unmarshal([]byte(tc.input), &got)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestUnmarshal_Struct(t *testing.T) {
+	t.Parallel()
+
+	// keys are not sorted and the raw info dictionary is kept as is
+	input := "d7:Comment2:hi8:announce3:url4:infod4:name1:b4:name1:ae5:filesld6:lengthi10e4:pathl1:a1:beee7:privatei1e7:unknownli1ee4:hash4:abcd1:-1:xe"
+
+	var got testTorrent
+	if err := bencode.Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := testTorrent{
+		Announce: "url",
+		Info:     bencode.RawMessage("d4:name1:b4:name1:ae"),
+		Private:  true,
+		Files:    []testFile{{Length: 10, Path: []string{"a", "b"}}},
+		Hash:     [4]byte{'a', 'b', 'c', 'd'},
+		Comment:  "hi",
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestUnmarshal_Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		target interface{}
+		offset int64
+		err    error
+	}{
+		{
+			name:   "type mismatch reports the field and offset",
+			input:  "d5:filesld6:length3:fooeee",
+			target: &testTorrent{},
+			offset: 18,
+		},
+		{
+			name:   "overflowing integer",
+			input:  "i300e",
+			target: new(uint8),
+			offset: 0,
+		},
+		{
+			name:   "byte array of the wrong length",
+			input:  "3:abc",
+			target: new([4]byte),
+			offset: 0,
+		},
+		{
+			name:   "invalid integer",
+			input:  "li1ei01ee",
+			target: new(interface{}),
+			offset: 4,
+		},
+		{
+			name:   "unexpected end of input",
+			input:  "l4:spam",
+			target: new(interface{}),
+			offset: 7,
+			err:    io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := bencode.Unmarshal([]byte(tc.input), tc.target)
+
+			var syntaxErr *bencode.SyntaxError
+			var typeErr *bencode.UnmarshalTypeError
+			switch {
+			case errors.As(err, &syntaxErr):
+				if syntaxErr.Offset != tc.offset {
+					t.Errorf("want offset %d, got %d: %v", tc.offset, syntaxErr.Offset, err)
+				}
+			case errors.As(err, &typeErr):
+				if typeErr.Offset != tc.offset {
+					t.Errorf("want offset %d, got %d: %v", tc.offset, typeErr.Offset, err)
+				}
+			default:
+				t.Fatalf("want syntax or type error, got %v", err)
+			}
+
+			if tc.err != nil && !errors.Is(err, tc.err) {
+				t.Errorf("want %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestUnmarshal_TypeErrorField(t *testing.T) {
+	t.Parallel()
+
+	err := bencode.Unmarshal([]byte("d5:filesld6:length3:fooeee"), &testTorrent{})
+
+	var typeErr *bencode.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("want type error, got %v", err)
+	}
+
+	if typeErr.Field != "files[0].length" {
+		t.Errorf("want field files[0].length, got %s", typeErr.Field)
+	}
+}
+
+func TestDecoder_Limits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		input    string
+		maxDepth int
+		maxSize  int64
+		err      error
+	}{
+		{
+			name:     "nesting too deep",
+			input:    strings.Repeat("l", 5) + strings.Repeat("e", 5),
+			maxDepth: 4,
+			maxSize:  bencode.DefaultMaxSize,
+			err:      bencode.ErrMaxDepth,
+		},
+		{
+			name:     "string longer than the maximum size is rejected before reading",
+			input:    "999999999999:",
+			maxDepth: bencode.DefaultMaxDepth,
+			maxSize:  1024,
+			err:      bencode.ErrMaxSize,
+		},
+		{
+			name:     "value larger than the maximum size",
+			input:    "l" + strings.Repeat("i1e", 10) + "e",
+			maxDepth: bencode.DefaultMaxDepth,
+			maxSize:  16,
+			err:      bencode.ErrMaxSize,
+		},
+		{
+			name:     "within limits",
+			input:    "llee",
+			maxDepth: 2,
+			maxSize:  4,
+			err:      nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := bencode.NewDecoder(strings.NewReader(tc.input))
+			d.MaxDepth = tc.maxDepth
+			d.MaxSize = tc.maxSize
+
+			var v interface{}
+			if err := d.Decode(&v); !errors.Is(err, tc.err) {
+				t.Errorf("want %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestDecoder_Stream(t *testing.T) {
+	t.Parallel()
+
+	input := []byte("d1:ai1ee4:rest")
+	d := bencode.NewDecoder(bytes.NewReader(input))
+
+	var v map[string]int
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	if rest := input[d.InputOffset():]; string(rest) != "4:rest" {
+		t.Errorf("want rest 4:rest, got %q", rest)
+	}
+
+	var s string
+	if err := d.Decode(&s); err != nil || s != "rest" {
+		t.Errorf("want rest, got %q, %v", s, err)
+	}
+
+	if err := d.Decode(&s); !errors.Is(err, io.EOF) {
+		t.Errorf("want EOF, got %v", err)
+	}
+}
+
+func TestUnmarshal_Remain(t *testing.T) {
+	t.Parallel()
+
+	type dict struct {
+		Name  string                        `bencode:"name"`
+		Extra map[string]bencode.RawMessage `bencode:",remain"`
+	}
+
+	input := "d1:ai1e4:name4:spam1:zl1:xee"
+
+	var got dict
+	if err := bencode.Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := dict{
+		Name: "spam",
+		Extra: map[string]bencode.RawMessage{
+			"a": bencode.RawMessage("i1e"),
+			"z": bencode.RawMessage("l1:xe"),
+		},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	// the remaining keys are written back in order, the fields take precedence
+	got.Extra["name"] = bencode.RawMessage("3:egg")
+	data, err := bencode.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != input {
+		t.Errorf("want %q, got %q", input, data)
+	}
+}
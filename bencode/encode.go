@@ -0,0 +1,189 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	marshalerType = reflect.TypeFor[Marshaler]()
+)
+
+// Encoder writes bencoded values to a stream
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w}
+}
+
+// Encode writes the encoding of v
+func (e *Encoder) Encode(v interface{}) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+// Marshal returns the encoding of v. Dictionary keys are written sorted by
+// their raw bytes, floats, channels, functions and nil values are not supported
+func Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil value")
+	}
+
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+
+		data, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return err
+		}
+
+		// a marshaler must produce exactly one valid value
+		if err = Unmarshal(data, &RawMessage{}); err != nil {
+			return fmt.Errorf("bencode: invalid output of MarshalBencode for %s: %w", v.Type(), err)
+		}
+
+		buf.Write(data)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		return encode(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			writeString(buf, string(b))
+			return nil
+		}
+
+		buf.WriteByte('l')
+		for i := range v.Len() {
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return &UnsupportedTypeError{v.Type()}
+		}
+
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			writeString(buf, k.String())
+			if err := encode(buf, v.MapIndex(k)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		fields := cachedFields(v.Type())
+
+		type entry struct {
+			key   string
+			value reflect.Value
+		}
+
+		var entries []entry
+		for _, f := range fields.list {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && isEmpty(fv) {
+				continue
+			}
+
+			entries = append(entries, entry{f.name, fv})
+		}
+
+		// the fields take precedence over the remaining keys
+		if fields.remain != nil {
+			remain := v.FieldByIndex(fields.remain)
+			for _, k := range remain.MapKeys() {
+				if _, ok := fields.byKey(k.String()); !ok {
+					entries = append(entries, entry{k.String(), remain.MapIndex(k)})
+				}
+			}
+
+			slices.SortFunc(entries, func(a, b entry) int {
+				return strings.Compare(a.key, b.key)
+			})
+		}
+
+		buf.WriteByte('d')
+		for _, e := range entries {
+			writeString(buf, e.key)
+			if err := encode(buf, e.value); err != nil {
+				return fmt.Errorf("field %s: %w", e.key, err)
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return &UnsupportedTypeError{v.Type()}
+	}
+
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String, reflect.Array:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
@@ -0,0 +1,86 @@
+package bencode_test
+
+import (
+	"testing"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	type omit struct {
+		Name    string   `bencode:"name"`
+		Comment string   `bencode:"comment,omitempty"`
+		List    []string `bencode:"list,omitempty"`
+	}
+
+	cases := []struct {
+		name   string
+		input  interface{}
+		output string
+		fails  bool
+	}{
+		{
+			name:   "scalars",
+			input:  []interface{}{42, -1, uint8(7), "spam", []byte("ab"), true, false},
+			output: "li42ei-1ei7e4:spam2:abi1ei0ee",
+		},
+		{
+			name:   "map keys are sorted",
+			input:  map[string]interface{}{"b": 1, "a": map[string]string{"d": "x", "c": "y"}},
+			output: "d1:ad1:c1:y1:d1:xe1:bi1ee",
+		},
+		{
+			name: "struct fields are sorted by key",
+			input: testTorrent{
+				Announce: "url",
+				Info:     bencode.RawMessage("d4:name1:ae"),
+				Files:    []testFile{{Length: 1, Path: []string{"a"}}},
+				Hash:     [4]byte{'a', 'b', 'c', 'd'},
+				Ignored:  "ignored",
+			},
+			output: "d7:Comment0:8:announce3:url5:filesld6:lengthi1e4:pathl1:aeee4:hash4:abcd4:infod4:name1:ae7:privatei0ee",
+		},
+		{
+			name:   "empty fields are omitted",
+			input:  &omit{Name: "a"},
+			output: "d4:name1:ae",
+		},
+		{
+			name:  "error on invalid raw message",
+			input: bencode.RawMessage("d4:name"),
+			fails: true,
+		},
+		{
+			name:  "error on float",
+			input: 1.5,
+			fails: true,
+		},
+		{
+			name:  "error on nil",
+			input: []interface{}{nil},
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := bencode.Marshal(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tc.output {
+				t.Errorf("want %q, got %q", tc.output, got)
+			}
+		})
+	}
+}
@@ -1,15 +1,16 @@
 package client
 
 import (
-	"bytes"
-	"crypto/sha1"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/kanowfy/btor/handshake"
 	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/metainfo"
 	"github.com/kanowfy/btor/peers"
 )
 
@@ -28,12 +29,18 @@ type Client struct {
 	recvBitfield bool
 	choke        bool
 	logger       *slog.Logger
+	ext          extensionState
+	// port is the port we listen on, 0 when not listening
+	port uint16
+	// incoming is set when the peer connected to us, the port of its address
+	// is then not the one it listens on
+	incoming  bool
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 type PieceTask struct {
-	Index  int
-	Hash   []byte
-	Length int
+	metainfo.Piece
 }
 
 type PieceResult struct {
@@ -52,46 +59,112 @@ type pieceState struct {
 
 // New establish tcp connection with a peer and complete the handshake
 func New(logger *slog.Logger, peer peers.Peer, infoHash, peerID []byte) (*Client, error) {
-	logger = logger.With(slog.String("peer_addr", fmt.Sprintf("%s:%d", peer.IP, peer.Port)))
+	return dial(logger, peer, infoHash, peerID, nil)
+}
+
+// dial connects to a peer, the connections of a swarm advertise its extensions
+// and use its address family
+func dial(logger *slog.Logger, peer peers.Peer, infoHash, peerID []byte, swarm *Swarm) (*Client, error) {
+	logger = logger.With(slog.String("peer_addr", peer.Addr()))
+
+	network := "tcp"
+	if swarm != nil {
+		network = swarm.Family.Network(network)
+	}
 
 	logger.Info("establishing connection with peer")
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", peer.IP, peer.Port), 3*time.Second)
+	conn, err := net.DialTimeout(network, peer.Addr(), 3*time.Second)
 	if err != nil {
 		logger.Error("failed to establish connection with peer", "error", err)
 		return nil, err
 	}
 
+	// peers given by host name are only known to be blocked once resolved
+	if swarm != nil {
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+			if ip, ok := netip.AddrFromSlice(addr.IP); ok && swarm.Filter.Reject(ip) {
+				conn.Close()
+				return nil, fmt.Errorf("peer %s is blocked by the ip filter", peer.Addr())
+			}
+		}
+	}
+
 	logger.Info("performing handshake with peer")
-	_, err = handshake.InitHandshake(conn, infoHash, peerID)
+	reply, err := handshake.ExchangeWithOptions(conn, newHandshake(infoHash, peerID), handshakeOptions(peer))
 	if err != nil {
 		logger.Error("failed to complete handshake with peer", "error", err)
 		conn.Close()
 		return nil, err
 	}
 
-	msg, err := readFirstMsg(conn)
+	return newSession(logger, conn, peer, infoHash, peerID, swarm, reply)
+}
+
+// newSession starts the session of a connection which completed the
+// handshake, whichever side initiated it
+func newSession(logger *slog.Logger, conn net.Conn, peer peers.Peer, infoHash, peerID []byte, swarm *Swarm, reply *handshake.Handshake) (*Client, error) {
+	c := &Client{
+		conn:     conn,
+		peer:     peer,
+		infoHash: infoHash,
+		peerID:   peerID,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+	var extensions *Extensions
+	if swarm != nil {
+		extensions = swarm.Extensions
+		c.port = swarm.Port
+	}
+	c.ext.handlers, c.ext.m = extensions.handlers()
+
+	if reply.SupportsExtensionProtocol() {
+		if err := c.sendExtensionHandshake(); err != nil {
+			logger.Error("failed to send extension handshake to peer", "error", err)
+			c.Close()
+			return nil, err
+		}
+	}
+
+	msg, err := c.readFirstMsg()
 	if err != nil {
 		logger.Error("failed to read message from peer", "error", err)
+		c.Close()
 		return nil, err
 	}
 
-	var bitfield message.Bitfield
-	var recvBitfield bool
 	if msg.ID == message.MessageBitfield {
-		bitfield = message.Bitfield(msg.Payload)
-		recvBitfield = true
+		c.bitfield = message.Bitfield(msg.Payload)
+		c.recvBitfield = true
+	}
+
+	return c, nil
+}
+
+// newHandshake creates our handshake, advertising the extension protocol
+func newHandshake(infoHash, peerID []byte) *handshake.Handshake {
+	h := handshake.New(infoHash, peerID)
+	h.EnableExtensionProtocol()
+	return h
+}
+
+// handshakeOptions verifies the peer id of a peer given by a tracker and bounds the exchange
+func handshakeOptions(peer peers.Peer) handshake.ExchangeOptions {
+	opts := handshake.ExchangeOptions{Timeout: handshake.DefaultTimeout}
+	if len(peer.ID) == 20 {
+		opts.PeerID = []byte(peer.ID)
 	}
 
-	return &Client{
-		conn,
-		peer,
-		infoHash,
-		peerID,
-		bitfield,
-		recvBitfield,
-		false,
-		logger,
-	}, nil
+	return opts
+}
+
+// Close closes the connection with the peer
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	return c.conn.Close()
 }
 
 func StartDownloadClient(logger *slog.Logger, peer peers.Peer, infoHash, peerID []byte, taskStream chan PieceTask, resultStream chan<- PieceResult) {
@@ -100,6 +173,13 @@ func StartDownloadClient(logger *slog.Logger, peer peers.Peer, infoHash, peerID
 		logger.Error(err.Error())
 		return
 	}
+	defer c.Close()
+
+	c.download(taskStream, resultStream)
+}
+
+// download downloads the pieces of taskStream the peer has until taskStream is closed
+func (c *Client) download(taskStream chan PieceTask, resultStream chan<- PieceResult) {
 	// send interested
 	if err := c.sendInterested(); err != nil {
 		c.logger.Error("failed to send interested message to peer", "error", err)
@@ -132,7 +212,7 @@ func StartDownloadClient(logger *slog.Logger, peer peers.Peer, infoHash, peerID
 		}
 
 		// check hash
-		if err := matchPieceHash(piece, pt.Hash); err != nil {
+		if err := pt.Verify(piece); err != nil {
 			c.logger.Error("failed to validate piece hash", "error", err)
 			taskStream <- pt
 			continue
@@ -202,6 +282,8 @@ func (state *pieceState) readMessage() error {
 
 		state.client.bitfield.SetPieceIndex(index)
 		// to be implemented
+	case message.MessageExtended:
+		return state.client.handleExtended(msg)
 	case message.MessagePiece:
 		got, err := message.ParsePiece(msg, state.buf, state.index)
 		if err != nil {
@@ -223,17 +305,27 @@ func CalculatePieceLength(pieceIndex, maxPieceLen, fileLen int) int {
 	return fileLen % maxPieceLen
 }
 
-func readFirstMsg(conn net.Conn) (*message.Message, error) {
-	msg, err := message.Read(conn)
-	if err != nil {
-		return nil, err
-	}
+// readFirstMsg reads the first message of the peer, handling the extension
+// protocol messages which may come before it
+func (c *Client) readFirstMsg() (*message.Message, error) {
+	for {
+		msg, err := message.Read(c.conn)
+		if err != nil {
+			return nil, err
+		}
 
-	if msg == nil {
-		return nil, fmt.Errorf("expected message but got nothing")
-	}
+		if msg == nil {
+			return nil, fmt.Errorf("expected message but got nothing")
+		}
 
-	return msg, nil
+		if msg.ID != message.MessageExtended {
+			return msg, nil
+		}
+
+		if err = c.handleExtended(msg); err != nil {
+			return nil, err
+		}
+	}
 }
 
 func (c *Client) sendInterested() error {
@@ -253,10 +345,25 @@ func (c *Client) readUnchoke() error {
 		return fmt.Errorf("expected unchoke message but got nothing")
 	}
 
-	if msg.ID != message.MessageUnchoke {
+	// the extension protocol messages and haves may come before the unchoke
+	switch msg.ID {
+	case message.MessageUnchoke:
+		return nil
+	case message.MessageExtended:
+		if err = c.handleExtended(msg); err != nil {
+			return err
+		}
+		return c.readUnchoke()
+	case message.MessageHave:
+		index, err := message.ParseHave(msg)
+		if err != nil {
+			return err
+		}
+		c.bitfield.SetPieceIndex(index)
+		return c.readUnchoke()
+	default:
 		return fmt.Errorf("expected unchoke message but got message with ID %d", msg.ID)
 	}
-	return nil
 }
 
 func (c *Client) sendRequest(pieceIndex, offset, pieceLength int) error {
@@ -270,15 +377,3 @@ func (c *Client) sendHave(pieceIndex int) error {
 	_, err := c.conn.Write(msg.Serialize())
 	return err
 }
-
-func matchPieceHash(piece []byte, hash []byte) error {
-	var hashed = sha1.New()
-	hashed.Write(piece)
-	checksum := hashed.Sum(nil)
-
-	if !bytes.Equal(checksum, hash) {
-		return fmt.Errorf("mismatch piece hash, want %x, got %x", hash, checksum)
-	}
-
-	return nil
-}
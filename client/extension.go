@@ -0,0 +1,194 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/kanowfy/btor/message"
+)
+
+// ClientName is the name we give peers in the extension handshake
+const ClientName = "btor"
+
+var (
+	ErrExtensionRegistered  = errors.New("extension already registered")
+	ErrExtensionUnsupported = errors.New("peer does not support extension")
+)
+
+// ExtensionHandler handles an extension of the extension protocol on a connection
+type ExtensionHandler interface {
+	// Start is called with the extension handshake of the peer when it
+	// advertises the extension, it may be called again if the peer sends
+	// another handshake
+	Start(c *Client, hs *message.ExtendedHandshake)
+	// Handle is called with the payload of every message of the extension
+	// sent by the peer
+	Handle(c *Client, payload []byte) error
+}
+
+// ExtensionFactory creates the handler of an extension for a new connection
+type ExtensionFactory func() ExtensionHandler
+
+// Extensions is a registry of extensions by name, such as ut_pex. The
+// connections using it advertise its extensions in their extension handshake
+// and receive their messages with extended message IDs assigned in the order
+// of registration
+type Extensions struct {
+	mu        sync.RWMutex
+	names     []string
+	factories map[string]ExtensionFactory
+}
+
+func NewExtensions() *Extensions {
+	return &Extensions{
+		factories: make(map[string]ExtensionFactory),
+	}
+}
+
+// Register registers the factory creating the handler of an extension on
+// each connection
+func (e *Extensions) Register(name string, factory ExtensionFactory) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if len(name) == 0 {
+		return fmt.Errorf("extension name must not be empty")
+	}
+
+	if _, ok := e.factories[name]; ok {
+		return fmt.Errorf("%w: %s", ErrExtensionRegistered, name)
+	}
+
+	// the extended message ID 0 is the handshake
+	if len(e.names) == 255 {
+		return fmt.Errorf("too many extensions")
+	}
+
+	e.names = append(e.names, name)
+	e.factories[name] = factory
+	return nil
+}
+
+// Names returns the names of the registered extensions in the order of registration
+func (e *Extensions) Names() []string {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return append([]string(nil), e.names...)
+}
+
+// handlers creates the handlers of a new connection keyed by our extended
+// message ID, along with the m dictionary advertising them
+func (e *Extensions) handlers() (map[byte]ExtensionHandler, map[string]int) {
+	handlers := make(map[byte]ExtensionHandler)
+	m := make(map[string]int)
+	if e == nil {
+		return handlers, m
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for i, name := range e.names {
+		id := byte(i + 1)
+		handlers[id] = e.factories[name]()
+		m[name] = int(id)
+	}
+
+	return handlers, m
+}
+
+// extensionState is the extension protocol state of a connection
+type extensionState struct {
+	mu       sync.RWMutex
+	handlers map[byte]ExtensionHandler
+	m        map[string]int
+	// peer is the last extension handshake of the peer, nil until received
+	peer *message.ExtendedHandshake
+}
+
+// sendExtensionHandshake tells the peer about the extensions we support, as described in BEP 10
+func (c *Client) sendExtensionHandshake() error {
+	hs := &message.ExtendedHandshake{
+		M: c.ext.m,
+		V: ClientName,
+		P: int(c.port),
+	}
+
+	if addr, ok := c.conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip := addr.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		hs.YourIP = string(ip)
+	}
+
+	msg, err := message.NewExtendedHandshake(hs)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Write(msg.Serialize())
+	return err
+}
+
+// handleExtended handles a message of the extension protocol
+func (c *Client) handleExtended(msg *message.Message) error {
+	extID, payload, err := message.ParseExtended(msg)
+	if err != nil {
+		return err
+	}
+
+	if extID == message.ExtendedHandshakeID {
+		hs, err := message.ParseExtendedHandshake(msg)
+		if err != nil {
+			return err
+		}
+
+		c.ext.mu.Lock()
+		c.ext.peer = hs
+		c.ext.mu.Unlock()
+
+		for name, id := range c.ext.m {
+			if hs.M[name] > 0 {
+				c.ext.handlers[byte(id)].Start(c, hs)
+			}
+		}
+
+		return nil
+	}
+
+	// the messages of extensions we did not advertise are ignored
+	if h, ok := c.ext.handlers[extID]; ok {
+		return h.Handle(c, payload)
+	}
+
+	return nil
+}
+
+// PeerExtensions returns the last extension handshake of the peer, nil when
+// it did not send any
+func (c *Client) PeerExtensions() *message.ExtendedHandshake {
+	c.ext.mu.RLock()
+	defer c.ext.mu.RUnlock()
+
+	return c.ext.peer
+}
+
+// SendExtended sends the payload of a message of an extension, with the
+// extended message ID the peer advertised for it
+func (c *Client) SendExtended(name string, payload []byte) error {
+	hs := c.PeerExtensions()
+	if hs == nil || hs.M[name] <= 0 {
+		return fmt.Errorf("%w: %s", ErrExtensionUnsupported, name)
+	}
+
+	_, err := c.conn.Write(message.NewExtended(byte(hs.M[name]), payload).Serialize())
+	return err
+}
@@ -0,0 +1,189 @@
+package client_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/peers"
+)
+
+// echoExtension replies to the peer with the payload of its messages
+type echoExtension struct {
+	started chan string
+}
+
+func (e *echoExtension) Start(c *client.Client, hs *message.ExtendedHandshake) {
+	e.started <- hs.V
+}
+
+func (e *echoExtension) Handle(c *client.Client, payload []byte) error {
+	return c.SendExtended("lt_echo", payload)
+}
+
+func TestExtensions_Register(t *testing.T) {
+	t.Parallel()
+
+	e := client.NewExtensions()
+	if err := e.Register("lt_a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Register("lt_b", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Register("lt_a", nil); !errors.Is(err, client.ErrExtensionRegistered) {
+		t.Errorf("want %v, got %v", client.ErrExtensionRegistered, err)
+	}
+
+	if err := e.Register("", nil); err == nil {
+		t.Error("expect error on empty name, got nil")
+	}
+
+	want := []string{"lt_a", "lt_b"}
+	if got := e.Names(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSwarm_Extensions(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the peer advertises lt_echo with its own ID and reads back the echoed payload
+	const peerEchoID = 7
+	type result struct {
+		m    map[string]int
+		echo []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var r result
+		r.m, r.echo, r.err = serveEcho(conn, peerEchoID)
+		results <- r
+	}()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	taskStream := make(chan client.PieceTask)
+	defer close(taskStream)
+
+	swarm := client.NewSwarm(logger, []byte("aaaaaaaaaaaaaaaaaaaa"), make([]byte, 20), true, taskStream, make(chan client.PieceResult))
+	defer swarm.Close()
+
+	ext := &echoExtension{started: make(chan string, 1)}
+	if err := swarm.Extensions.Register("lt_echo", func() client.ExtensionHandler { return ext }); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ln.Addr().(*net.TCPAddr)
+	swarm.Add([]peers.Peer{{IP: addr.IP.String(), Port: uint16(addr.Port)}})
+
+	select {
+	case v := <-ext.started:
+		if v != "test" {
+			t.Errorf("want peer client name test, got %q", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the extension to start")
+	}
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+
+		// ut_pex is not registered for private torrents
+		wantM := map[string]int{"lt_echo": 1}
+		if !cmp.Equal(wantM, r.m) {
+			t.Error(cmp.Diff(wantM, r.m))
+		}
+
+		if want := []byte("ping"); !cmp.Equal(want, r.echo) {
+			t.Error(cmp.Diff(want, r.echo))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the echoed message")
+	}
+}
+
+// serveEcho completes the handshakes with the client, sends a lt_echo message
+// and returns the extensions of the client and the payload it echoed
+func serveEcho(conn net.Conn, echoID byte) (map[string]int, []byte, error) {
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, nil, err
+	}
+
+	reply := handshake.New(buf[28:48], []byte("-XX0000-000000000000"))
+	reply.EnableExtensionProtocol()
+	if _, err := conn.Write(reply.Serialize()); err != nil {
+		return nil, nil, err
+	}
+
+	msg, err := message.Read(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hs, err := message.ParseExtendedHandshake(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ours, err := message.NewExtendedHandshake(&message.ExtendedHandshake{
+		M: map[string]int{"lt_echo": int(echoID)},
+		V: "test",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the message is sent with the ID the client advertised
+	ping := message.NewExtended(byte(hs.M["lt_echo"]), []byte("ping"))
+	bitfield := message.New(message.MessageBitfield, []byte{0})
+	for _, m := range []*message.Message{ours, ping, bitfield} {
+		if _, err := conn.Write(m.Serialize()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for {
+		msg, err := message.Read(conn)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if msg == nil || msg.ID != message.MessageExtended {
+			continue
+		}
+
+		id, payload, err := message.ParseExtended(msg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if id == echoID {
+			return hs.M, payload, nil
+		}
+	}
+}
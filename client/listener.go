@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/ipfilter"
+	"github.com/kanowfy/btor/peers"
+)
+
+// DefaultMaxIncoming is the default number of incoming connections a listener keeps at once
+const DefaultMaxIncoming = 50
+
+// Listener accepts the incoming peer connections. It reads the handshake of
+// each connection and hands the connection to the swarm of its info hash,
+// which carries on like with the peers it connected to
+type Listener struct {
+	logger *slog.Logger
+	ln     net.Listener
+
+	// MaxConns bounds the incoming connections at once, the connections
+	// beyond it are closed right away
+	MaxConns int
+	// Filter rejects the connections from the addresses of its ranges
+	Filter *ipfilter.Filter
+
+	mu     sync.Mutex
+	swarms map[string]*Swarm
+	conns  int
+}
+
+// NewListener creates a listener accepting connections from ln
+func NewListener(logger *slog.Logger, ln net.Listener) *Listener {
+	return &Listener{
+		logger:   logger,
+		ln:       ln,
+		MaxConns: DefaultMaxIncoming,
+		swarms:   make(map[string]*Swarm),
+	}
+}
+
+// Listen listens for peers on a TCP port of the address family, a random
+// port is used when port is 0
+func Listen(logger *slog.Logger, family peers.Family, port uint16) (*Listener, error) {
+	ln, err := net.Listen(family.Network("tcp"), fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, err
+	}
+
+	return NewListener(logger, ln), nil
+}
+
+// Port returns the port the listener accepts connections on
+func (l *Listener) Port() uint16 {
+	if addr, ok := l.ln.Addr().(*net.TCPAddr); ok {
+		return uint16(addr.Port)
+	}
+
+	return 0
+}
+
+// Add routes the connections for the info hash of a swarm to it
+func (l *Listener) Add(s *Swarm) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.swarms[string(s.infoHash)] = s
+}
+
+// Remove stops routing connections to a swarm
+func (l *Listener) Remove(s *Swarm) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.swarms[string(s.infoHash)] == s {
+		delete(l.swarms, string(s.infoHash))
+	}
+}
+
+// Serve accepts connections until the listener is closed
+func (l *Listener) Serve() error {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		if !l.acquire(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+
+		go l.handle(&incomingConn{Conn: conn, release: l.release})
+	}
+}
+
+// Close stops accepting connections, the accepted ones stay with their swarm
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+// acquire takes a connection slot for an address which is not blocked
+func (l *Listener) acquire(addr net.Addr) bool {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(tcpAddr.IP); ok && l.Filter.Reject(ip) {
+			l.logger.Info("peer blocked by ip filter", slog.String("peer_addr", addr.String()))
+			return false
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conns >= l.MaxConns {
+		l.logger.Info("too many incoming connections", slog.String("peer_addr", addr.String()))
+		return false
+	}
+	l.conns++
+
+	return true
+}
+
+func (l *Listener) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.conns--
+}
+
+// handle reads the handshake of a connection and hands it to the swarm of its info hash
+func (l *Listener) handle(conn net.Conn) {
+	logger := l.logger.With(slog.String("peer_addr", conn.RemoteAddr().String()))
+
+	if err := conn.SetDeadline(time.Now().Add(handshake.DefaultTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+
+	hs, err := handshake.Read(conn)
+	if err != nil {
+		logger.Error("failed to read handshake from peer", "error", err)
+		conn.Close()
+		return
+	}
+
+	l.mu.Lock()
+	s := l.swarms[string(hs.InfoHash)]
+	l.mu.Unlock()
+
+	if s == nil {
+		logger.Info("peer asked for an unknown torrent", slog.String("info_hash", fmt.Sprintf("%x", hs.InfoHash)))
+		conn.Close()
+		return
+	}
+
+	if bytes.Equal(hs.PeerID, s.peerID) {
+		logger.Info("closing connection", "error", handshake.ErrSelfConnection)
+		conn.Close()
+		return
+	}
+
+	s.accept(conn, hs)
+}
+
+// incomingConn frees its connection slot of the listener once closed
+type incomingConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *incomingConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
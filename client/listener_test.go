@@ -0,0 +1,187 @@
+package client_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/ipfilter"
+	"github.com/kanowfy/btor/message"
+)
+
+// startListener serves a listener on the loopback routing to a swarm of
+// infoHash, once set up by configure
+func startListener(t *testing.T, infoHash, peerID []byte, configure func(*client.Listener)) (*client.Listener, *client.Swarm) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	l := client.NewListener(logger, ln)
+	configure(l)
+	go l.Serve()
+	t.Cleanup(func() { l.Close() })
+
+	taskStream := make(chan client.PieceTask)
+	t.Cleanup(func() { close(taskStream) })
+
+	s := client.NewSwarm(logger, infoHash, peerID, true, taskStream, make(chan client.PieceResult))
+	t.Cleanup(s.Close)
+	s.Port = l.Port()
+	l.Add(s)
+
+	return l, s
+}
+
+// dialListener connects to a listener and sends a handshake
+func dialListener(t *testing.T, l *client.Listener, infoHash, peerID []byte) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(l.Port()))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	h := handshake.New(infoHash, peerID)
+	h.EnableExtensionProtocol()
+	if _, err := conn.Write(h.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+	l, swarm := startListener(t, infoHash, make([]byte, 20), func(*client.Listener) {})
+
+	conn := dialListener(t, l, infoHash, []byte("-XX0000-000000000000"))
+
+	reply, err := handshake.Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reply.SupportsExtensionProtocol() {
+		t.Error("want the reply to support the extension protocol")
+	}
+
+	msg, err := message.Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := message.ParseExtendedHandshake(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hs.P != int(l.Port()) {
+		t.Errorf("want advertised port %d, got %d", l.Port(), hs.P)
+	}
+
+	if _, err := conn.Write(message.New(message.MessageBitfield, []byte{0}).Serialize()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peer is connected once the session started
+	deadline := time.Now().Add(5 * time.Second)
+	for len(swarm.Connected()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the incoming peer to be connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	got := swarm.Connected()[0]
+	if got.IP != "127.0.0.1" || got.ID != "-XX0000-000000000000" {
+		t.Errorf("unexpected connected peer %+v", got)
+	}
+}
+
+func TestListener_Rejects(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+	peerID := make([]byte, 20)
+
+	cases := []struct {
+		name     string
+		infoHash []byte
+		peerID   []byte
+		filter   string
+		maxConns int
+	}{
+		{
+			name:     "unknown info hash",
+			infoHash: []byte("bbbbbbbbbbbbbbbbbbbb"),
+			peerID:   []byte("-XX0000-000000000000"),
+			maxConns: client.DefaultMaxIncoming,
+		},
+		{
+			name:     "connection to ourselves",
+			infoHash: infoHash,
+			peerID:   peerID,
+			maxConns: client.DefaultMaxIncoming,
+		},
+		{
+			name:     "blocked address",
+			infoHash: infoHash,
+			peerID:   []byte("-XX0000-000000000000"),
+			filter:   "127.0.0.0/8\n",
+			maxConns: client.DefaultMaxIncoming,
+		},
+		{
+			name:     "too many connections",
+			infoHash: infoHash,
+			peerID:   []byte("-XX0000-000000000000"),
+			maxConns: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var filter *ipfilter.Filter
+			if len(tc.filter) > 0 {
+				var err error
+				filter, err = ipfilter.Parse(strings.NewReader(tc.filter))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, _ := startListener(t, infoHash, peerID, func(l *client.Listener) {
+				l.MaxConns = tc.maxConns
+				l.Filter = filter
+			})
+
+			conn := dialListener(t, l, tc.infoHash, tc.peerID)
+
+			// the connection is closed without a reply
+			_, err := handshake.Read(conn)
+			if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+				t.Errorf("want the connection closed, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/peers"
+)
+
+// metadata exchange as described in BEP 9
+const (
+	MetadataPieceLen = 16384 // 2^14
+	// MaxMetadataSize bounds the info dictionary size a peer can make us allocate
+	MaxMetadataSize = 1 << 24
+
+	// utMetadataID is the extended message ID we ask peers to use for ut_metadata messages
+	utMetadataID byte = 1
+
+	metadataRequest = 0
+	metadataData    = 1
+	metadataReject  = 2
+
+	metadataTimeout = 30 * time.Second
+)
+
+var (
+	ErrMetadataUnsupported = errors.New("peer does not support metadata exchange")
+	ErrMetadataRejected    = errors.New("peer rejected metadata request")
+)
+
+type metadataMessage struct {
+	MsgType   int `bencode:"msg_type"`
+	Piece     int `bencode:"piece"`
+	TotalSize int `bencode:"total_size"`
+}
+
+// FetchMetadata connects to a peer and downloads the info dictionary of the
+// torrent identified by infoHash using the ut_metadata extension. The returned
+// info dictionary is verified against infoHash. The peer is reached with the
+// address family
+func FetchMetadata(logger *slog.Logger, family peers.Family, peer peers.Peer, infoHash, peerID []byte) ([]byte, error) {
+	addr := peer.Addr()
+	logger = logger.With(slog.String("peer_addr", addr))
+
+	logger.Info("establishing connection with peer for metadata")
+	conn, err := net.DialTimeout(family.Network("tcp"), addr, 3*time.Second)
+	if err != nil {
+		logger.Error("failed to establish connection with peer", "error", err)
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = conn.SetDeadline(time.Now().Add(metadataTimeout)); err != nil {
+		return nil, err
+	}
+
+	logger.Info("performing handshake with peer")
+	// the deadline of the connection already bounds the exchange
+	opts := handshakeOptions(peer)
+	opts.Timeout = 0
+
+	reply, err := handshake.ExchangeWithOptions(conn, newHandshake(infoHash, peerID), opts)
+	if err != nil {
+		logger.Error("failed to complete handshake with peer", "error", err)
+		return nil, err
+	}
+
+	if !reply.SupportsExtensionProtocol() {
+		return nil, ErrMetadataUnsupported
+	}
+
+	info, err := readMetadata(conn)
+	if err != nil {
+		logger.Error("failed to fetch metadata from peer", "error", err)
+		return nil, err
+	}
+
+	if hash := sha1.Sum(info); !bytes.Equal(hash[:], infoHash) {
+		return nil, fmt.Errorf("mismatch metadata hash, want %x, got %x", infoHash, hash)
+	}
+
+	logger.Info("metadata downloaded", slog.Int("size", len(info)))
+	return info, nil
+}
+
+// readMetadata exchanges extension handshakes on an established connection,
+// then requests and assembles every metadata piece
+func readMetadata(rw io.ReadWriter) ([]byte, error) {
+	hs, err := message.NewExtendedHandshake(&message.ExtendedHandshake{
+		M: map[string]int{"ut_metadata": int(utMetadataID)},
+		V: ClientName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = rw.Write(hs.Serialize()); err != nil {
+		return nil, err
+	}
+
+	var (
+		peerMetadataID byte
+		metadata       []byte
+		received       []bool
+		numReceived    int
+	)
+
+	for metadata == nil || numReceived < len(received) {
+		msg, err := message.Read(rw)
+		if err != nil {
+			return nil, err
+		}
+
+		// other messages such as bitfield and have are irrelevant here
+		if msg == nil || msg.ID != message.MessageExtended {
+			continue
+		}
+
+		extID, extPayload, err := message.ParseExtended(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		switch extID {
+		case message.ExtendedHandshakeID:
+			if metadata != nil {
+				continue
+			}
+
+			eh, err := message.ParseExtendedHandshake(msg)
+			if err != nil {
+				return nil, err
+			}
+
+			id, ok := eh.M["ut_metadata"]
+			if !ok || id == 0 {
+				return nil, ErrMetadataUnsupported
+			}
+
+			if eh.MetadataSize <= 0 || eh.MetadataSize > MaxMetadataSize {
+				return nil, fmt.Errorf("invalid metadata size: %d", eh.MetadataSize)
+			}
+
+			peerMetadataID = byte(id)
+			metadata = make([]byte, eh.MetadataSize)
+			received = make([]bool, (eh.MetadataSize+MetadataPieceLen-1)/MetadataPieceLen)
+
+			for i := range received {
+				if err := sendMetadataRequest(rw, peerMetadataID, i); err != nil {
+					return nil, err
+				}
+			}
+		case utMetadataID:
+			if metadata == nil {
+				return nil, fmt.Errorf("received metadata before extension handshake")
+			}
+
+			var mm metadataMessage
+			data, err := decodeDict(extPayload, &mm)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata message: %w", err)
+			}
+
+			switch mm.MsgType {
+			case metadataData:
+				if mm.Piece < 0 || mm.Piece >= len(received) {
+					return nil, fmt.Errorf("unexpected metadata piece index %d", mm.Piece)
+				}
+
+				start := mm.Piece * MetadataPieceLen
+				end := min(start+MetadataPieceLen, len(metadata))
+				if len(data) != end-start {
+					return nil, fmt.Errorf("unexpected metadata piece length, want %d, got %d", end-start, len(data))
+				}
+
+				copy(metadata[start:end], data)
+				if !received[mm.Piece] {
+					received[mm.Piece] = true
+					numReceived++
+				}
+			case metadataReject:
+				return nil, ErrMetadataRejected
+			}
+		}
+	}
+
+	return metadata, nil
+}
+
+func sendMetadataRequest(w io.Writer, peerMetadataID byte, piece int) error {
+	payload, err := bencode.Marshal(map[string]interface{}{
+		"msg_type": metadataRequest,
+		"piece":    piece,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(message.NewExtended(peerMetadataID, payload).Serialize())
+	return err
+}
+
+// decodeDict decodes the bencoded dictionary at the start of payload into v and
+// returns the bytes following it
+func decodeDict(payload []byte, v interface{}) ([]byte, error) {
+	if len(payload) == 0 || payload[0] != 'd' {
+		return nil, fmt.Errorf("expected dictionary")
+	}
+
+	d := bencode.NewDecoder(bytes.NewReader(payload))
+	if err := d.Decode(v); err != nil {
+		return nil, err
+	}
+
+	return payload[d.InputOffset():], nil
+}
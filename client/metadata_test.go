@@ -0,0 +1,187 @@
+package client_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/peers"
+)
+
+// metadataPeer is a local peer serving an info dictionary with ut_metadata
+type metadataPeer struct {
+	info       []byte
+	extensions bool
+	reject     bool
+	corrupt    bool
+}
+
+func (mp *metadataPeer) serve(conn net.Conn) error {
+	defer conn.Close()
+
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+
+	reply := handshake.New(buf[28:48], []byte("-XX0000-000000000000"))
+	if mp.extensions {
+		reply.EnableExtensionProtocol()
+	}
+	if _, err := conn.Write(reply.Serialize()); err != nil {
+		return err
+	}
+
+	if !mp.extensions {
+		return nil
+	}
+
+	// unrelated messages before the extension handshake are skipped by the client
+	if _, err := conn.Write(message.New(message.MessageBitfield, []byte{0xff}).Serialize()); err != nil {
+		return err
+	}
+
+	hs, err := bencode.Marshal(map[string]interface{}{
+		"m":             map[string]interface{}{"ut_metadata": 3},
+		"metadata_size": len(mp.info),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(message.NewExtended(message.ExtendedHandshakeID, hs).Serialize()); err != nil {
+		return err
+	}
+
+	var clientMetadataID int64
+	for {
+		msg, err := message.Read(conn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		extID, payload, err := message.ParseExtended(msg)
+		if err != nil {
+			return err
+		}
+
+		var dict map[string]interface{}
+		if err := bencode.NewDecoder(bytes.NewReader(payload)).Decode(&dict); err != nil {
+			return err
+		}
+
+		if extID == message.ExtendedHandshakeID {
+			clientMetadataID = dict["m"].(map[string]interface{})["ut_metadata"].(int64)
+			continue
+		}
+
+		if extID != 3 {
+			return fmt.Errorf("unexpected extended message ID %d", extID)
+		}
+
+		piece := int(dict["piece"].(int64))
+		var resp bytes.Buffer
+		if mp.reject {
+			header, _ := bencode.Marshal(map[string]interface{}{"msg_type": 2, "piece": piece})
+			resp.Write(header)
+		} else {
+			header, _ := bencode.Marshal(map[string]interface{}{"msg_type": 1, "piece": piece, "total_size": len(mp.info)})
+			resp.Write(header)
+			end := min((piece+1)*client.MetadataPieceLen, len(mp.info))
+			data := append([]byte(nil), mp.info[piece*client.MetadataPieceLen:end]...)
+			if mp.corrupt {
+				data[0] ^= 0xff
+			}
+			resp.Write(data)
+		}
+
+		if _, err := conn.Write(message.NewExtended(byte(clientMetadataID), resp.Bytes()).Serialize()); err != nil {
+			return err
+		}
+	}
+}
+
+func TestFetchMetadata(t *testing.T) {
+	t.Parallel()
+
+	// large enough to span multiple metadata pieces
+	info := []byte(fmt.Sprintf("d6:lengthi100000e4:name8:test.txt12:piece lengthi5000e6:pieces%d:%se", 20*1000, strings.Repeat("a", 20*1000)))
+	infoHash := sha1.Sum(info)
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	cases := []struct {
+		name  string
+		peer  *metadataPeer
+		fails bool
+	}{
+		{
+			name:  "correctly fetches and verifies metadata",
+			peer:  &metadataPeer{info: info, extensions: true},
+			fails: false,
+		},
+		{
+			name:  "error when peer does not support extensions",
+			peer:  &metadataPeer{info: info, extensions: false},
+			fails: true,
+		},
+		{
+			name:  "error when peer rejects requests",
+			peer:  &metadataPeer{info: info, extensions: true, reject: true},
+			fails: true,
+		},
+		{
+			name:  "error on metadata hash mismatch",
+			peer:  &metadataPeer{info: info, extensions: true, corrupt: true},
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				tc.peer.serve(conn)
+			}()
+
+			addr := ln.Addr().(*net.TCPAddr)
+			peer := peers.Peer{IP: addr.IP.String(), Port: uint16(addr.Port)}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			got, err := client.FetchMetadata(logger, peers.FamilyAny, peer, infoHash[:], peerID)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !cmp.Equal(info, got) {
+					t.Errorf("metadata mismatch, want %d bytes, got %d bytes", len(info), len(got))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package client
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/peers"
+)
+
+// peer exchange as described in BEP 11
+const (
+	// utPex is the name of the extension in the extension handshake
+	utPex = "ut_pex"
+	// PEXInterval is the time between two PEX messages sent to a peer, peers
+	// sending them more often have their messages ignored
+	PEXInterval = time.Minute
+	// MaxPEXPeers bounds the added and dropped peers of a PEX message
+	MaxPEXPeers = 50
+)
+
+// Flags of the peers added by a PEX message
+const (
+	PEXPrefersEncryption byte = 1 << iota
+	PEXSeed
+	PEXSupportsUTP
+	PEXHolepunch
+	PEXReachable
+)
+
+// PEXPeer is a peer added by a PEX message
+type PEXPeer struct {
+	peers.Peer
+	Flags byte
+}
+
+// PEXMessage tells a peer about the peers we connected to and disconnected
+// from since the previous message
+type PEXMessage struct {
+	Added   []PEXPeer
+	Dropped []peers.Peer
+}
+
+// pexMessage is the encoding of a PEX message, with the IPv4 and IPv6 peers in
+// compact format and one byte of flags per added peer
+type pexMessage struct {
+	Added    string `bencode:"added"`
+	AddedF   string `bencode:"added.f"`
+	Added6   string `bencode:"added6"`
+	Added6F  string `bencode:"added6.f"`
+	Dropped  string `bencode:"dropped"`
+	Dropped6 string `bencode:"dropped6"`
+}
+
+// ParsePEX parses the payload of a ut_pex message
+func ParsePEX(payload []byte) (*PEXMessage, error) {
+	var pm pexMessage
+	if _, err := decodeDict(payload, &pm); err != nil {
+		return nil, fmt.Errorf("invalid pex message: %w", err)
+	}
+
+	var m PEXMessage
+	for _, added := range []struct {
+		peers string
+		flags string
+		ipv6  bool
+	}{
+		{pm.Added, pm.AddedF, false},
+		{pm.Added6, pm.Added6F, true},
+	} {
+		list, err := peers.ParseCompact([]byte(added.peers), added.ipv6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pex message: %w", err)
+		}
+
+		for i, p := range list {
+			pp := PEXPeer{Peer: p}
+			if i < len(added.flags) {
+				pp.Flags = added.flags[i]
+			}
+			m.Added = append(m.Added, pp)
+		}
+	}
+
+	for _, dropped := range []struct {
+		peers string
+		ipv6  bool
+	}{
+		{pm.Dropped, false},
+		{pm.Dropped6, true},
+	} {
+		list, err := peers.ParseCompact([]byte(dropped.peers), dropped.ipv6)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pex message: %w", err)
+		}
+		m.Dropped = append(m.Dropped, list...)
+	}
+
+	return &m, nil
+}
+
+// Marshal encodes the message into the payload of a ut_pex message
+func (m *PEXMessage) Marshal() ([]byte, error) {
+	var pm pexMessage
+	for _, ipv6 := range []bool{false, true} {
+		var (
+			added []peers.Peer
+			flags []byte
+		)
+		for _, p := range m.Added {
+			// peers given by host name cannot be encoded
+			if len(peers.Compact([]peers.Peer{p.Peer}, ipv6)) > 0 {
+				added = append(added, p.Peer)
+				flags = append(flags, p.Flags)
+			}
+		}
+
+		if ipv6 {
+			pm.Added6, pm.Added6F = string(peers.Compact(added, true)), string(flags)
+			pm.Dropped6 = string(peers.Compact(m.Dropped, true))
+		} else {
+			pm.Added, pm.AddedF = string(peers.Compact(added, false)), string(flags)
+			pm.Dropped = string(peers.Compact(m.Dropped, false))
+		}
+	}
+
+	return bencode.Marshal(pm)
+}
+
+// pexSession exchanges peers with a connected peer
+type pexSession struct {
+	swarm *Swarm
+	// started is set once the peer advertised ut_pex
+	started bool
+	// lastReceived is the time of the last PEX message accepted from the peer
+	lastReceived time.Time
+	// sent are the peers the peer was told about
+	sent map[string]peers.Peer
+}
+
+func newPEXSession(swarm *Swarm) *pexSession {
+	return &pexSession{
+		swarm: swarm,
+		sent:  make(map[string]peers.Peer),
+	}
+}
+
+// Start sends PEX messages to a peer which supports them until the connection ends
+func (p *pexSession) Start(c *Client, hs *message.ExtendedHandshake) {
+	if p.started {
+		return
+	}
+	p.started = true
+
+	go func() {
+		ticker := time.NewTicker(PEXInterval)
+		defer ticker.Stop()
+
+		for {
+			if err := p.send(c); err != nil {
+				c.logger.Error("failed to send pex message to peer", "error", err)
+				return
+			}
+
+			select {
+			case <-c.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// send tells the peer about the changes of the connected peers since the previous message
+func (p *pexSession) send(c *Client) error {
+	connected := p.swarm.reachable()
+	delete(connected, c.peer.Addr())
+
+	var m PEXMessage
+	for addr, peer := range connected {
+		if _, ok := p.sent[addr]; !ok && len(m.Added) < MaxPEXPeers {
+			m.Added = append(m.Added, PEXPeer{Peer: peer, Flags: PEXReachable})
+			p.sent[addr] = peer
+		}
+	}
+
+	for addr, peer := range p.sent {
+		if _, ok := connected[addr]; !ok && len(m.Dropped) < MaxPEXPeers {
+			m.Dropped = append(m.Dropped, peer)
+			delete(p.sent, addr)
+		}
+	}
+
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return nil
+	}
+
+	payload, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+
+	return c.SendExtended(utPex, payload)
+}
+
+// Handle hands the peers added by a PEX message to the swarm, the invalid
+// messages and those sent too early are ignored
+func (p *pexSession) Handle(c *Client, payload []byte) error {
+	now := time.Now()
+	if !p.lastReceived.IsZero() && now.Sub(p.lastReceived) < PEXInterval/2 {
+		c.logger.Info("ignoring pex message sent too early")
+		return nil
+	}
+
+	m, err := ParsePEX(payload)
+	if err != nil {
+		c.logger.Error("failed to parse pex message", "error", err)
+		return nil
+	}
+	p.lastReceived = now
+
+	var added []peers.Peer
+	for _, pp := range m.Added[:min(len(m.Added), MaxPEXPeers)] {
+		added = append(added, pp.Peer)
+	}
+
+	c.logger.Info("received pex message", slog.Int("added", len(m.Added)), slog.Int("dropped", len(m.Dropped)))
+	p.swarm.Add(added)
+	return nil
+}
@@ -0,0 +1,380 @@
+package client_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/message"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestParsePEX(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		output *client.PEXMessage
+		fails  bool
+	}{
+		{
+			name:  "IPv4 peers with flags",
+			input: "d5:added12:\x01\x02\x03\x04\x1a\xe1\x05\x06\x07\x08\x1a\xe27:added.f2:\x02\x107:dropped6:\x09\x09\x09\x09\x00\x50e",
+			output: &client.PEXMessage{
+				Added: []client.PEXPeer{
+					{Peer: peers.Peer{IP: "1.2.3.4", Port: 6881}, Flags: client.PEXSeed},
+					{Peer: peers.Peer{IP: "5.6.7.8", Port: 6882}, Flags: client.PEXReachable},
+				},
+				Dropped: []peers.Peer{{IP: "9.9.9.9", Port: 80}},
+			},
+			fails: false,
+		},
+		{
+			name:  "IPv6 peers without flags",
+			input: "d6:added618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1e",
+			output: &client.PEXMessage{
+				Added: []client.PEXPeer{
+					{Peer: peers.Peer{IP: "2001:db8::1", Port: 6881}},
+				},
+			},
+			fails: false,
+		},
+		{
+			name:  "error on truncated peers",
+			input: "d5:added5:\x01\x02\x03\x04\x1ae",
+			fails: true,
+		},
+		{
+			name:  "error on non dictionary payload",
+			input: "li1ee",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := client.ParsePEX([]byte(tc.input))
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestPEXMessage_Marshal(t *testing.T) {
+	t.Parallel()
+
+	m := &client.PEXMessage{
+		Added: []client.PEXPeer{
+			{Peer: peers.Peer{IP: "1.2.3.4", Port: 6881}, Flags: client.PEXSeed},
+			{Peer: peers.Peer{IP: "2001:db8::1", Port: 6882}, Flags: client.PEXPrefersEncryption},
+			// peers given by host name are left out
+			{Peer: peers.Peer{IP: "example.com", Port: 6883}},
+		},
+		Dropped: []peers.Peer{{IP: "9.9.9.9", Port: 80}},
+	}
+
+	payload, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.ParsePEX(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &client.PEXMessage{
+		Added:   m.Added[:2],
+		Dropped: m.Dropped,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+// pexPeer is a local peer which tells the client about another peer with ut_pex
+type pexPeer struct {
+	// added is the peer sent in the PEX message
+	added peers.Peer
+	// extensions receives the extensions advertised by the client
+	extensions chan map[string]interface{}
+}
+
+func (pp *pexPeer) serve(conn net.Conn) error {
+	buf := make([]byte, 68)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+
+	reply := handshake.New(buf[28:48], []byte("-XX0000-000000000000"))
+	reply.EnableExtensionProtocol()
+	if _, err := conn.Write(reply.Serialize()); err != nil {
+		return err
+	}
+
+	msg, err := message.Read(conn)
+	if err != nil {
+		return err
+	}
+
+	_, payload, err := message.ParseExtended(msg)
+	if err != nil {
+		return err
+	}
+
+	var hs map[string]interface{}
+	if err := bencode.NewDecoder(bytes.NewReader(payload)).Decode(&hs); err != nil {
+		return err
+	}
+	m, _ := hs["m"].(map[string]interface{})
+	pp.extensions <- m
+
+	ours, err := bencode.Marshal(map[string]interface{}{
+		"m": map[string]interface{}{"ut_pex": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(message.NewExtended(message.ExtendedHandshakeID, ours).Serialize()); err != nil {
+		return err
+	}
+
+	// private swarm clients do not advertise ut_pex and ignore the message
+	pexID, ok := m["ut_pex"].(int64)
+	if !ok {
+		pexID = 1
+	}
+
+	pex, err := (&client.PEXMessage{Added: []client.PEXPeer{{Peer: pp.added}}}).Marshal()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(message.NewExtended(byte(pexID), pex).Serialize()); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(message.New(message.MessageBitfield, []byte{0}).Serialize()); err != nil {
+		return err
+	}
+
+	// keep the connection open until the client leaves
+	_, err = io.Copy(io.Discard, conn)
+	return err
+}
+
+func TestSwarm_PEX(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	cases := []struct {
+		name    string
+		private bool
+		// output tells whether the peer learned with PEX is connected to
+		output bool
+	}{
+		{
+			name:    "public swarm connects to the peers learned with pex",
+			private: false,
+			output:  true,
+		},
+		{
+			name:    "private swarm does not exchange peers",
+			private: true,
+			output:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			// the peer only known through PEX reports the info hash of its handshake
+			learned, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer learned.Close()
+
+			handshakes := make(chan []byte, 1)
+			go func() {
+				conn, err := learned.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				buf := make([]byte, 68)
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					return
+				}
+				handshakes <- buf[28:48]
+			}()
+
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ln.Close()
+
+			learnedAddr := learned.Addr().(*net.TCPAddr)
+			pp := &pexPeer{
+				added:      peers.Peer{IP: learnedAddr.IP.String(), Port: uint16(learnedAddr.Port)},
+				extensions: make(chan map[string]interface{}, 1),
+			}
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				pp.serve(conn)
+			}()
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			taskStream := make(chan client.PieceTask)
+			defer close(taskStream)
+
+			swarm := client.NewSwarm(logger, infoHash, peerID, tc.private, taskStream, make(chan client.PieceResult))
+			defer swarm.Close()
+
+			addr := ln.Addr().(*net.TCPAddr)
+			swarm.Add([]peers.Peer{{IP: addr.IP.String(), Port: uint16(addr.Port)}})
+
+			select {
+			case m := <-pp.extensions:
+				if _, ok := m["ut_pex"]; ok != tc.output {
+					t.Errorf("want ut_pex advertised %t, got extensions %v", tc.output, m)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the extension handshake")
+			}
+
+			select {
+			case got := <-handshakes:
+				if !tc.output {
+					t.Fatal("want no connection to the peer learned with pex")
+				}
+
+				if !cmp.Equal(infoHash, got) {
+					t.Error(cmp.Diff(infoHash, got))
+				}
+			case <-time.After(time.Second):
+				if tc.output {
+					t.Fatal("timed out waiting for a connection to the peer learned with pex")
+				}
+			}
+		})
+	}
+}
+
+func TestSwarm_PEXIncoming(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	l := client.NewListener(logger, ln)
+	go l.Serve()
+	defer l.Close()
+
+	taskStream := make(chan client.PieceTask)
+	defer close(taskStream)
+
+	swarm := client.NewSwarm(logger, infoHash, make([]byte, 20), false, taskStream, make(chan client.PieceResult))
+	defer swarm.Close()
+	swarm.Port = l.Port()
+	l.Add(swarm)
+
+	// incoming connects an incoming peer which listens on port and reads the
+	// handshakes of the swarm
+	incoming := func(peerID string, port int) net.Conn {
+		conn := dialListener(t, l, infoHash, []byte(peerID))
+		if _, err := handshake.Read(conn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := message.Read(conn); err != nil {
+			t.Fatal(err)
+		}
+
+		hs, err := bencode.Marshal(map[string]interface{}{
+			"m": map[string]interface{}{"ut_pex": 1},
+			"p": port,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(message.NewExtended(message.ExtendedHandshakeID, hs).Serialize()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(message.New(message.MessageBitfield, []byte{0}).Serialize()); err != nil {
+			t.Fatal(err)
+		}
+
+		return conn
+	}
+
+	incoming("-XX0000-000000000001", 4000)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(swarm.Connected()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the incoming peer to be connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the first peer is advertised at the port of its extension handshake
+	conn := incoming("-XX0000-000000000002", 5000)
+	for {
+		msg, err := message.Read(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || msg.ID != message.MessageExtended {
+			continue
+		}
+
+		_, payload, err := message.ParseExtended(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := client.ParsePEX(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &client.PEXMessage{Added: []client.PEXPeer{{Peer: peers.Peer{IP: "127.0.0.1", Port: 4000}, Flags: client.PEXReachable}}}
+		if !cmp.Equal(want, got) {
+			t.Error(cmp.Diff(want, got))
+		}
+		return
+	}
+}
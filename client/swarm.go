@@ -0,0 +1,251 @@
+package client
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kanowfy/btor/handshake"
+	"github.com/kanowfy/btor/ipfilter"
+	"github.com/kanowfy/btor/peers"
+)
+
+const (
+	// DefaultMaxPeers is the default number of peers a swarm connects to at once
+	DefaultMaxPeers = 50
+	// maxCandidates bounds the peers waiting for a connection slot
+	maxCandidates = 1000
+)
+
+// Swarm is the connection manager of a download. It connects to the peers
+// found by every source up to MaxPeers at once, keeps the other peers until a
+// connection ends, and exchanges peers with the connected ones unless the
+// torrent is private
+type Swarm struct {
+	logger       *slog.Logger
+	infoHash     []byte
+	peerID       []byte
+	taskStream   chan PieceTask
+	resultStream chan<- PieceResult
+
+	// MaxPeers bounds the number of peers connected to at once
+	MaxPeers int
+	// Filter blocks the peers of its ranges, whichever way they were found
+	Filter *ipfilter.Filter
+	// Family restricts the peers connected to to an address family
+	Family peers.Family
+	// Extensions are advertised to the peers, ut_pex is registered unless the
+	// torrent is private
+	Extensions *Extensions
+	// Port is the port a Listener accepts the peers of the swarm on, told to
+	// the peers in the extension handshake. 0 when not listening
+	Port uint16
+
+	mu         sync.Mutex
+	known      map[string]bool
+	candidates []peers.Peer
+	// active are the peers being connected to or connected
+	active    map[string]bool
+	connected map[string]*Client
+	closed    bool
+}
+
+// NewSwarm creates the swarm of an info hash, the peers download the pieces
+// of taskStream into resultStream
+func NewSwarm(logger *slog.Logger, infoHash, peerID []byte, private bool, taskStream chan PieceTask, resultStream chan<- PieceResult) *Swarm {
+	s := &Swarm{
+		logger:       logger,
+		infoHash:     infoHash,
+		peerID:       peerID,
+		taskStream:   taskStream,
+		resultStream: resultStream,
+		MaxPeers:     DefaultMaxPeers,
+		Extensions:   NewExtensions(),
+		known:        make(map[string]bool),
+		active:       make(map[string]bool),
+		connected:    make(map[string]*Client),
+	}
+
+	if peers.SourcePEX.Allowed(private) {
+		s.Extensions.Register(utPex, func() ExtensionHandler {
+			return newPEXSession(s)
+		})
+	}
+
+	return s
+}
+
+// Add adds peers to connect to, the peers already known, outside the address
+// family or blocked by the filter are ignored
+func (s *Swarm) Add(peerList []peers.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, peer := range s.Family.Filter(peerList) {
+		addr := peer.Addr()
+		if s.closed || s.known[addr] {
+			continue
+		}
+		s.known[addr] = true
+
+		if ip, err := netip.ParseAddr(peer.IP); err == nil && s.Filter.Reject(ip) {
+			s.logger.Info("peer blocked by ip filter", slog.String("peer_addr", addr))
+			continue
+		}
+
+		if len(s.active) < s.MaxPeers {
+			s.start(peer)
+		} else if len(s.candidates) < maxCandidates {
+			s.candidates = append(s.candidates, peer)
+		}
+	}
+}
+
+// Connected returns the peers which completed the handshake
+func (s *Swarm) Connected() []peers.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]peers.Peer, 0, len(s.connected))
+	for _, c := range s.connected {
+		list = append(list, c.peer)
+	}
+
+	return list
+}
+
+// reachable returns the connected peers at the address they accept
+// connections on, keyed by the address of their connection. The peers which
+// connected to us are left out until their extension handshake tells their port
+func (s *Swarm) reachable() map[string]peers.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make(map[string]peers.Peer, len(s.connected))
+	for addr, c := range s.connected {
+		peer := c.peer
+		if c.incoming {
+			hs := c.PeerExtensions()
+			if hs == nil || hs.P <= 0 || hs.P > math.MaxUint16 {
+				continue
+			}
+			peer.Port = uint16(hs.P)
+		}
+		list[addr] = peer
+	}
+
+	return list
+}
+
+// Close stops connecting to new peers, the connections end once taskStream is closed
+func (s *Swarm) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.candidates = nil
+}
+
+// start connects to a peer, it must be called with mu held
+func (s *Swarm) start(peer peers.Peer) {
+	s.active[peer.Addr()] = true
+	go s.run(peer)
+}
+
+func (s *Swarm) run(peer peers.Peer) {
+	defer s.done(peer)
+
+	c, err := dial(s.logger, peer, s.infoHash, s.peerID, s)
+	if err != nil {
+		s.logger.Error(err.Error())
+		return
+	}
+
+	s.serve(c)
+}
+
+// accept takes over an incoming connection whose handshake was read, it is
+// closed when the swarm is full or already connected to the peer
+func (s *Swarm) accept(conn net.Conn, hs *handshake.Handshake) {
+	addr, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	peer := peers.Peer{IP: addr.Addr().Unmap().String(), Port: addr.Port(), ID: string(hs.PeerID)}
+
+	s.mu.Lock()
+	if s.closed || s.active[peer.Addr()] || len(s.active) >= s.MaxPeers {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.known[peer.Addr()] = true
+	s.active[peer.Addr()] = true
+	s.mu.Unlock()
+
+	go s.runIncoming(conn, peer, hs)
+}
+
+func (s *Swarm) runIncoming(conn net.Conn, peer peers.Peer, hs *handshake.Handshake) {
+	defer s.done(peer)
+
+	logger := s.logger.With(slog.String("peer_addr", peer.Addr()))
+	logger.Info("accepted connection from peer")
+
+	// the peer must reply to our handshake with its first message in time
+	if err := conn.SetDeadline(time.Now().Add(handshake.DefaultTimeout)); err != nil {
+		conn.Close()
+		return
+	}
+
+	if _, err := conn.Write(newHandshake(s.infoHash, s.peerID).Serialize()); err != nil {
+		logger.Error("failed to complete handshake with peer", "error", err)
+		conn.Close()
+		return
+	}
+
+	c, err := newSession(logger, conn, peer, s.infoHash, s.peerID, s, hs)
+	if err != nil {
+		return
+	}
+	c.incoming = true
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		c.Close()
+		return
+	}
+
+	s.serve(c)
+}
+
+// serve downloads from a connected peer until the download ends
+func (s *Swarm) serve(c *Client) {
+	defer c.Close()
+
+	s.mu.Lock()
+	s.connected[c.peer.Addr()] = c
+	s.mu.Unlock()
+
+	c.download(s.taskStream, s.resultStream)
+}
+
+// done frees the connection slot of a peer for the next candidate
+func (s *Swarm) done(peer peers.Peer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, peer.Addr())
+	delete(s.connected, peer.Addr())
+
+	if s.closed || len(s.candidates) == 0 {
+		return
+	}
+
+	next := s.candidates[0]
+	s.candidates = s.candidates[1:]
+	s.start(next)
+}
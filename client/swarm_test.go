@@ -0,0 +1,116 @@
+package client_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/ipfilter"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestSwarm_Filter(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan struct{}, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		accepted <- struct{}{}
+	}()
+
+	filter, err := ipfilter.Parse(strings.NewReader("127.0.0.0/8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	taskStream := make(chan client.PieceTask)
+	defer close(taskStream)
+
+	swarm := client.NewSwarm(logger, []byte("aaaaaaaaaaaaaaaaaaaa"), make([]byte, 20), false, taskStream, make(chan client.PieceResult))
+	defer swarm.Close()
+	swarm.Filter = filter
+
+	addr := ln.Addr().(*net.TCPAddr)
+	peer := peers.Peer{IP: addr.IP.String(), Port: uint16(addr.Port)}
+
+	// a peer found twice is only counted once
+	swarm.Add([]peers.Peer{peer})
+	swarm.Add([]peers.Peer{peer})
+
+	select {
+	case <-accepted:
+		t.Fatal("want no connection to the blocked peer")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if filter.Blocked() != 1 {
+		t.Errorf("want 1 blocked peer, got %d", filter.Blocked())
+	}
+}
+
+func TestSwarm_FilterHostName(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan int, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _ := io.Copy(io.Discard, conn)
+		received <- int(n)
+	}()
+
+	filter, err := ipfilter.Parse(strings.NewReader("127.0.0.0/8\n::1/128\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	taskStream := make(chan client.PieceTask)
+	defer close(taskStream)
+
+	swarm := client.NewSwarm(logger, []byte("aaaaaaaaaaaaaaaaaaaa"), make([]byte, 20), false, taskStream, make(chan client.PieceResult))
+	defer swarm.Close()
+	swarm.Filter = filter
+
+	// the host name is only matched by the filter once resolved
+	swarm.Add([]peers.Peer{{IP: "localhost", Port: uint16(ln.Addr().(*net.TCPAddr).Port)}})
+
+	select {
+	case n := <-received:
+		if n != 0 {
+			t.Errorf("want no handshake sent to the blocked peer, got %d bytes", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the connection to the blocked peer closed")
+	}
+
+	if filter.Blocked() != 1 {
+		t.Errorf("want 1 blocked peer, got %d", filter.Blocked())
+	}
+}
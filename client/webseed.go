@@ -0,0 +1,164 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kanowfy/btor/metainfo"
+)
+
+const (
+	DefaultWebSeedMinBackoff  = 5 * time.Second
+	DefaultWebSeedMaxBackoff  = 5 * time.Minute
+	DefaultWebSeedMaxFailures = 10
+)
+
+// WebSeed downloads pieces over HTTP from a GetRight-style web seed as described in BEP 19
+type WebSeed struct {
+	URL    string
+	Client *http.Client
+	// MinBackoff is the wait after the first failure, it doubles with every
+	// consecutive failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxFailures is the number of consecutive failures after which the seed is abandoned
+	MaxFailures int
+
+	failures int
+}
+
+func NewWebSeed(seedURL string) *WebSeed {
+	return &WebSeed{
+		URL:         seedURL,
+		Client:      &http.Client{Timeout: time.Minute},
+		MinBackoff:  DefaultWebSeedMinBackoff,
+		MaxBackoff:  DefaultWebSeedMaxBackoff,
+		MaxFailures: DefaultWebSeedMaxFailures,
+	}
+}
+
+// StartWebSeedClient downloads pieces from a web seed alongside the peer download clients
+func StartWebSeedClient(logger *slog.Logger, ws *WebSeed, mi *metainfo.Metainfo, taskStream chan PieceTask, resultStream chan<- PieceResult) {
+	logger = logger.With(slog.String("web_seed", ws.URL))
+
+	for pt := range taskStream {
+		logger.Info("downloading piece", slog.Int("index", pt.Index))
+		piece, err := ws.downloadPiece(mi, pt)
+		if err == nil {
+			err = pt.Verify(piece)
+		}
+
+		if err != nil {
+			taskStream <- pt
+			ws.failures++
+			if ws.failures >= ws.MaxFailures {
+				logger.Error("giving up on web seed", slog.Int("failures", ws.failures), "error", err)
+				return
+			}
+
+			backoff := ws.backoff()
+			logger.Info("could not download piece", slog.Int("index", pt.Index), slog.Duration("backoff", backoff), "error", err)
+			time.Sleep(backoff)
+			continue
+		}
+
+		ws.failures = 0
+		logger.Info("piece downloaded", slog.Int("piece index", pt.Index))
+		resultStream <- PieceResult{
+			PieceTask: pt,
+			Data:      piece,
+		}
+	}
+}
+
+// backoff returns the wait before the next request given the consecutive failures
+func (ws *WebSeed) backoff() time.Duration {
+	backoff := ws.MinBackoff
+	for i := 1; i < ws.failures && backoff < ws.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, ws.MaxBackoff)
+}
+
+// downloadPiece fetches every file span covered by the piece with range requests
+func (ws *WebSeed) downloadPiece(mi *metainfo.Metainfo, pt PieceTask) ([]byte, error) {
+	buf := make([]byte, pt.Length)
+	for _, span := range mi.Spans(pt.Offset, pt.Length) {
+		// pad files are zeros and do not exist on the server
+		if span.File.Attr.Pad() {
+			continue
+		}
+
+		if err := ws.fetchSpan(mi, span, buf[span.Offset:span.Offset+span.Length]); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+func (ws *WebSeed) fetchSpan(mi *metainfo.Metainfo, span metainfo.FileSpan, buf []byte) error {
+	req, err := http.NewRequest(http.MethodGet, ws.fileURL(mi, span.File), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", span.FileOffset, span.FileOffset+span.Length-1))
+
+	resp, err := ws.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range and sends the whole file
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(span.FileOffset)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected web seed response status: %s", resp.Status)
+	}
+
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	return nil
+}
+
+// fileURL builds the url of a file. Single file torrents use the seed url as
+// is unless it ends with a slash, in which case the torrent name is appended.
+// Files of multifile torrents are found under a directory named after the torrent
+func (ws *WebSeed) fileURL(mi *metainfo.Metainfo, file metainfo.ContentFile) string {
+	if !mi.Multifile && !strings.HasSuffix(ws.URL, "/") {
+		return ws.URL
+	}
+
+	var b strings.Builder
+	b.WriteString(ws.URL)
+	if !strings.HasSuffix(ws.URL, "/") {
+		b.WriteString("/")
+	}
+	b.WriteString(url.PathEscape(mi.Info.Name))
+
+	if mi.Multifile {
+		for _, p := range file.Path {
+			b.WriteString("/")
+			b.WriteString(url.PathEscape(p))
+		}
+	}
+
+	return b.String()
+}
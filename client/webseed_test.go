@@ -0,0 +1,162 @@
+package client_test
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestStartWebSeedClient(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	root := filepath.Join(dir, "my content")
+	files := map[string][]byte{
+		"a.bin":       bytes.Repeat([]byte("a"), 20000),
+		"sub/b c.bin": bytes.Repeat([]byte("b"), 30000),
+		"sub/d.txt":   []byte("d"),
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	multi, err := metainfo.Create(root, metainfo.CreateOptions{PieceLength: 16384})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	single, err := metainfo.Create(filepath.Join(root, "a.bin"), metainfo.CreateOptions{PieceLength: 16384})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first requests fail so the client has to back off and retry
+	var requests atomic.Int32
+	fileServer := http.FileServer(http.Dir(dir))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		mi      *metainfo.Metainfo
+		seedURL string
+		want    []byte
+	}{
+		{
+			name:    "multifile torrent from the root directory",
+			mi:      multi,
+			seedURL: srv.URL + "/",
+			want:    append(append(append([]byte(nil), files["a.bin"]...), files["sub/b c.bin"]...), files["sub/d.txt"]...),
+		},
+		{
+			name:    "single file torrent from the file url",
+			mi:      single,
+			seedURL: srv.URL + "/my%20content/a.bin",
+			want:    files["a.bin"],
+		},
+		{
+			name:    "single file torrent from a directory url",
+			mi:      single,
+			seedURL: srv.URL + "/my%20content/",
+			want:    files["a.bin"],
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests.Store(0)
+
+			pieces := tc.mi.Pieces()
+			taskStream := make(chan client.PieceTask, len(pieces))
+			resultStream := make(chan client.PieceResult)
+			for _, p := range pieces {
+				taskStream <- client.PieceTask{Piece: p}
+			}
+
+			ws := client.NewWebSeed(tc.seedURL)
+			ws.MinBackoff = time.Millisecond
+			ws.MaxBackoff = 2 * time.Millisecond
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			go client.StartWebSeedClient(logger, ws, tc.mi, taskStream, resultStream)
+
+			got := make([]byte, tc.mi.Info.Length)
+			for range pieces {
+				select {
+				case res := <-resultStream:
+					copy(got[res.Offset:], res.Data)
+				case <-time.After(5 * time.Second):
+					t.Fatal("timed out waiting for pieces")
+				}
+			}
+			close(taskStream)
+
+			if !bytes.Equal(tc.want, got) {
+				t.Error("downloaded content does not match")
+			}
+		})
+	}
+}
+
+func TestStartWebSeedClient_GivesUp(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	mi, err := metainfo.Create(filepath.Join(root, "a.bin"), metainfo.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	taskStream := make(chan client.PieceTask, 1)
+	taskStream <- client.PieceTask{Piece: mi.Pieces()[0]}
+
+	ws := client.NewWebSeed(srv.URL + "/a.bin")
+	ws.MinBackoff = time.Millisecond
+	ws.MaxBackoff = time.Millisecond
+	ws.MaxFailures = 3
+
+	done := make(chan struct{})
+	go func() {
+		client.StartWebSeedClient(slog.New(slog.NewTextHandler(io.Discard, nil)), ws, mi, taskStream, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("web seed client did not give up")
+	}
+
+	// the failed task is put back for other clients
+	if len(taskStream) != 1 {
+		t.Errorf("want task put back, got %d tasks", len(taskStream))
+	}
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/spf13/cobra"
+)
+
+func createCmd() *cobra.Command {
+	var (
+		outfile     string
+		announce    []string
+		comment     string
+		createdBy   string
+		noDate      bool
+		private     bool
+		webSeeds    []string
+		pieceLength int
+		exclude     []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create [flags] FILE_OR_DIRECTORY",
+		Short: "create a .torrent file from a file or a directory",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			mi, err := metainfo.Create(args[0], metainfo.CreateOptions{
+				PieceLength: pieceLength,
+				Exclude:     exclude,
+				Private:     private,
+			})
+			if err != nil {
+				fmt.Printf("failed to create torrent: %v\n", err)
+				os.Exit(1)
+			}
+
+			if tiers := parseTiers(announce); len(tiers) > 0 {
+				mi.Announce = tiers[0][0]
+				if len(tiers) > 1 || len(tiers[0]) > 1 {
+					mi.AnnounceList = tiers
+				}
+			}
+
+			mi.Comment = comment
+			mi.CreatedBy = createdBy
+			mi.URLList = webSeeds
+			if !noDate {
+				mi.CreationDate = time.Now()
+			}
+
+			if len(outfile) == 0 {
+				outfile = mi.Info.Name + ".torrent"
+			}
+
+			f, err := os.Create(outfile)
+			if err != nil {
+				fmt.Printf("failed to create torrent file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			if err = mi.Write(f); err != nil {
+				fmt.Printf("failed to write torrent file: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Created %s\n", outfile)
+			fmt.Printf("• Info Hash: %x\n", mi.InfoHash)
+			fmt.Printf("• Pieces: %d x %dB\n", len(mi.Info.Pieces)/20, mi.Info.PieceLength)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outfile, "out", "o", "", "output torrent file name, defaults to NAME.torrent")
+	cmd.Flags().StringArrayVarP(&announce, "announce", "a", nil, "tracker url, repeat for each tier and separate urls of the same tier with commas")
+	cmd.Flags().StringVarP(&comment, "comment", "c", "", "free-form comment")
+	cmd.Flags().StringVar(&createdBy, "created-by", "btor", "name of the program creating the torrent")
+	cmd.Flags().BoolVar(&noDate, "no-date", false, "omit the creation date")
+	cmd.Flags().BoolVarP(&private, "private", "p", false, "mark the torrent as private")
+	cmd.Flags().StringArrayVarP(&webSeeds, "web-seed", "w", nil, "web seed url, can be repeated")
+	cmd.Flags().IntVarP(&pieceLength, "piece-length", "l", 0, "piece length in bytes, a power of two selected from the content size by default")
+	cmd.Flags().StringArrayVarP(&exclude, "exclude", "x", nil, "glob pattern of files to exclude, can be repeated")
+
+	return cmd
+}
+
+// parseTiers reads the tiers of --announce flags, every flag is a tier and
+// urls of the same tier are separated by commas
+func parseTiers(announce []string) [][]string {
+	var tiers [][]string
+	for _, a := range announce {
+		var tier []string
+		for _, u := range strings.Split(a, ",") {
+			if u = strings.TrimSpace(u); len(u) > 0 {
+				tier = append(tier, u)
+			}
+		}
+
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	return tiers
+}
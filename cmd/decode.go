@@ -4,9 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/kanowfy/btor/bencode"
 	"github.com/spf13/cobra"
 )
 
@@ -17,11 +16,9 @@ func decodeCmd() *cobra.Command {
 		Long:  "decodes the bencoded string and print the json encoded result to the standard out",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
-			bencodeReader := strings.NewReader(args[0])
-
 			var decoded interface{}
-			if err := bencode.Unmarshal(bencodeReader, &decoded); err != nil {
-				fmt.Printf("invalid bencoded string: %q\n", args[0])
+			if err := bencode.Unmarshal([]byte(args[0]), &decoded); err != nil {
+				fmt.Printf("invalid bencoded string: %q: %v\n", args[0], err)
 				os.Exit(1)
 			}
 
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kanowfy/btor/dht"
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/kanowfy/btor/peers"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// dhtBootstrapTimeout bounds the time spent joining the DHT
+	dhtBootstrapTimeout = 30 * time.Second
+	// dhtLookupTimeout bounds the time spent looking up the peers of a torrent
+	dhtLookupTimeout = 30 * time.Second
+	// dhtAnnounceInterval is the time between the DHT announces of a download,
+	// nodes forget the announced peers after 30 minutes
+	dhtAnnounceInterval = 15 * time.Minute
+	// dhtRetryInterval is the wait after an announce which found no peers
+	dhtRetryInterval = time.Minute
+)
+
+var (
+	dhtEnabled   bool
+	dhtPort      int
+	dhtBootstrap []string
+)
+
+// addDHTFlags adds the flags of the commands which may find peers on the DHT
+func addDHTFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&dhtEnabled, "dht", true, "find peers on the mainline DHT, never used for private torrents")
+	cmd.Flags().IntVar(&dhtPort, "dht-port", peers.DefaultPort, "udp port of the DHT node, a random port is used when it is taken")
+	cmd.Flags().StringSliceVar(&dhtBootstrap, "dht-bootstrap", dht.DefaultBootstrapNodes, "host:port addresses of the nodes to join the DHT through")
+}
+
+func dhtCachePath() string {
+	return os.ExpandEnv("$HOME/.local/share/btor/dht.dat")
+}
+
+// startDHT starts a DHT node and joins the network through the nodes saved
+// by the previous run, extra nodes such as those of a trackerless torrent and
+// the bootstrap nodes
+func startDHT(ctx context.Context, logger *slog.Logger, extraNodes []string) (*dht.Server, error) {
+	node, cached, err := listenDHT(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = bootstrapDHT(ctx, node, append(cached, extraNodes...)); err != nil {
+		node.Close()
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// listenDHT starts a DHT node with the id saved by the previous run, and
+// returns the addresses of the nodes saved along with it
+func listenDHT(logger *slog.Logger) (*dht.Server, []string, error) {
+	id, cached, err := dht.LoadNodes(dhtCachePath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Warn("failed to load dht nodes", "error", err)
+	}
+
+	network := "udp4"
+	if addressFamily == peers.FamilyIPv6 {
+		network = "udp6"
+	}
+
+	node, err := dht.Listen(logger, network, fmt.Sprintf(":%d", dhtPort), id)
+	if err != nil {
+		// another client may be using the port
+		node, err = dht.Listen(logger, network, ":0", id)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var addrs []string
+	for _, n := range cached {
+		addrs = append(addrs, n.Addr.String())
+	}
+
+	return node, addrs, nil
+}
+
+// bootstrapDHT joins the network through the nodes of addrs then the bootstrap nodes
+func bootstrapDHT(ctx context.Context, node *dht.Server, addrs []string) error {
+	ctx, cancel := context.WithTimeout(ctx, dhtBootstrapTimeout)
+	defer cancel()
+
+	if err := node.Bootstrap(ctx, append(addrs, dhtBootstrap...)); err != nil {
+		return fmt.Errorf("failed to join dht: %w", err)
+	}
+
+	return nil
+}
+
+// nodeAddrs returns the addresses of the DHT nodes of a torrent
+func nodeAddrs(mi *metainfo.Metainfo) []string {
+	var addrs []string
+	for _, n := range mi.Nodes {
+		addrs = append(addrs, n.String())
+	}
+
+	return addrs
+}
+
+// findDHTPeers joins the DHT to look up the peers of an info hash
+func findDHTPeers(logger *slog.Logger, infoHash []byte) ([]peers.Peer, error) {
+	node, err := startDHT(context.Background(), logger, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer stopDHT(logger, node)
+
+	ctx, cancel := context.WithTimeout(context.Background(), dhtLookupTimeout)
+	defer cancel()
+
+	return node.GetPeers(ctx, infoHash)
+}
+
+// stopDHT saves the nodes of the routing table for the next run and stops the node
+func stopDHT(logger *slog.Logger, node *dht.Server) {
+	if err := node.SaveNodes(dhtCachePath()); err != nil {
+		logger.Warn("failed to save dht nodes", "error", err)
+	}
+
+	node.Close()
+}
+
+// announceDHT keeps a download announced on the DHT with the port peers reach
+// us on until the context is done, the peers found are handed to connect
+func announceDHT(ctx context.Context, logger *slog.Logger, node *dht.Server, infoHash []byte, port uint16, connect func([]peers.Peer)) {
+	for {
+		found, err := node.Announce(ctx, infoHash, port)
+		if err != nil && ctx.Err() == nil {
+			logger.Error("failed to announce to dht", slog.String("info_hash", fmt.Sprintf("%x", infoHash)), "error", err)
+		}
+		connect(found)
+
+		wait := dhtAnnounceInterval
+		if len(found) == 0 {
+			wait = dhtRetryInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
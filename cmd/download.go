@@ -1,14 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/dht"
+	"github.com/kanowfy/btor/lsd"
 	"github.com/kanowfy/btor/metainfo"
 	"github.com/kanowfy/btor/peers"
 	"github.com/schollz/progressbar/v3"
@@ -18,8 +24,8 @@ import (
 func downloadFileCmd() *cobra.Command {
 	var outfile string
 	cmd := &cobra.Command{
-		Use:   "download -o OUT_FILE TORRENT_FILE",
-		Short: "download and save file from a .torrent file",
+		Use:   "download -o OUT_FILE TORRENT_FILE|MAGNET_URI",
+		Short: "download and save file from a .torrent file or a magnet link",
 		Args:  cobra.MinimumNArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			torrentfile := args[0]
@@ -45,85 +51,408 @@ func downloadFileCmd() *cobra.Command {
 
 	cmd.Flags().StringVarP(&outfile, "out", "o", "", "output file name")
 	cmd.MarkFlagRequired("out")
+	addDHTFlags(cmd)
+	addLSDFlags(cmd)
+	addListenFlags(cmd)
 
 	return cmd
 }
 
-func downloadFile(outFile, torrentFile string, peerID []byte) error {
-	f, err := os.Open(torrentFile)
-	if err != nil {
-		return err
+// stopTimeout bounds the time spent sending the stopped event to the trackers
+const stopTimeout = 5 * time.Second
+
+func downloadFile(outFile, source string, peerID []byte) error {
+	var (
+		mi       *metainfo.Metainfo
+		peerList []peers.Peer
+	)
+
+	if isMagnet(source) {
+		var (
+			infoDict []byte
+			err      error
+		)
+		mi, infoDict, peerList, err = resolveMagnet(source, peerID)
+		if err != nil {
+			return err
+		}
+
+		if err = refuseInvalid(metainfo.ValidateInfo(infoDict)); err != nil {
+			return err
+		}
+
+		if len(peerList) == 0 && len(mi.WebSeeds()) == 0 {
+			return errors.New("no peers allowed to download private torrent")
+		}
+	} else {
+		if err := refuseInvalid(lintFile(source)); err != nil {
+			return err
+		}
+
+		var err error
+		mi, err = parseTorrentFile(source)
+		if err != nil {
+			return err
+		}
 	}
-	defer f.Close()
-	mi, err := metainfo.Parse(f)
+
+	return download(outFile, mi, peerList, peerID)
+}
+
+// refuseInvalid turns the error level problems found in a torrent into an error
+func refuseInvalid(problems []metainfo.Problem, err error) error {
 	if err != nil {
 		return err
 	}
 
-	peerList, err := peers.Fetch(mi.Announce, mi.InfoHash, mi.Info.Length, peerID)
-	if err != nil {
-		return err
+	var errs []error
+	for _, p := range problems {
+		if p.Severity == metainfo.SeverityError {
+			errs = append(errs, errors.New(p.String()))
+		}
 	}
 
-	pieceHashes := mi.PieceHashes()
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid torrent: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// download fetches every piece of the torrent from the peers and writes the
+// content to outFile. The torrent stays announced to its trackers and the DHT
+// during the download, and the peers they return are connected to as they come
+func download(outFile string, mi *metainfo.Metainfo, peerList []peers.Peer, peerID []byte) error {
+	pieces := mi.Pieces()
+	for _, p := range pieces {
+		if p.Hash == nil && p.Root == nil {
+			return fmt.Errorf("no hash available to verify piece %d", p.Index)
+		}
+	}
 
 	logger := slog.Default().With(slog.Group(
 		"metainfo", slog.String("file_name", mi.Info.Name), slog.Int("file_size", mi.Info.Length),
 	))
 
-	taskStream := make(chan client.PieceTask, len(pieceHashes)) // put buffer to unblock
+	var contentLength, totalLength int
+	for _, p := range pieces {
+		contentLength = max(contentLength, p.Offset+p.Length)
+		totalLength += p.Length
+	}
+
+	taskStream := make(chan client.PieceTask, len(pieces)) // put buffer to unblock
 	resultStream := make(chan client.PieceResult)
-	for _, peer := range peerList {
-		go client.StartDownloadClient(logger, peer, mi.InfoHash, peerID, taskStream, resultStream)
+
+	// the peers of every source are told the port of the listener, if any
+	port := uint16(peers.DefaultPort)
+	listener, err := startListener(logger)
+	if err != nil {
+		logger.Warn("failed to accept incoming peers", "error", err)
+	} else {
+		defer listener.Close()
+		port = listener.Port()
+	}
+
+	// each swarm connects to the peers of an info hash and exchanges peers with them
+	swarms := make(map[string]*client.Swarm)
+	for _, infoHash := range mi.InfoHashes() {
+		s := client.NewSwarm(logger, infoHash, peerID, mi.Info.Private, taskStream, resultStream)
+		s.Filter = ipFilter
+		s.Family = addressFamily
+		if listener != nil {
+			s.Port = port
+			listener.Add(s)
+		}
+		swarms[string(infoHash)] = s
+	}
+	connect := func(infoHash []byte, peerList []peers.Peer) {
+		swarms[string(infoHash)].Add(peerList)
+	}
+
+	// hybrid torrents can be downloaded from both the v1 and the v2 swarm
+	var downloaded atomic.Int64
+	stats := func() peers.TransferStats {
+		d := downloaded.Load()
+		return peers.TransferStats{Downloaded: d, Left: int64(totalLength) - d}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var (
+		wg         sync.WaitGroup
+		announcers []*peers.Announcer
+		errs       []error
+		// sourceFailed receives the errors of the trackers and the DHT once
+		// they failed to start in the background
+		sourceFailed = make(chan error, len(mi.InfoHashes())+1)
+	)
+	for _, infoHash := range mi.InfoHashes() {
+		// the swarms keep their own tracker order and tracker ids
+		trackers := peers.NewTrackerList(mi.AnnounceTiers(), addressFamily)
+		if len(trackers.Tiers()) == 0 {
+			break
+		}
+
+		a := peers.NewAnnouncer(logger, trackers, infoHash, peerID, port, stats)
+		announcers = append(announcers, a)
+
+		// the started event may go through every tier, the download does not wait for it
+		peerStream := make(chan []peers.Peer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startPeers, err := a.Start(ctx)
+			if err != nil {
+				sourceFailed <- err
+			}
+			connect(infoHash, startPeers)
+
+			a.Run(ctx, peerStream)
+		}()
+		go func() {
+			for {
+				select {
+				case newPeers := <-peerStream:
+					connect(infoHash, newPeers)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	// public torrents also find their peers on the DHT, trackerless ones only
+	// there. The node joins the network in the background so that the
+	// download starts with the peers of the other sources right away
+	var node *dht.Server
+	if dhtEnabled && peers.SourceDHT.Allowed(mi.Info.Private) {
+		var (
+			cached []string
+			err    error
+		)
+		node, cached, err = listenDHT(logger)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			defer stopDHT(logger, node)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := bootstrapDHT(ctx, node, append(cached, nodeAddrs(mi)...)); err != nil {
+					sourceFailed <- err
+					return
+				}
+
+				for _, infoHash := range mi.InfoHashes() {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						announceDHT(ctx, logger, node, infoHash, port, func(found []peers.Peer) {
+							connect(infoHash, found)
+						})
+					}()
+				}
+			}()
+		}
+	}
+
+	// peers of the local network are preferred as they keep the traffic local
+	var lanServices []*lsd.Service
+	if lsdEnabled && peers.SourceLSD.Allowed(mi.Info.Private) {
+		var err error
+		lanServices, err = startLSD(logger, port)
+		if err != nil {
+			logger.Warn("failed to start local service discovery", "error", err)
+		} else {
+			defer stopLSD(lanServices)
+
+			for _, infoHash := range mi.InfoHashes() {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					announceLSD(ctx, lanServices, infoHash, func(found []peers.Peer) {
+						connect(infoHash, found)
+					})
+				}()
+			}
+		}
+	}
+
+	// the stopped event is sent however the download ends
+	defer func() {
+		cancel()
+
+		stopped := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-time.After(stopTimeout):
+		}
+	}()
+
+	// web seeds can serve the whole content when no tracker responds, and the
+	// trackers, the DHT and the local network may find peers later on
+	if len(peerList) == 0 && len(announcers) == 0 && node == nil && len(lanServices) == 0 && len(mi.WebSeeds()) == 0 {
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+
+		return errors.New("torrent has no trackers, peers can only be found with the dht")
+	}
+
+	// the trackers and the DHT starting in the background
+	pending := len(announcers)
+	if node != nil {
+		pending++
+	}
+
+	if len(peerList) > 0 {
+		connect(mi.InfoHashes()[0], peerList)
+	}
+
+	for _, seedURL := range mi.WebSeeds() {
+		go client.StartWebSeedClient(logger, client.NewWebSeed(seedURL), mi, taskStream, resultStream)
 	}
 
-	for i := 0; i < len(pieceHashes); i++ {
+	for _, p := range pieces {
 		taskStream <- client.PieceTask{
-			Index:  i,
-			Hash:   pieceHashes[i],
-			Length: client.CalculatePieceLength(i, mi.Info.PieceLength, mi.Info.Length),
+			Piece: p,
 		}
 	}
 
-	resultBuf := make([]byte, mi.Info.Length)
-	bar := progressbar.DefaultBytes(int64(mi.Info.Length), "downloading")
+	resultBuf := make([]byte, contentLength)
+	bar := progressbar.DefaultBytes(int64(totalLength), "downloading")
 
 	// keep reading from resultStream until enough pieces are collected
 	var numResult int
-	for numResult < len(pieceHashes) {
-		res := <-resultStream
+	for numResult < len(pieces) {
+		var res client.PieceResult
+		select {
+		case res = <-resultStream:
+		case err := <-sourceFailed:
+			// the download cannot go on once every source of peers failed
+			errs = append(errs, err)
+			pending--
+			if pending == 0 && len(peerList) == 0 && len(lanServices) == 0 && len(mi.WebSeeds()) == 0 {
+				return errors.Join(errs...)
+			}
+			logger.Warn("failed to start a source of peers", "error", err)
+			continue
+		}
 		numResult++
 
-		start := mi.Info.PieceLength * res.Index
+		start := res.Offset
 		end := start + res.Length
 		copy(resultBuf[start:end], res.Data)
 		bar.Write(res.Data)
+		downloaded.Add(int64(res.Length))
+	}
+
+	for _, s := range swarms {
+		if listener != nil {
+			listener.Remove(s)
+		}
+		s.Close()
 	}
 	close(taskStream)
+	reportBlocked(logger)
+
+	for _, a := range announcers {
+		a.Complete()
+	}
 
 	// write to dest
 	if mi.Multifile {
-		var lengthWritten int
-		for _, file := range mi.Info.Files {
-			dirpath := filepath.Join(append([]string{outFile}, file.Path[:len(file.Path)-1]...)...)
-			if err := os.MkdirAll(dirpath, 0o755); err != nil {
-				return err
+		for _, file := range mi.ContentFiles() {
+			if file.Attr.Pad() {
+				continue
 			}
 
-			path := filepath.Join(dirpath, file.Path[len(file.Path)-1])
+			path, err := contentPath(outFile, file.Path)
+			if err != nil {
+				return err
+			}
 
-			if err = os.WriteFile(path, resultBuf[lengthWritten:lengthWritten+file.Length], 0o660); err != nil {
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
 				return err
 			}
 
-			lengthWritten += file.Length
+			if err := writeFile(outFile, path, file, resultBuf); err != nil {
+				return err
+			}
 		}
 
 	} else {
-		if err = os.WriteFile(outFile, resultBuf, 0o660); err != nil {
+		if err := writeFile(outFile, outFile, mi.ContentFiles()[0], resultBuf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// contentPath joins the path components of a content file to root, refusing
+// the components which would escape it
+func contentPath(root string, components []string) (string, error) {
+	if problems := metainfo.ValidatePath("path", components); len(problems) > 0 {
+		return "", fmt.Errorf("unsafe file path %q: %s", components, problems[0].Message)
+	}
+
+	return filepath.Join(append([]string{root}, components...)...), nil
+}
+
+// writeFile writes a file of the torrent content to path, applying the file
+// attributes described in BEP 47. Symbolic link targets are resolved from root
+func writeFile(root, path string, file metainfo.ContentFile, content []byte) error {
+	if file.Attr.Symlink() {
+		target, err := contentPath(root, file.SymlinkPath)
+		if err != nil {
+			return fmt.Errorf("symbolic link %s: %w", path, err)
+		}
+
+		rel, err := filepath.Rel(filepath.Dir(path), target)
+		if err != nil {
+			return err
+		}
+
+		if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
 			return err
 		}
+
+		return os.Symlink(rel, path)
+	}
+
+	var mode os.FileMode = 0o660
+	if file.Attr.Executable() {
+		mode = 0o770
+	}
+
+	if err := os.WriteFile(path, content[file.Offset:file.Offset+file.Length], mode); err != nil {
+		return err
+	}
+
+	// the mode is only applied to new files
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+
+	if file.Attr.Hidden() {
+		return setHidden(path)
 	}
 
 	return nil
 }
+
+func parseTorrentFile(path string) (*metainfo.Metainfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return metainfo.Parse(f)
+}
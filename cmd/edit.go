@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/spf13/cobra"
+)
+
+func editCmd() *cobra.Command {
+	var (
+		outfile       string
+		announce      []string
+		clearAnnounce bool
+		webSeeds      []string
+		clearSeeds    bool
+		comment       string
+		createdBy     string
+		source        string
+		private       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "edit [flags] TORRENT_FILE",
+		Short: "edit the metadata of a .torrent file without rehashing its content",
+		Long: `edit the metadata of a .torrent file without rehashing its content.
+Keys which are not edited are kept as is. Changing the source or the private
+flag modifies the info dictionary, which gives the torrent a new info hash`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				fmt.Printf("failed to read torrent file: %v\n", err)
+				os.Exit(1)
+			}
+
+			t, err := metainfo.ReadTorrent(bytes.NewReader(data))
+			if err != nil {
+				fmt.Printf("could not read torrent file: %v\n", err)
+				os.Exit(1)
+			}
+
+			before, err := t.Metainfo()
+			if err != nil {
+				fmt.Printf("could not read metainfo file: %v\n", err)
+				os.Exit(1)
+			}
+
+			if err = applyEdits(cmd, t, editFlags{
+				announce:      announce,
+				clearAnnounce: clearAnnounce,
+				webSeeds:      webSeeds,
+				clearSeeds:    clearSeeds,
+				comment:       comment,
+				createdBy:     createdBy,
+				source:        source,
+				private:       private,
+			}); err != nil {
+				fmt.Printf("failed to edit torrent: %v\n", err)
+				os.Exit(1)
+			}
+
+			after, err := t.Metainfo()
+			if err != nil {
+				fmt.Printf("edited torrent is invalid: %v\n", err)
+				os.Exit(1)
+			}
+
+			if len(outfile) == 0 {
+				outfile = args[0]
+			}
+
+			if t.InfoModified() {
+				fmt.Println("warning: the info dictionary changed, the torrent now belongs to a different swarm")
+				printInfoHashChange("Info Hash", before.InfoHash, after.InfoHash)
+				printInfoHashChange("Info Hash v2", before.InfoHashV2, after.InfoHashV2)
+			}
+
+			// the edited torrent keeps the permissions of the input
+			var mode os.FileMode = 0o644
+			if stat, err := os.Stat(args[0]); err == nil {
+				mode = stat.Mode().Perm()
+			}
+
+			if err = saveTorrent(outfile, mode, t); err != nil {
+				fmt.Printf("failed to write torrent file: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Saved %s\n", outfile)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outfile, "out", "o", "", "output torrent file name, defaults to overwriting the input")
+	cmd.Flags().StringArrayVarP(&announce, "announce", "a", nil, "replace the trackers, repeat for each tier and separate urls of the same tier with commas")
+	cmd.Flags().BoolVar(&clearAnnounce, "clear-announce", false, "remove every tracker")
+	cmd.Flags().StringArrayVarP(&webSeeds, "web-seed", "w", nil, "add a web seed url, can be repeated")
+	cmd.Flags().BoolVar(&clearSeeds, "clear-web-seeds", false, "remove the existing web seeds")
+	cmd.Flags().StringVarP(&comment, "comment", "c", "", "set the comment, an empty value removes it")
+	cmd.Flags().StringVar(&createdBy, "created-by", "", "set the name of the program creating the torrent, an empty value removes it")
+	cmd.Flags().StringVarP(&source, "source", "s", "", "set the source tag, an empty value removes it (changes the info hash)")
+	cmd.Flags().BoolVarP(&private, "private", "p", false, "set or, with --private=false, remove the private flag (changes the info hash)")
+	cmd.MarkFlagsMutuallyExclusive("announce", "clear-announce")
+
+	return cmd
+}
+
+type editFlags struct {
+	announce      []string
+	clearAnnounce bool
+	webSeeds      []string
+	clearSeeds    bool
+	comment       string
+	createdBy     string
+	source        string
+	private       bool
+}
+
+// saveTorrent replaces the torrent file at path at once, so that a failed
+// write never leaves the input torrent truncated
+func saveTorrent(path string, mode os.FileMode, t *metainfo.Torrent) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err = t.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// applyEdits applies the flags given on the command line, flags left out keep the current values
+func applyEdits(cmd *cobra.Command, t *metainfo.Torrent, flags editFlags) error {
+	if flags.clearAnnounce || len(flags.announce) > 0 {
+		if err := t.SetAnnounceTiers(parseTiers(flags.announce)); err != nil {
+			return err
+		}
+	}
+
+	if flags.clearSeeds || len(flags.webSeeds) > 0 {
+		var seeds []string
+		if !flags.clearSeeds {
+			existing, err := t.WebSeeds()
+			if err != nil {
+				return err
+			}
+			seeds = existing
+		}
+
+		for _, s := range flags.webSeeds {
+			if !slices.Contains(seeds, s) {
+				seeds = append(seeds, s)
+			}
+		}
+
+		if err := t.SetWebSeeds(seeds); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flags().Changed("comment") {
+		if err := t.SetComment(flags.comment); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flags().Changed("created-by") {
+		if err := t.SetCreatedBy(flags.createdBy); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flags().Changed("source") {
+		if err := t.SetSource(flags.source); err != nil {
+			return err
+		}
+	}
+
+	if cmd.Flags().Changed("private") {
+		if err := t.SetPrivate(flags.private); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func printInfoHashChange(name string, before, after []byte) {
+	if before != nil || after != nil {
+		fmt.Printf("• %s: %x -> %x\n", name, before, after)
+	}
+}
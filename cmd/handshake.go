@@ -2,16 +2,29 @@ package cmd
 
 import (
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"strings"
 
 	"github.com/kanowfy/btor/handshake"
 	"github.com/kanowfy/btor/metainfo"
 	"github.com/spf13/cobra"
 )
 
+// handshakeDocument is the structured output of the handshake command
+type handshakeDocument struct {
+	Peer       string   `json:"peer" yaml:"peer"`
+	Protocol   string   `json:"protocol" yaml:"protocol"`
+	InfoHash   string   `json:"info_hash" yaml:"info_hash"`
+	PeerID     string   `json:"peer_id" yaml:"peer_id"`
+	Reserved   string   `json:"reserved" yaml:"reserved"`
+	Extensions []string `json:"extensions" yaml:"extensions"`
+}
+
 func handshakeCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "handshake [torrent file] <peer_ip>:<peer_port>",
@@ -39,30 +52,53 @@ func handshakeCmd() *cobra.Command {
 				panic(err)
 			}
 
-			reply, err := getHandshakeMessage(args[1], m.InfoHash, peerID[:])
+			reply, err := getHandshakeMessage(args[1], m.InfoHashes()[0], peerID[:])
 			if err != nil {
 				fmt.Printf("could not exchange handshake: %v\n", err)
 				os.Exit(1)
 			}
 
-			fmt.Printf("Peer ID: %x\n", reply.PeerID)
-			return nil
+			doc := &handshakeDocument{
+				Peer:       args[1],
+				Protocol:   reply.Protocol,
+				InfoHash:   hex.EncodeToString(reply.InfoHash),
+				PeerID:     hex.EncodeToString(reply.PeerID),
+				Reserved:   hex.EncodeToString(reply.Reserved),
+				Extensions: reply.Extensions(),
+			}
+			if doc.Extensions == nil {
+				doc.Extensions = []string{}
+			}
+
+			return printDocument(doc, func() {
+				fmt.Printf("Peer ID: %s\n", doc.PeerID)
+				fmt.Printf("Reserved: %s\n", doc.Reserved)
+				if len(doc.Extensions) > 0 {
+					fmt.Printf("Extensions: %s\n", strings.Join(doc.Extensions, ", "))
+				}
+			})
 		},
 	}
 }
 
 func getHandshakeMessage(addr string, infoHash []byte, peerID []byte) (*handshake.Handshake, error) {
-	resolvedAddr, err := net.ResolveTCPAddr("tcp", addr)
+	network := addressFamily.Network("tcp")
+	resolvedAddr, err := net.ResolveTCPAddr(network, addr)
 	if err != nil {
 		return nil, fmt.Errorf("invalid peer address: %q", addr)
 	}
 
-	conn, err := net.DialTCP("tcp", nil, resolvedAddr)
+	if ip, ok := netip.AddrFromSlice(resolvedAddr.IP); ok && ipFilter.Reject(ip) {
+		return nil, fmt.Errorf("peer %s is blocked by the ip filter", addr)
+	}
+
+	conn, err := net.DialTCP(network, nil, resolvedAddr)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
 
-	h, err := handshake.InitHandshake(conn, infoHash, peerID)
+	h, err := handshake.ExchangeWithOptions(conn, handshake.New(infoHash, peerID), handshake.ExchangeOptions{Timeout: handshake.DefaultTimeout})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package cmd
+
+// setHidden does nothing, files are hidden by a leading dot in their name
+// rather than by an attribute on other systems
+func setHidden(path string) error {
+	return nil
+}
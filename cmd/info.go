@@ -1,15 +1,50 @@
 package cmd
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/kanowfy/btor/metainfo"
 	"github.com/spf13/cobra"
 )
 
+// infoDocument is the structured output of the info command
+type infoDocument struct {
+	Name         string         `json:"name" yaml:"name"`
+	Version      string         `json:"version" yaml:"version"`
+	InfoHash     string         `json:"info_hash,omitempty" yaml:"info_hash,omitempty"`
+	InfoHashV2   string         `json:"info_hash_v2,omitempty" yaml:"info_hash_v2,omitempty"`
+	Private      bool           `json:"private" yaml:"private"`
+	Source       string         `json:"source,omitempty" yaml:"source,omitempty"`
+	Comment      string         `json:"comment,omitempty" yaml:"comment,omitempty"`
+	CreatedBy    string         `json:"created_by,omitempty" yaml:"created_by,omitempty"`
+	CreationDate *time.Time     `json:"creation_date,omitempty" yaml:"creation_date,omitempty"`
+	Encoding     string         `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Trackers     [][]string     `json:"trackers" yaml:"trackers"`
+	WebSeeds     []string       `json:"web_seeds,omitempty" yaml:"web_seeds,omitempty"`
+	Length       int            `json:"length" yaml:"length"`
+	MD5Sum       string         `json:"md5sum,omitempty" yaml:"md5sum,omitempty"`
+	PieceLength  int            `json:"piece_length" yaml:"piece_length"`
+	PieceHashes  []string       `json:"piece_hashes,omitempty" yaml:"piece_hashes,omitempty"`
+	Files        []fileDocument `json:"files,omitempty" yaml:"files,omitempty"`
+	// OtherKeys are the keys btor does not interpret, info dictionary keys are prefixed with info.
+	OtherKeys []string `json:"other_keys,omitempty" yaml:"other_keys,omitempty"`
+}
+
+type fileDocument struct {
+	Path        string `json:"path" yaml:"path"`
+	Length      int    `json:"length" yaml:"length"`
+	Attr        string `json:"attr,omitempty" yaml:"attr,omitempty"`
+	SymlinkPath string `json:"symlink_path,omitempty" yaml:"symlink_path,omitempty"`
+	MD5Sum      string `json:"md5sum,omitempty" yaml:"md5sum,omitempty"`
+	PiecesRoot  string `json:"pieces_root,omitempty" yaml:"pieces_root,omitempty"`
+}
+
 func infoCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "info [torrent file]",
@@ -32,32 +67,166 @@ func infoCmd() *cobra.Command {
 				os.Exit(1)
 			}
 
-			fmt.Printf("• Name: %s\n", m.Info.Name)
-			fmt.Printf("• Tracker URL: %s\n", m.Announce)
-			if !m.Multifile {
-				fmt.Printf("• File Length: %d\n", m.Info.Length)
-			} else {
-				var size int
-				for _, f := range m.Info.Files {
-					size += f.Length
-				}
-				fmt.Printf("• Total Files/Size: %d files/%dB\n", len(m.Info.Files), size)
-				fmt.Println("• Files:")
-				for i := range len(m.Info.Files) {
-					fmt.Printf("%d. %s/%s\n", i+1, m.Info.Name, strings.Join(m.Info.Files[i].Path, "/"))
-				}
+			doc := newInfoDocument(m)
+			return printDocument(doc, func() {
+				printInfoTable(doc, m.Multifile)
+			})
+		},
+	}
+}
+
+func newInfoDocument(m *metainfo.Metainfo) *infoDocument {
+	doc := &infoDocument{
+		Name:        m.Info.Name,
+		Version:     "v1",
+		InfoHash:    hex.EncodeToString(m.InfoHash),
+		InfoHashV2:  hex.EncodeToString(m.InfoHashV2),
+		Private:     m.Info.Private,
+		Source:      m.Info.Source,
+		Comment:     m.Comment,
+		CreatedBy:   m.CreatedBy,
+		Encoding:    m.Encoding,
+		Trackers:    m.AnnounceTiers(),
+		WebSeeds:    m.WebSeeds(),
+		Length:      m.Info.Length,
+		MD5Sum:      m.Info.MD5Sum,
+		PieceLength: m.Info.PieceLength,
+		OtherKeys:   extraKeys(m),
+	}
+
+	switch {
+	case m.IsHybrid():
+		doc.Version = "hybrid"
+	case m.IsV2():
+		doc.Version = "v2"
+	}
+
+	if !m.CreationDate.IsZero() {
+		date := m.CreationDate.UTC()
+		doc.CreationDate = &date
+	}
+
+	for _, h := range m.PieceHashes() {
+		doc.PieceHashes = append(doc.PieceHashes, hex.EncodeToString(h))
+	}
+
+	if !m.Multifile {
+		return doc
+	}
+
+	if m.IsV1() {
+		for _, f := range m.Info.Files {
+			if f.Attr.Pad() {
+				continue
 			}
-			/*
-				fmt.Printf("Info Hash: %x\n", m.InfoHash)
 
-				pieceHashes := m.PieceHashes()
-				fmt.Println("Piece Hashes:")
-				for _, h := range pieceHashes {
-					fmt.Printf("%x\n", h)
-				}
-			*/
+			doc.Files = append(doc.Files, fileDocument{
+				Path:        strings.Join(f.Path, "/"),
+				Length:      f.Length,
+				Attr:        string(f.Attr),
+				SymlinkPath: strings.Join(f.SymlinkPath, "/"),
+				MD5Sum:      f.MD5Sum,
+			})
+		}
+	}
 
-			return nil
-		},
+	// v2 only torrents describe their files in the file tree, hybrid ones add
+	// the pieces roots to the v1 files of the same path
+	files := make(map[string]int, len(doc.Files))
+	for i, f := range doc.Files {
+		files[f.Path] = i
 	}
+	for _, f := range m.FilesV2 {
+		path := strings.Join(f.Path, "/")
+		if !m.IsV1() {
+			files[path] = len(doc.Files)
+			doc.Files = append(doc.Files, fileDocument{
+				Path:        path,
+				Length:      f.Length,
+				Attr:        string(f.Attr),
+				SymlinkPath: strings.Join(f.SymlinkPath, "/"),
+			})
+		}
+
+		if i, ok := files[path]; ok {
+			doc.Files[i].PiecesRoot = hex.EncodeToString(f.PiecesRoot)
+		}
+	}
+
+	return doc
+}
+
+func printInfoTable(doc *infoDocument, multifile bool) {
+	fmt.Printf("• Name: %s\n", doc.Name)
+	switch doc.Version {
+	case "hybrid":
+		fmt.Println("• Version: hybrid (v1 + v2)")
+	case "v2":
+		fmt.Println("• Version: v2")
+	}
+	if len(doc.InfoHash) > 0 {
+		fmt.Printf("• Info Hash: %s\n", doc.InfoHash)
+	}
+	if len(doc.InfoHashV2) > 0 {
+		fmt.Printf("• Info Hash v2: %s\n", doc.InfoHashV2)
+	}
+	if doc.Private {
+		fmt.Println("• Private: yes")
+	}
+	if len(doc.Source) > 0 {
+		fmt.Printf("• Source: %s\n", doc.Source)
+	}
+	if len(doc.Comment) > 0 {
+		fmt.Printf("• Comment: %s\n", doc.Comment)
+	}
+	if len(doc.CreatedBy) > 0 {
+		fmt.Printf("• Created By: %s\n", doc.CreatedBy)
+	}
+	if doc.CreationDate != nil {
+		fmt.Printf("• Creation Date: %s\n", doc.CreationDate.Format(time.RFC1123))
+	}
+	if len(doc.Encoding) > 0 {
+		fmt.Printf("• Encoding: %s\n", doc.Encoding)
+	}
+	if len(doc.Trackers) == 1 && len(doc.Trackers[0]) == 1 {
+		fmt.Printf("• Tracker URL: %s\n", doc.Trackers[0][0])
+	} else if len(doc.Trackers) > 0 {
+		fmt.Println("• Trackers:")
+		for i, tier := range doc.Trackers {
+			fmt.Printf("  Tier %d: %s\n", i+1, strings.Join(tier, ", "))
+		}
+	}
+	if !multifile {
+		fmt.Printf("• File Length: %d\n", doc.Length)
+		if len(doc.MD5Sum) > 0 {
+			fmt.Printf("• MD5: %s\n", doc.MD5Sum)
+		}
+	} else {
+		fmt.Printf("• Total Files/Size: %d files/%dB\n", len(doc.Files), doc.Length)
+		fmt.Println("• Files:")
+		for i, f := range doc.Files {
+			fmt.Printf("%d. %s/%s", i+1, doc.Name, f.Path)
+			if len(f.MD5Sum) > 0 {
+				fmt.Printf(" (md5 %s)", f.MD5Sum)
+			}
+			fmt.Println()
+		}
+	}
+	if len(doc.OtherKeys) > 0 {
+		fmt.Printf("• Other Keys: %s\n", strings.Join(doc.OtherKeys, ", "))
+	}
+}
+
+// extraKeys lists the keys of the torrent and its info dictionary which btor does not interpret
+func extraKeys(m *metainfo.Metainfo) []string {
+	var keys []string
+	for k := range m.Extra {
+		keys = append(keys, k)
+	}
+	for k := range m.Info.Extra {
+		keys = append(keys, "info."+k)
+	}
+	slices.Sort(keys)
+
+	return keys
 }
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/kanowfy/btor/ipfilter"
+)
+
+var (
+	ipFilterPaths []string
+	// ipFilter blocks the peers of the ip filter lists, nil when none is given
+	ipFilter *ipfilter.Filter
+)
+
+// loadIPFilter loads the ip filter lists given on the command line
+func loadIPFilter() error {
+	if len(ipFilterPaths) == 0 {
+		return nil
+	}
+
+	f, err := ipfilter.LoadFiles(ipFilterPaths...)
+	if err != nil {
+		return fmt.Errorf("failed to load ip filter: %w", err)
+	}
+
+	ipFilter = f
+	slog.Info("loaded ip filter", slog.Int("ranges", f.Len()))
+	return nil
+}
+
+// reportBlocked tells how many peers the ip filter blocked
+func reportBlocked(logger *slog.Logger) {
+	if n := ipFilter.Blocked(); n > 0 {
+		logger.Info("peers blocked by ip filter", slog.Int64("count", n))
+		fmt.Printf("Blocked %d peers with the ip filter\n", n)
+	}
+}
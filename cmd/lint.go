@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/spf13/cobra"
+)
+
+func lintCmd() *cobra.Command {
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "lint [flags] TORRENT_FILE...",
+		Short: "report structural problems of torrent files",
+		Long:  "report structural problems of torrent files, exits with a non-zero status when an error is found, or a warning in strict mode",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var failed bool
+			for _, path := range args {
+				problems, err := lintFile(path)
+				if err != nil {
+					fmt.Printf("%s: could not read torrent file: %v\n", path, err)
+					failed = true
+					continue
+				}
+
+				if len(problems) == 0 {
+					fmt.Printf("%s: ok\n", path)
+					continue
+				}
+
+				for _, p := range problems {
+					fmt.Printf("%s: %s\n", path, p)
+				}
+
+				if metainfo.HasErrors(problems) || strict {
+					failed = true
+				}
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().BoolVar(&strict, "strict", false, "fail on warnings too")
+
+	return cmd
+}
+
+func lintFile(path string) ([]metainfo.Problem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return metainfo.Validate(f)
+}
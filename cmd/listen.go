@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/peers"
+	"github.com/spf13/cobra"
+)
+
+var (
+	listenPort  int
+	maxIncoming int
+)
+
+// addListenFlags adds the flags of the commands which accept incoming peers
+func addListenFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&listenPort, "port", peers.DefaultPort, "tcp port to accept peers on, a random port is used when it is taken")
+	cmd.Flags().IntVar(&maxIncoming, "max-incoming", client.DefaultMaxIncoming, "maximum number of incoming peer connections at once")
+}
+
+// startListener accepts the incoming peers, those blocked by the ip filter are rejected
+func startListener(logger *slog.Logger) (*client.Listener, error) {
+	if listenPort < 0 || listenPort > 65535 {
+		return nil, errors.New("port must be between 0 and 65535")
+	}
+
+	l, err := client.Listen(logger, addressFamily, uint16(listenPort))
+	if err != nil {
+		// another client may be using the port
+		l, err = client.Listen(logger, addressFamily, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	l.MaxConns = maxIncoming
+	l.Filter = ipFilter
+
+	go func() {
+		if err := l.Serve(); err != nil {
+			logger.Error("stopped accepting peers", "error", err)
+		}
+	}()
+
+	return l, nil
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/kanowfy/btor/lsd"
+	"github.com/kanowfy/btor/peers"
+	"github.com/spf13/cobra"
+)
+
+var lsdEnabled bool
+
+// addLSDFlags adds the flags of the commands which may find peers on the local network
+func addLSDFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&lsdEnabled, "lsd", true, "find peers on the local network, never used for private torrents")
+}
+
+// startLSD joins the local service discovery groups of the address family to
+// announce the port peers reach us on, failing only when no group can be joined
+func startLSD(logger *slog.Logger, port uint16) ([]*lsd.Service, error) {
+	var networks []string
+	if addressFamily != peers.FamilyIPv6 {
+		networks = append(networks, "udp4")
+	}
+	if addressFamily != peers.FamilyIPv4 {
+		networks = append(networks, "udp6")
+	}
+
+	var (
+		services []*lsd.Service
+		errs     []error
+	)
+	for _, network := range networks {
+		s, err := lsd.Listen(logger, network, port)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		services = append(services, s)
+	}
+
+	if len(services) == 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	for _, err := range errs {
+		logger.Warn("failed to join local service discovery group", "error", err)
+	}
+
+	return services, nil
+}
+
+// stopLSD stops the local service discovery
+func stopLSD(services []*lsd.Service) {
+	for _, s := range services {
+		s.Close()
+	}
+}
+
+// announceLSD keeps a download announced on the local network until the
+// context is done, the local peers are handed to connect
+func announceLSD(ctx context.Context, services []*lsd.Service, infoHash []byte, connect func([]peers.Peer)) {
+	done := make(chan struct{})
+	for _, s := range services {
+		go func() {
+			s.Run(ctx, infoHash, connect)
+			done <- struct{}{}
+		}()
+	}
+
+	for range services {
+		<-done
+	}
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kanowfy/btor/client"
+	"github.com/kanowfy/btor/magnet"
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/kanowfy/btor/peers"
+	"github.com/spf13/cobra"
+)
+
+// magnetAnnounceLeft is reported as the amount left to download while the
+// metadata is unknown, any non-zero value makes the tracker treat us as a leecher
+const magnetAnnounceLeft = 1
+
+func magnet2torrentCmd() *cobra.Command {
+	var outfile string
+	cmd := &cobra.Command{
+		Use:   "magnet2torrent -o OUT_FILE MAGNET_URI",
+		Short: "fetch the metadata of a magnet link from peers and save it as a .torrent file",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var peerID [20]byte
+			if _, err := rand.Read(peerID[:]); err != nil {
+				panic(err)
+			}
+
+			mi, infoDict, _, err := resolveMagnet(args[0], peerID[:])
+			if err != nil {
+				fmt.Printf("failed to resolve magnet link: %v\n", err)
+				os.Exit(1)
+			}
+
+			f, err := os.Create(outfile)
+			if err != nil {
+				fmt.Printf("failed to create torrent file: %v\n", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			if err = metainfo.WriteTorrent(f, infoDict, mi.AnnounceTiers()); err != nil {
+				fmt.Printf("failed to write torrent file: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Saved %s to %s\n", mi.Info.Name, outfile)
+		},
+	}
+
+	cmd.Flags().StringVarP(&outfile, "out", "o", "", "output torrent file name")
+	cmd.MarkFlagRequired("out")
+	addDHTFlags(cmd)
+
+	return cmd
+}
+
+func isMagnet(source string) bool {
+	return strings.HasPrefix(source, "magnet:")
+}
+
+// resolveMagnet finds peers for a magnet link from its trackers and peer
+// addresses, or the DHT, then fetches the info dictionary from the first peer able to
+// provide it. It returns the resulting metainfo, the raw info dictionary and
+// the peers allowed to download the torrent
+func resolveMagnet(uri string, peerID []byte) (*metainfo.Metainfo, []byte, []peers.Peer, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// every tracker of a magnet link is placed in its own tier
+	var tiers [][]string
+	for _, tr := range m.Trackers {
+		tiers = append(tiers, []string{tr})
+	}
+
+	logger := slog.Default().With(slog.String("info_hash", fmt.Sprintf("%x", m.InfoHash)))
+
+	// a malformed peer address does not make the other sources unusable
+	var manualPeers []peers.Peer
+	for _, addr := range m.Peers {
+		peer, err := parsePeerAddr(addr)
+		if err != nil {
+			logger.Warn("skipping peer of the magnet link", "error", err)
+			continue
+		}
+
+		if addressFamily.Allows(peer.IP) {
+			manualPeers = append(manualPeers, peer)
+		}
+	}
+
+	var (
+		trackerPeers []peers.Peer
+		errs         []error
+	)
+	if len(tiers) > 0 {
+		trackerPeers, err = peers.NewTrackerList(tiers, addressFamily).Fetch(context.Background(), m.InfoHash, magnetAnnounceLeft, peerID)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// the DHT is only looked up when the magnet link leads to no other peers,
+	// whether the torrent is private is not known yet
+	var dhtPeers []peers.Peer
+	if len(manualPeers) == 0 && len(trackerPeers) == 0 && dhtEnabled {
+		dhtPeers, err = findDHTPeers(logger, m.InfoHash)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	manualPeers = ipFilter.Allowed(manualPeers)
+	trackerPeers = ipFilter.Allowed(trackerPeers)
+	dhtPeers = ipFilter.Allowed(dhtPeers)
+
+	candidates := append(append(manualPeers, trackerPeers...), dhtPeers...)
+	if len(candidates) == 0 {
+		if len(errs) > 0 {
+			return nil, nil, nil, errors.Join(errs...)
+		}
+		if ipFilter.Blocked() > 0 {
+			return nil, nil, nil, errors.New("every peer of the magnet link is blocked by the ip filter")
+		}
+		return nil, nil, nil, errors.New("magnet link has no trackers or peers")
+	}
+
+	for _, peer := range candidates {
+		infoDict, err := client.FetchMetadata(logger, addressFamily, peer, m.InfoHash, peerID)
+		if err != nil {
+			continue
+		}
+
+		mi, err := metainfo.FromInfoDict(infoDict, tiers)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// whether the torrent is private is only known once the metadata is
+		// fetched, the peers given in the magnet link or found on the DHT are
+		// dropped from then on
+		peerList := trackerPeers
+		if peers.SourceManual.Allowed(mi.Info.Private) {
+			peerList = append(peerList, manualPeers...)
+		}
+		if peers.SourceDHT.Allowed(mi.Info.Private) {
+			peerList = append(peerList, dhtPeers...)
+		}
+
+		return mi, infoDict, peerList, nil
+	}
+
+	return nil, nil, nil, errors.New("could not fetch metadata from any peer")
+}
+
+func parsePeerAddr(addr string) (peers.Peer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return peers.Peer{}, fmt.Errorf("invalid peer address: %q", addr)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return peers.Peer{}, fmt.Errorf("invalid peer address: %q", addr)
+	}
+
+	return peers.Peer{
+		IP:   host,
+		Port: uint16(p),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputYAML}
+
+// outputFormat is the format of the documents printed by info, peers, handshake and scrape
+var outputFormat = outputTable
+
+func validateOutputFormat() error {
+	if !slices.Contains(outputFormats, outputFormat) {
+		return fmt.Errorf("unknown output format %q, must be one of table, json or yaml", outputFormat)
+	}
+
+	return nil
+}
+
+// printDocument writes the document to stdout in the selected format, the
+// table format is left to the human readable printer
+func printDocument(doc interface{}, table func()) error {
+	switch outputFormat {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case outputYAML:
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		table()
+		return nil
+	}
+}
@@ -1,18 +1,33 @@
 package cmd
 
 import (
+	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"os"
+	"strconv"
 
+	"github.com/kanowfy/btor/dht"
 	"github.com/kanowfy/btor/metainfo"
 	"github.com/kanowfy/btor/peers"
 	"github.com/spf13/cobra"
 )
 
+// peerDocument is a peer record of the peers command
+type peerDocument struct {
+	IP       string `json:"ip" yaml:"ip"`
+	Port     uint16 `json:"port" yaml:"port"`
+	Source   string `json:"source" yaml:"source"`
+	PeerID   string `json:"peer_id,omitempty" yaml:"peer_id,omitempty"`
+	InfoHash string `json:"info_hash" yaml:"info_hash"`
+}
+
 func peersCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "peers [torrent file]",
 		Short: "fetch peers from tracker url and print to stard out",
 		Args:  cobra.MinimumNArgs(1),
@@ -38,17 +53,64 @@ func peersCmd() *cobra.Command {
 				panic(err)
 			}
 
-			peerList, err := peers.Fetch(m.Announce, m.InfoHash, m.Info.Length, peerID[:])
-			if err != nil {
-				fmt.Printf("failed to fetch peers: %v\n", err)
-				os.Exit(1)
+			// trackerless torrents only have their DHT peers listed
+			var node *dht.Server
+			if dhtEnabled && peers.SourceDHT.Allowed(m.Info.Private) {
+				node, err = startDHT(context.Background(), slog.Default(), nodeAddrs(m))
+				if err != nil && len(m.AnnounceTiers()) == 0 {
+					fmt.Printf("failed to fetch peers: %v\n", err)
+					os.Exit(1)
+				}
+				if node != nil {
+					defer stopDHT(slog.Default(), node)
+				}
 			}
 
-			for _, p := range peerList {
-				fmt.Printf("%s:%d\n", p.IP, p.Port)
+			docs := []peerDocument{}
+			addPeers := func(infoHash []byte, source peers.Source, peerList []peers.Peer) {
+				for _, p := range ipFilter.Allowed(peerList) {
+					docs = append(docs, peerDocument{
+						IP:       p.IP,
+						Port:     p.Port,
+						Source:   source.String(),
+						PeerID:   hex.EncodeToString([]byte(p.ID)),
+						InfoHash: hex.EncodeToString(infoHash),
+					})
+				}
 			}
 
-			return nil
+			trackers := peers.NewTrackerList(m.AnnounceTiers(), addressFamily)
+			for _, infoHash := range m.InfoHashes() {
+				if len(trackers.Tiers()) > 0 {
+					peerList, err := trackers.Fetch(context.Background(), infoHash, m.Info.Length, peerID[:])
+					if err != nil {
+						fmt.Printf("failed to fetch peers: %v\n", err)
+						os.Exit(1)
+					}
+					addPeers(infoHash, peers.SourceTracker, peerList)
+				}
+
+				if node != nil {
+					ctx, cancel := context.WithTimeout(context.Background(), dhtLookupTimeout)
+					peerList, err := node.GetPeers(ctx, infoHash)
+					cancel()
+					if err != nil {
+						fmt.Printf("failed to fetch dht peers: %v\n", err)
+						os.Exit(1)
+					}
+					addPeers(infoHash, peers.SourceDHT, peerList)
+				}
+			}
+
+			return printDocument(docs, func() {
+				for _, p := range docs {
+					fmt.Println(net.JoinHostPort(p.IP, strconv.Itoa(int(p.Port))))
+				}
+			})
 		},
 	}
+
+	addDHTFlags(cmd)
+
+	return cmd
 }
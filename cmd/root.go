@@ -4,28 +4,58 @@ import (
 	"log/slog"
 	"os"
 
+	"github.com/kanowfy/btor/peers"
 	"github.com/spf13/cobra"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// addressFamily is the address family used to reach trackers and peers,
+// picked by the address-family flag
+var addressFamily = peers.FamilyAny
+
 func Execute() {
 	var root = &cobra.Command{
 		Use: "btor",
 	}
 
-	root.AddCommand(decodeCmd(), infoCmd(), peersCmd(), handshakeCmd(), downloadFileCmd())
+	root.AddCommand(decodeCmd(), infoCmd(), peersCmd(), handshakeCmd(), downloadFileCmd(), magnet2torrentCmd(), createCmd(), editCmd(), lintCmd(), scrapeCmd(), trackerCmd())
+
+	root.PersistentFlags().StringVar(&outputFormat, "output", outputTable, "output format of info, peers, handshake and scrape: table, json or yaml")
+
+	var family string
+	root.PersistentFlags().StringVar(&family, "address-family", "any", "address family used to reach trackers and peers: any, ipv4 or ipv6")
+
+	root.PersistentFlags().StringSliceVar(&ipFilterPaths, "ip-filter", nil, "ip filter lists of blocked peers, in eMule ipfilter.dat, PeerGuardian P2P or CIDR format")
 
 	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := validateOutputFormat(); err != nil {
+			return err
+		}
+
+		f, err := peers.ParseFamily(family)
+		if err != nil {
+			return err
+		}
+		addressFamily = f
+
 		if err := setupLogger(); err != nil {
 			return err
 		}
 
+		if err := loadIPFilter(); err != nil {
+			return err
+		}
+
 		return nil
 	}
 
 	root.Example = `Download from example.torrent file in home directory and save to example.txt file in the Download directory
 
-	$ btor download ~/examplefile.torrent -o ~/Downloads/example.txt`
+	$ btor download ~/examplefile.torrent -o ~/Downloads/example.txt
+
+Download from a magnet link
+
+	$ btor download 'magnet:?xt=urn:btih:...' -o ~/Downloads/example.txt`
 	root.Execute()
 }
 
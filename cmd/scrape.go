@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/kanowfy/btor/metainfo"
+	"github.com/kanowfy/btor/peers"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+// maxConcurrentScrapes is the number of trackers scraped at the same time
+const maxConcurrentScrapes = 8
+
+// scrapeDocument is the swarm health of a torrent reported by the scrape command
+type scrapeDocument struct {
+	Torrent string `json:"torrent" yaml:"torrent"`
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Seeders, Leechers and Completed are those of the tracker reporting the most seeders
+	Seeders   int                     `json:"seeders" yaml:"seeders"`
+	Leechers  int                     `json:"leechers" yaml:"leechers"`
+	Completed int                     `json:"completed" yaml:"completed"`
+	Alive     bool                    `json:"alive" yaml:"alive"`
+	Trackers  []trackerScrapeDocument `json:"trackers" yaml:"trackers"`
+	Error     string                  `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type trackerScrapeDocument struct {
+	URL       string `json:"url" yaml:"url"`
+	InfoHash  string `json:"info_hash" yaml:"info_hash"`
+	Seeders   int    `json:"seeders" yaml:"seeders"`
+	Leechers  int    `json:"leechers" yaml:"leechers"`
+	Completed int    `json:"completed" yaml:"completed"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func scrapeCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "scrape TORRENT_FILE...",
+		Short: "report the number of seeders and leechers of torrents from their trackers",
+		Long:  "report the number of seeders and leechers of torrents from their trackers, the info hashes of torrents sharing a tracker are scraped together",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			docs := make([]scrapeDocument, len(args))
+			torrents := make([]*metainfo.Metainfo, len(args))
+			for i, path := range args {
+				docs[i] = scrapeDocument{Torrent: path, Trackers: []trackerScrapeDocument{}}
+
+				m, err := parseTorrentFile(path)
+				if err != nil {
+					docs[i].Error = fmt.Sprintf("could not read torrent file: %v", err)
+					continue
+				}
+
+				docs[i].Name = m.Info.Name
+				torrents[i] = m
+			}
+
+			scrapeTorrents(torrents, docs)
+
+			err := printDocument(docs, func() {
+				for _, doc := range docs {
+					if len(doc.Error) > 0 {
+						fmt.Printf("%s: %s\n", doc.Torrent, doc.Error)
+						continue
+					}
+
+					state := "dead"
+					if doc.Alive {
+						state = "alive"
+					}
+					fmt.Printf("%s: %d seeders, %d leechers, %d completed (%s)\n", doc.Torrent, doc.Seeders, doc.Leechers, doc.Completed, state)
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, doc := range docs {
+				if len(doc.Error) > 0 {
+					os.Exit(1)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+// scrapeTorrents scrapes every tracker once with the info hashes of all the
+// torrents announcing to it and fills in the documents of the torrents
+func scrapeTorrents(torrents []*metainfo.Metainfo, docs []scrapeDocument) {
+	type swarm struct {
+		torrent  int
+		infoHash []byte
+	}
+
+	var trackers []string
+	swarms := make(map[string][]swarm)
+	for i, m := range torrents {
+		if m == nil {
+			continue
+		}
+
+		for _, tier := range m.AnnounceTiers() {
+			for _, trackerUrl := range tier {
+				if _, ok := swarms[trackerUrl]; !ok {
+					trackers = append(trackers, trackerUrl)
+				}
+
+				for _, infoHash := range m.InfoHashes() {
+					swarms[trackerUrl] = append(swarms[trackerUrl], swarm{i, infoHash})
+				}
+			}
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		egr errgroup.Group
+	)
+	egr.SetLimit(maxConcurrentScrapes)
+	for _, trackerUrl := range trackers {
+		egr.Go(func() error {
+			infoHashes := make([][]byte, len(swarms[trackerUrl]))
+			for i, s := range swarms[trackerUrl] {
+				infoHashes[i] = s.infoHash
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), peers.DefaultAnnounceTimeout)
+			results, err := peers.Scrape(ctx, trackerUrl, addressFamily, infoHashes)
+			cancel()
+
+			mu.Lock()
+			defer mu.Unlock()
+			for i, s := range swarms[trackerUrl] {
+				tracker := trackerScrapeDocument{
+					URL:      trackerUrl,
+					InfoHash: hex.EncodeToString(s.infoHash),
+				}
+
+				if err != nil {
+					tracker.Error = err.Error()
+				} else {
+					tracker.Seeders = results[i].Seeders
+					tracker.Leechers = results[i].Leechers
+					tracker.Completed = results[i].Completed
+				}
+
+				docs[s.torrent].Trackers = append(docs[s.torrent].Trackers, tracker)
+			}
+
+			return nil
+		})
+	}
+	egr.Wait()
+
+	for i := range docs {
+		if torrents[i] == nil {
+			continue
+		}
+
+		// the trackers are reported in the order of the tiers
+		slices.SortStableFunc(docs[i].Trackers, func(a, b trackerScrapeDocument) int {
+			return slices.Index(trackers, a.URL) - slices.Index(trackers, b.URL)
+		})
+
+		var answered bool
+		for _, tracker := range docs[i].Trackers {
+			if len(tracker.Error) > 0 {
+				continue
+			}
+
+			if !answered || tracker.Seeders > docs[i].Seeders {
+				docs[i].Seeders = tracker.Seeders
+				docs[i].Leechers = tracker.Leechers
+				docs[i].Completed = tracker.Completed
+			}
+			answered = true
+		}
+
+		if !answered {
+			docs[i].Error = "no tracker answered the scrape"
+		}
+		docs[i].Alive = docs[i].Seeders > 0
+	}
+}
@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/kanowfy/btor/tracker"
+	"github.com/spf13/cobra"
+)
+
+func trackerCmd() *cobra.Command {
+	var (
+		httpAddr  string
+		udpAddr   string
+		allow     []string
+		interval  time.Duration
+		maxSwarms int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "tracker",
+		Short: "run a tracker serving announces and scrapes over http and optionally udp",
+		Long:  "run a tracker serving announces and scrapes on /announce and /scrape over http and optionally udp, its stats are served as JSON on /stats. The swarms are kept in memory",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger := slog.Default().With(slog.String("component", "tracker"))
+
+			s := tracker.New(logger)
+			defer s.Close()
+			s.Interval = interval
+			s.MaxSwarms = maxSwarms
+
+			for _, a := range allow {
+				infoHashes, err := allowedInfoHashes(a)
+				if err != nil {
+					fmt.Printf("invalid allowed torrent %s: %v\n", a, err)
+					os.Exit(1)
+				}
+				s.Allow(infoHashes...)
+			}
+
+			if err := runTracker(s, httpAddr, udpAddr); err != nil {
+				fmt.Printf("tracker failed: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&httpAddr, "http", ":6969", "address of the http tracker")
+	cmd.Flags().StringVar(&udpAddr, "udp", "", "address of the udp tracker, disabled when empty")
+	cmd.Flags().StringSliceVar(&allow, "allow", nil, "torrent files or hex info hashes to serve, every torrent is served when empty")
+	cmd.Flags().DurationVar(&interval, "interval", tracker.DefaultInterval, "time peers are asked to wait between announces")
+	cmd.Flags().IntVar(&maxSwarms, "max-swarms", tracker.DefaultMaxSwarms, "maximum number of torrents tracked at once")
+
+	return cmd
+}
+
+// allowedInfoHashes returns the info hash given in hex or the info hashes of a torrent file
+func allowedInfoHashes(s string) ([][]byte, error) {
+	if infoHash, err := hex.DecodeString(s); err == nil && len(infoHash) == 20 {
+		return [][]byte{infoHash}, nil
+	}
+
+	m, err := parseTorrentFile(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.InfoHashes(), nil
+}
+
+// runTracker serves the tracker until an interrupt
+func runTracker(s *tracker.Server, httpAddr, udpAddr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	errs := make(chan error, 2)
+
+	ln, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+	}()
+	defer srv.Close()
+	fmt.Printf("http tracker listening on http://%s/announce\n", ln.Addr())
+
+	if len(udpAddr) > 0 {
+		conn, err := net.ListenPacket("udp", udpAddr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		go func() {
+			if err := s.ServeUDP(conn); err != nil {
+				errs <- err
+			}
+		}()
+		fmt.Printf("udp tracker listening on udp://%s/announce\n", conn.LocalAddr())
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
@@ -0,0 +1,72 @@
+package dht
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// nodeCache is the encoding of a node cache file
+type nodeCache struct {
+	ID     string `bencode:"id"`
+	Nodes  string `bencode:"nodes,omitempty"`
+	Nodes6 string `bencode:"nodes6,omitempty"`
+}
+
+// SaveNodes writes the id and the nodes of the routing table to a file, so
+// that the next run keeps its id and joins the network from known nodes
+func (s *Server) SaveNodes(path string) error {
+	nodes := s.table.nodes()
+	data, err := bencode.Marshal(nodeCache{
+		ID:     string(s.id[:]),
+		Nodes:  encodeNodes(nodes, false),
+		Nodes6: encodeNodes(nodes, true),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// the cache is replaced at once so that it is never read half written
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadNodes reads a node cache written by SaveNodes, it returns the id to
+// reuse and the nodes to bootstrap from
+func LoadNodes(path string) (ID, []Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	var cache nodeCache
+	if err = bencode.Unmarshal(data, &cache); err != nil {
+		return ID{}, nil, err
+	}
+
+	id, err := idFromString(cache.ID)
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	nodes, err := decodeNodes(cache.Nodes, false)
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	nodes6, err := decodeNodes(cache.Nodes6, true)
+	if err != nil {
+		return ID{}, nil, err
+	}
+
+	return id, append(nodes, nodes6...), nil
+}
@@ -0,0 +1,294 @@
+// Package dht implements a node of the mainline DHT, the distributed hash
+// table described in BEP 5 which lets BitTorrent clients find the peers of a
+// torrent without a tracker. A node answers the ping, find_node, get_peers and
+// announce_peer queries of other nodes and looks up peers on their behalf.
+// A node uses a single address family, IPv6 nodes exchange the compact node
+// infos of BEP 32
+package dht
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+const (
+	// DefaultQueryTimeout is the time a node is given to respond to a query
+	DefaultQueryTimeout = 5 * time.Second
+	// bucketRefreshInterval is the time after which a bucket which did not change is refreshed
+	bucketRefreshInterval = 15 * time.Minute
+	// maintenanceInterval is the time between checks for stale buckets and expired peers
+	maintenanceInterval = time.Minute
+)
+
+// DefaultBootstrapNodes are well known nodes to join the network through
+var DefaultBootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// Server is a DHT node
+type Server struct {
+	conn   net.PacketConn
+	id     ID
+	ipv6   bool
+	logger *slog.Logger
+
+	// QueryTimeout bounds the wait for the response of a query
+	QueryTimeout time.Duration
+
+	table  *table
+	tokens *tokens
+	store  *peerStore
+
+	mu           sync.Mutex
+	transactions map[string]*transaction
+	nextTx       uint16
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New creates a node serving on a udp connection of a single address family,
+// a random id is used when id is zero
+func New(logger *slog.Logger, conn net.PacketConn, id ID) *Server {
+	if id.IsZero() {
+		id = RandomID()
+	}
+
+	var ipv6 bool
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		ipv6 = addr.IP.To4() == nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		conn:         conn,
+		id:           id,
+		ipv6:         ipv6,
+		logger:       logger.With(slog.String("dht_addr", conn.LocalAddr().String())),
+		QueryTimeout: DefaultQueryTimeout,
+		table:        newTable(id),
+		tokens:       newTokens(),
+		store:        newPeerStore(),
+		transactions: make(map[string]*transaction),
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	go s.serve()
+	go s.maintain()
+
+	return s
+}
+
+// Listen creates a node listening on an address of the network, udp4 or udp6
+func Listen(logger *slog.Logger, network, addr string, id ID) (*Server, error) {
+	if network != "udp4" && network != "udp6" {
+		return nil, fmt.Errorf("unsupported dht network %q", network)
+	}
+
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(logger, conn, id), nil
+}
+
+// ID returns the id of the node
+func (s *Server) ID() ID {
+	return s.id
+}
+
+// Addr returns the address the node listens on
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Nodes returns the nodes of the routing table which are not bad
+func (s *Server) Nodes() []Node {
+	return s.table.nodes()
+}
+
+// Close stops the node, the pending queries fail with ErrClosed
+func (s *Server) Close() error {
+	s.cancel()
+	return s.conn.Close()
+}
+
+// network returns the network of the node for resolving addresses
+func (s *Server) network() string {
+	if s.ipv6 {
+		return "udp6"
+	}
+
+	return "udp4"
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			s.logger.Error("failed to read dht message", "error", err)
+			continue
+		}
+
+		udpAddr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		addr := udpAddr.AddrPort()
+		addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+		if addr.Addr().Is4() == s.ipv6 {
+			continue
+		}
+
+		var m msg
+		if err := bencode.Unmarshal(buf[:n], &m); err != nil {
+			s.logger.Debug("invalid dht message", slog.String("from", addr.String()), "error", err)
+			continue
+		}
+
+		switch m.Y {
+		case "q":
+			s.handleQuery(addr, &m)
+		case "r", "e":
+			s.handleResponse(addr, &m)
+		}
+	}
+}
+
+// handleQuery answers a query of another node
+func (s *Server) handleQuery(from netip.AddrPort, m *msg) {
+	if m.A == nil {
+		s.sendError(from, m.T, ErrorProtocol, "missing arguments")
+		return
+	}
+
+	id, err := idFromString(m.A.ID)
+	if err != nil {
+		s.sendError(from, m.T, ErrorProtocol, "invalid id")
+		return
+	}
+
+	resp := &response{ID: string(s.id[:])}
+	switch m.Q {
+	case "ping":
+	case "find_node":
+		target, err := idFromString(m.A.Target)
+		if err != nil {
+			s.sendError(from, m.T, ErrorProtocol, "invalid target")
+			return
+		}
+
+		s.setNodes(resp, target)
+	case "get_peers":
+		infoHash, err := idFromString(m.A.InfoHash)
+		if err != nil {
+			s.sendError(from, m.T, ErrorProtocol, "invalid info_hash")
+			return
+		}
+
+		resp.Token = s.tokens.token(from.Addr())
+		for _, addr := range s.store.get(infoHash, maxValues) {
+			resp.Values = append(resp.Values, encodePeer(addr))
+		}
+		s.setNodes(resp, infoHash)
+	case "announce_peer":
+		infoHash, err := idFromString(m.A.InfoHash)
+		if err != nil {
+			s.sendError(from, m.T, ErrorProtocol, "invalid info_hash")
+			return
+		}
+
+		if !s.tokens.valid(m.A.Token, from.Addr()) {
+			s.sendError(from, m.T, ErrorProtocol, "bad token")
+			return
+		}
+
+		port := m.A.Port
+		if m.A.ImpliedPort {
+			port = int(from.Port())
+		}
+
+		if port <= 0 || port > 65535 {
+			s.sendError(from, m.T, ErrorProtocol, "invalid port")
+			return
+		}
+
+		if !s.store.add(infoHash, netip.AddrPortFrom(from.Addr(), uint16(port))) {
+			s.logger.Debug("dht peer store full, dropping announce", slog.String("from", from.String()))
+		}
+	default:
+		s.sendError(from, m.T, ErrorMethodUnknown, "method unknown")
+		return
+	}
+
+	if err := s.send(from, &msg{T: m.T, Y: "r", R: resp}); err != nil {
+		s.logger.Debug("failed to respond to dht query", slog.String("to", from.String()), "error", err)
+	}
+
+	s.addNode(Node{ID: id, Addr: from})
+}
+
+// setNodes sets the nodes closest to target in a response
+func (s *Server) setNodes(resp *response, target ID) {
+	compact := encodeNodes(s.table.closest(target, K), s.ipv6)
+	if s.ipv6 {
+		resp.Nodes6 = compact
+	} else {
+		resp.Nodes = compact
+	}
+}
+
+// addNode records a node which sent a message, pinging the questionable node
+// of a full bucket it could replace
+func (s *Server) addNode(n Node) {
+	if ping := s.table.seen(n); ping != nil {
+		go s.Ping(s.ctx, ping.Addr)
+	}
+}
+
+// Ping checks that a node at an address is alive, it joins the routing table
+// when it responds
+func (s *Server) Ping(ctx context.Context, addr netip.AddrPort) error {
+	_, err := s.query(ctx, addr, "ping", queryArgs{})
+	return err
+}
+
+// maintain refreshes the buckets which did not change for a while by looking
+// up a random id of their range, and drops the expired peers
+func (s *Server) maintain() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.store.expire()
+
+		for _, i := range s.table.staleBuckets(bucketRefreshInterval) {
+			if _, err := s.lookup(s.ctx, s.table.randomID(i), "find_node"); err != nil {
+				s.logger.Debug("failed to refresh bucket", slog.Int("bucket", i), "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package dht_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/dht"
+)
+
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newNetwork starts n nodes on the loopback interface, each joining the
+// network through the first one
+func newNetwork(t *testing.T, n int) []*dht.Server {
+	t.Helper()
+
+	nodes := make([]*dht.Server, n)
+	for i := range nodes {
+		s, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		nodes[i] = s
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, s := range nodes[1:] {
+		if err := s.Bootstrap(ctx, []string{nodes[0].Addr().String()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return nodes
+}
+
+func TestAnnounceAndGetPeers(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNetwork(t, 20)
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	got, err := nodes[5].Announce(ctx, infoHash, 4000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 0 {
+		t.Errorf("want no peers before the first announce, got %v", got)
+	}
+
+	if _, err = nodes[12].Announce(ctx, infoHash, 5000); err != nil {
+		t.Fatal(err)
+	}
+
+	// the peers are found from a node which took part in neither announce
+	got, err = nodes[19].GetPeers(ctx, infoHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{"127.0.0.1:4000": true, "127.0.0.1:5000": true}
+	addrs := make(map[string]bool)
+	for _, p := range got {
+		addrs[p.Addr()] = true
+	}
+
+	if !cmp.Equal(want, addrs) {
+		t.Error(cmp.Diff(want, addrs))
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNetwork(t, 10)
+
+	// nodes learn about each other through the lookups of their own ids
+	for i, s := range nodes {
+		if len(s.Nodes()) < 5 {
+			t.Errorf("node %d knows %d nodes, want at least 5", i, len(s.Nodes()))
+		}
+	}
+
+	lonely, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lonely.Close()
+	lonely.QueryTimeout = 100 * time.Millisecond
+
+	err = lonely.Bootstrap(context.Background(), []string{"127.0.0.1:1", "not a node"})
+	if !errors.Is(err, dht.ErrNoNodes) {
+		t.Errorf("want %v, got %v", dht.ErrNoNodes, err)
+	}
+}
+
+func TestQueries(t *testing.T) {
+	t.Parallel()
+
+	s, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	id := s.ID()
+	cases := []struct {
+		name   string
+		input  string
+		output map[string]interface{}
+	}{
+		{
+			name:  "ping",
+			input: "d1:ad2:id20:bbbbbbbbbbbbbbbbbbbbe1:q4:ping1:t2:aa1:y1:qe",
+			output: map[string]interface{}{
+				"t": "aa",
+				"y": "r",
+				"r": map[string]interface{}{"id": string(id[:])},
+			},
+		},
+		{
+			name:  "error on announce with a bad token",
+			input: "d1:ad2:id20:bbbbbbbbbbbbbbbbbbbb9:info_hash20:aaaaaaaaaaaaaaaaaaaa4:porti6881e5:token3:fooe1:q13:announce_peer1:t2:ab1:y1:qe",
+			output: map[string]interface{}{
+				"t": "ab",
+				"y": "e",
+				"e": []interface{}{int64(dht.ErrorProtocol), "bad token"},
+			},
+		},
+		{
+			name:  "error on find_node without target",
+			input: "d1:ad2:id20:bbbbbbbbbbbbbbbbbbbbe1:q9:find_node1:t2:ac1:y1:qe",
+			output: map[string]interface{}{
+				"t": "ac",
+				"y": "e",
+				"e": []interface{}{int64(dht.ErrorProtocol), "invalid target"},
+			},
+		},
+		{
+			name:  "error on unknown method",
+			input: "d1:ad2:id20:bbbbbbbbbbbbbbbbbbbbe1:q3:foo1:t2:ad1:y1:qe",
+			output: map[string]interface{}{
+				"t": "ad",
+				"y": "e",
+				"e": []interface{}{int64(dht.ErrorMethodUnknown), "method unknown"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("udp4", s.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			if _, err = conn.Write([]byte(tc.input)); err != nil {
+				t.Fatal(err)
+			}
+
+			buf := make([]byte, 1500)
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]interface{}
+			if err = bencode.Unmarshal(buf[:n], &got); err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestGetPeers_TokenAndValues(t *testing.T) {
+	t.Parallel()
+
+	s, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp4", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	roundTrip := func(query string) map[string]interface{} {
+		t.Helper()
+
+		if _, err := conn.Write([]byte(query)); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp struct {
+			R map[string]interface{} `bencode:"r"`
+		}
+		if err = bencode.Unmarshal(buf[:n], &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.R
+	}
+
+	r := roundTrip("d1:ad2:id20:bbbbbbbbbbbbbbbbbbbb9:info_hash20:aaaaaaaaaaaaaaaaaaaae1:q9:get_peers1:t2:aa1:y1:qe")
+	token, ok := r["token"].(string)
+	if !ok {
+		t.Fatalf("missing token in %v", r)
+	}
+
+	// the token allows announcing with the port of the query
+	announce, err := bencode.Marshal(map[string]interface{}{
+		"t": "ab",
+		"y": "q",
+		"q": "announce_peer",
+		"a": map[string]interface{}{
+			"id":           "bbbbbbbbbbbbbbbbbbbb",
+			"info_hash":    "aaaaaaaaaaaaaaaaaaaa",
+			"port":         1,
+			"implied_port": 1,
+			"token":        token,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	roundTrip(string(announce))
+
+	r = roundTrip("d1:ad2:id20:bbbbbbbbbbbbbbbbbbbb9:info_hash20:aaaaaaaaaaaaaaaaaaaae1:q9:get_peers1:t2:ac1:y1:qe")
+
+	local := netip.MustParseAddrPort(conn.LocalAddr().String())
+	want := []interface{}{string(append(local.Addr().AsSlice(), byte(local.Port()>>8), byte(local.Port())))}
+	if !cmp.Equal(want, r["values"]) {
+		t.Error(cmp.Diff(want, r["values"]))
+	}
+
+	// the querying node joined the routing table
+	wantNode := dht.Node{ID: dht.ID([]byte("bbbbbbbbbbbbbbbbbbbb")), Addr: local}
+	if got := s.Nodes(); len(got) != 1 || got[0] != wantNode {
+		t.Errorf("want nodes [%v], got %v", wantNode, got)
+	}
+}
+
+func TestAnnounce_MaxInfoHashes(t *testing.T) {
+	t.Parallel()
+
+	// the number of info hashes a node stores peers for
+	const maxInfoHashes = 1000
+
+	s, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp4", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	roundTrip := func(query map[string]interface{}) map[string]interface{} {
+		t.Helper()
+
+		b, err := bencode.Marshal(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := conn.Write(b); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 1500)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var resp struct {
+			R map[string]interface{} `bencode:"r"`
+		}
+		if err = bencode.Unmarshal(buf[:n], &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.R
+	}
+	getPeers := func(infoHash string) map[string]interface{} {
+		return roundTrip(map[string]interface{}{
+			"t": "aa",
+			"y": "q",
+			"q": "get_peers",
+			"a": map[string]interface{}{"id": "bbbbbbbbbbbbbbbbbbbb", "info_hash": infoHash},
+		})
+	}
+	infoHash := func(i int) string {
+		return fmt.Sprintf("%020d", i)
+	}
+
+	// the token is given to the address of the querying node
+	token, ok := getPeers(infoHash(0))["token"].(string)
+	if !ok {
+		t.Fatal("missing token")
+	}
+
+	for i := range maxInfoHashes + 1 {
+		roundTrip(map[string]interface{}{
+			"t": "ab",
+			"y": "q",
+			"q": "announce_peer",
+			"a": map[string]interface{}{
+				"id":           "bbbbbbbbbbbbbbbbbbbb",
+				"info_hash":    infoHash(i),
+				"port":         1,
+				"implied_port": 1,
+				"token":        token,
+			},
+		})
+	}
+
+	// the announce of the info hash past the cap is dropped
+	for i, want := range map[int]bool{0: true, maxInfoHashes - 1: true, maxInfoHashes: false} {
+		if _, got := getPeers(infoHash(i))["values"]; got != want {
+			t.Errorf("info hash %d: want stored %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestPing_Timeout(t *testing.T) {
+	t.Parallel()
+
+	s, err := dht.Listen(logger, "udp4", "127.0.0.1:0", dht.ID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.QueryTimeout = 100 * time.Millisecond
+
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	err = s.Ping(context.Background(), netip.MustParseAddrPort(silent.LocalAddr().String()))
+	if !errors.Is(err, dht.ErrTimeout) {
+		t.Errorf("want %v, got %v", dht.ErrTimeout, err)
+	}
+}
+
+func TestSaveNodes(t *testing.T) {
+	t.Parallel()
+
+	nodes := newNetwork(t, 5)
+	path := filepath.Join(t.TempDir(), "dht", "nodes.dat")
+
+	if err := nodes[1].SaveNodes(path); err != nil {
+		t.Fatal(err)
+	}
+
+	id, got, err := dht.LoadNodes(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != nodes[1].ID() {
+		t.Errorf("want id %s, got %s", nodes[1].ID(), id)
+	}
+
+	if len(got) == 0 || len(got) != len(nodes[1].Nodes()) {
+		t.Errorf("want %d nodes, got %d", len(nodes[1].Nodes()), len(got))
+	}
+
+	// a node restarted from the cache joins the network again through the cached nodes
+	var addrs []string
+	for _, n := range got {
+		addrs = append(addrs, n.Addr.String())
+	}
+
+	restarted, err := dht.Listen(logger, "udp4", "127.0.0.1:0", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+
+	if err = restarted.Bootstrap(context.Background(), addrs); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,203 @@
+package dht
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+var (
+	ErrTimeout = errors.New("dht query timed out")
+	ErrClosed  = errors.New("dht server closed")
+)
+
+// Error codes of KRPC error messages
+const (
+	ErrorGeneric       = 201
+	ErrorServer        = 202
+	ErrorProtocol      = 203
+	ErrorMethodUnknown = 204
+)
+
+// Error is a KRPC error message sent by a node in response to a query
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("dht error %d: %s", e.Code, e.Message)
+}
+
+// MarshalBencode encodes the error as a list of its code and message
+func (e Error) MarshalBencode() ([]byte, error) {
+	return bencode.Marshal([]interface{}{e.Code, e.Message})
+}
+
+func (e *Error) UnmarshalBencode(data []byte) error {
+	var l []interface{}
+	if err := bencode.Unmarshal(data, &l); err != nil {
+		return err
+	}
+
+	if len(l) < 2 {
+		return fmt.Errorf("error must be a list of a code and a message")
+	}
+
+	code, _ := l[0].(int64)
+	msg, _ := l[1].(string)
+	e.Code, e.Message = int(code), msg
+	return nil
+}
+
+// msg is a KRPC message: a query, a response or an error
+type msg struct {
+	T string     `bencode:"t"`
+	Y string     `bencode:"y"`
+	Q string     `bencode:"q,omitempty"`
+	A *queryArgs `bencode:"a,omitempty"`
+	R *response  `bencode:"r,omitempty"`
+	E *Error     `bencode:"e,omitempty"`
+}
+
+// queryArgs are the arguments of every query method
+type queryArgs struct {
+	ID          string `bencode:"id"`
+	Target      string `bencode:"target,omitempty"`
+	InfoHash    string `bencode:"info_hash,omitempty"`
+	Token       string `bencode:"token,omitempty"`
+	Port        int    `bencode:"port,omitempty"`
+	ImpliedPort bool   `bencode:"implied_port,omitempty"`
+}
+
+// response holds the return values of every query method
+type response struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`
+	Nodes6 string   `bencode:"nodes6,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+}
+
+// nodes returns the nodes of the response in the address family of the server
+func (r *response) nodes(ipv6 bool) []Node {
+	compact := r.Nodes
+	if ipv6 {
+		compact = r.Nodes6
+	}
+
+	nodes, err := decodeNodes(compact, ipv6)
+	if err != nil {
+		return nil
+	}
+
+	return nodes
+}
+
+// transaction is a query waiting for its response
+type transaction struct {
+	addr     netip.AddrPort
+	response chan *msg
+}
+
+// newTransaction registers a query to an address under a new transaction id
+func (s *Server) newTransaction(addr netip.AddrPort) (string, *transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &transaction{addr: addr, response: make(chan *msg, 1)}
+	for {
+		s.nextTx++
+		t := string(binary.BigEndian.AppendUint16(nil, s.nextTx))
+		if _, ok := s.transactions[t]; !ok {
+			s.transactions[t] = tx
+			return t, tx
+		}
+	}
+}
+
+func (s *Server) endTransaction(t string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.transactions, t)
+}
+
+// handleResponse hands a response or an error to the query it answers
+func (s *Server) handleResponse(from netip.AddrPort, m *msg) {
+	s.mu.Lock()
+	tx, ok := s.transactions[m.T]
+	s.mu.Unlock()
+
+	if !ok || tx.addr != from {
+		return
+	}
+
+	select {
+	case tx.response <- m:
+	default:
+	}
+}
+
+// query sends a query to a node and waits for its response, the routing
+// table learns from the outcome
+func (s *Server) query(parent context.Context, addr netip.AddrPort, method string, args queryArgs) (*response, error) {
+	args.ID = string(s.id[:])
+
+	t, tx := s.newTransaction(addr)
+	defer s.endTransaction(t)
+
+	if err := s.send(addr, &msg{T: t, Y: "q", Q: method, A: &args}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, s.QueryTimeout)
+	defer cancel()
+
+	select {
+	case m := <-tx.response:
+		if m.E != nil {
+			return nil, m.E
+		}
+
+		if m.R == nil {
+			return nil, fmt.Errorf("invalid response from %s", addr)
+		}
+
+		id, err := idFromString(m.R.ID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %w", addr, err)
+		}
+
+		s.addNode(Node{ID: id, Addr: addr})
+		return m.R, nil
+	case <-s.ctx.Done():
+		return nil, ErrClosed
+	case <-ctx.Done():
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+
+		s.table.failed(addr)
+		return nil, ErrTimeout
+	}
+}
+
+func (s *Server) send(addr netip.AddrPort, m *msg) error {
+	data, err := bencode.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.conn.WriteTo(data, net.UDPAddrFromAddrPort(addr))
+	return err
+}
+
+func (s *Server) sendError(addr netip.AddrPort, t string, code int, message string) {
+	s.send(addr, &msg{T: t, Y: "e", E: &Error{Code: code, Message: message}})
+}
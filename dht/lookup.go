@@ -0,0 +1,222 @@
+package dht
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+// alpha is the number of concurrent queries of a lookup
+const alpha = 3
+
+var ErrNoNodes = errors.New("no reachable dht nodes")
+
+// tokenNode is a node which responded to a lookup with the token it gave
+type tokenNode struct {
+	Node
+	token string
+}
+
+// lookupResult is the outcome of an iterative lookup
+type lookupResult struct {
+	// closest are the nodes closest to the target which responded, at most K
+	closest []tokenNode
+	peers   []peers.Peer
+}
+
+// lookup queries the nodes closer and closer to a target with find_node or
+// get_peers, until the K closest nodes known have all been queried
+func (s *Server) lookup(ctx context.Context, target ID, method string) (*lookupResult, error) {
+	candidates := s.table.closest(target, K)
+	if len(candidates) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	args := queryArgs{Target: string(target[:])}
+	if method == "get_peers" {
+		args = queryArgs{InfoHash: string(target[:])}
+	}
+
+	type reply struct {
+		node Node
+		resp *response
+		err  error
+	}
+	replies := make(chan reply)
+
+	var (
+		known    = make(map[netip.AddrPort]bool)
+		queried  = make(map[netip.AddrPort]bool)
+		found    = make(map[string]bool)
+		result   = &lookupResult{}
+		inflight int
+	)
+	for _, n := range candidates {
+		known[n.Addr] = true
+	}
+
+	for {
+		sortByDistance(candidates, target)
+		for _, n := range candidates[:min(K, len(candidates))] {
+			if inflight == alpha {
+				break
+			}
+
+			if queried[n.Addr] {
+				continue
+			}
+
+			queried[n.Addr] = true
+			inflight++
+			go func() {
+				resp, err := s.query(ctx, n.Addr, method, args)
+				replies <- reply{n, resp, err}
+			}()
+		}
+
+		if inflight == 0 {
+			break
+		}
+
+		r := <-replies
+		inflight--
+		if r.err != nil {
+			candidates = slices.DeleteFunc(candidates, func(n Node) bool {
+				return n.Addr == r.node.Addr
+			})
+			continue
+		}
+
+		id, _ := idFromString(r.resp.ID)
+		result.closest = append(result.closest, tokenNode{Node{ID: id, Addr: r.node.Addr}, r.resp.Token})
+
+		for _, n := range r.resp.nodes(s.ipv6) {
+			if known[n.Addr] || n.ID == s.id || n.Addr.Port() == 0 {
+				continue
+			}
+
+			known[n.Addr] = true
+			candidates = append(candidates, n)
+		}
+
+		for _, p := range decodePeers(r.resp.Values) {
+			if !found[p.Addr()] {
+				found[p.Addr()] = true
+				result.peers = append(result.peers, p)
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.closest) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	slices.SortFunc(result.closest, func(a, b tokenNode) int {
+		da, db := distance(a.ID, target), distance(b.ID, target)
+		return slices.Compare(da[:], db[:])
+	})
+	result.closest = result.closest[:min(K, len(result.closest))]
+
+	return result, nil
+}
+
+// Bootstrap joins the network through nodes given as host:port addresses,
+// such as DefaultBootstrapNodes, the nodes of a trackerless torrent or the
+// nodes saved by a previous run. The own id is then looked up to fill the
+// routing table and make the node known to its neighbours
+func (s *Server) Bootstrap(ctx context.Context, addrs []string) error {
+	var wg sync.WaitGroup
+	for _, a := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			udpAddr, err := net.ResolveUDPAddr(s.network(), a)
+			if err != nil {
+				s.logger.Debug("failed to resolve bootstrap node", slog.String("node", a), "error", err)
+				return
+			}
+
+			addr := udpAddr.AddrPort()
+			s.Ping(ctx, netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()))
+		}()
+	}
+	wg.Wait()
+
+	if _, err := s.lookup(ctx, s.id, "find_node"); err != nil {
+		return err
+	}
+
+	s.logger.Info("joined dht", slog.Int("nodes", len(s.table.nodes())))
+	return nil
+}
+
+// GetPeers looks up the peers of an info hash
+func (s *Server) GetPeers(ctx context.Context, infoHash []byte) ([]peers.Peer, error) {
+	target, err := idFromString(string(infoHash))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.lookup(ctx, target, "get_peers")
+	if err != nil {
+		return nil, err
+	}
+
+	return result.peers, nil
+}
+
+// Announce looks up the peers of an info hash like GetPeers, then tells the
+// nodes closest to it that we download the torrent on a tcp port
+func (s *Server) Announce(ctx context.Context, infoHash []byte, port uint16) ([]peers.Peer, error) {
+	target, err := idFromString(string(infoHash))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.lookup(ctx, target, "get_peers")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg        sync.WaitGroup
+		announced atomic.Int32
+	)
+	for _, n := range result.closest {
+		if len(n.token) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.query(ctx, n.Addr, "announce_peer", queryArgs{
+				InfoHash: string(target[:]),
+				Token:    n.token,
+				Port:     int(port),
+			})
+			if err == nil {
+				announced.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.logger.Info("announced to dht", slog.String("info_hash", target.String()),
+		slog.Int("nodes", int(announced.Load())), slog.Int("peers", len(result.peers)))
+
+	return result.peers, nil
+}
@@ -0,0 +1,156 @@
+package dht
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"slices"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+// ID is a node id or an info hash, both live in the same 160 bit space
+type ID [20]byte
+
+// RandomID returns a random node id
+func RandomID() ID {
+	var id ID
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+
+	return id
+}
+
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero reports whether the id is unset
+func (id ID) IsZero() bool {
+	return id == ID{}
+}
+
+// idFromString converts a binary string received in a message into an id
+func idFromString(s string) (ID, error) {
+	var id ID
+	if len(s) != len(id) {
+		return id, fmt.Errorf("invalid id length %d", len(s))
+	}
+
+	copy(id[:], s)
+	return id, nil
+}
+
+// distance is the XOR metric between two ids
+func distance(a, b ID) ID {
+	var d ID
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+
+	return d
+}
+
+// commonPrefixLen returns the number of leading bits shared by two ids
+func commonPrefixLen(a, b ID) int {
+	for i := range a {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+
+	return len(a) * 8
+}
+
+// sortByDistance sorts nodes from the closest to the farthest from target
+func sortByDistance(nodes []Node, target ID) {
+	slices.SortFunc(nodes, func(a, b Node) int {
+		da, db := distance(a.ID, target), distance(b.ID, target)
+		return slices.Compare(da[:], db[:])
+	})
+}
+
+// Node is a DHT node known by its id and udp address
+type Node struct {
+	ID   ID
+	Addr netip.AddrPort
+}
+
+const (
+	// compactNodeLen is the length of the compact info of an IPv4 node
+	compactNodeLen = 26
+	// compactNode6Len is the length of the compact info of an IPv6 node, as described in BEP 32
+	compactNode6Len = 38
+)
+
+// encodeNodes encodes nodes in the compact node info format of the address
+// family, the nodes of the other family are left out
+func encodeNodes(nodes []Node, ipv6 bool) string {
+	var b []byte
+	for _, n := range nodes {
+		addr := n.Addr.Addr()
+		if addr.Is4() == ipv6 {
+			continue
+		}
+
+		b = append(b, n.ID[:]...)
+		b = append(b, addr.AsSlice()...)
+		b = binary.BigEndian.AppendUint16(b, n.Addr.Port())
+	}
+
+	return string(b)
+}
+
+// decodeNodes decodes compact node infos of the address family
+func decodeNodes(s string, ipv6 bool) ([]Node, error) {
+	size := compactNodeLen
+	if ipv6 {
+		size = compactNode6Len
+	}
+
+	if len(s)%size != 0 {
+		return nil, fmt.Errorf("compact nodes length %d is not a multiple of %d", len(s), size)
+	}
+
+	nodes := make([]Node, 0, len(s)/size)
+	for i := 0; i < len(s); i += size {
+		entry := []byte(s[i : i+size])
+
+		var n Node
+		copy(n.ID[:], entry)
+		addr, _ := netip.AddrFromSlice(entry[len(n.ID) : size-2])
+		n.Addr = netip.AddrPortFrom(addr, binary.BigEndian.Uint16(entry[size-2:]))
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
+
+// encodePeer encodes a peer address in the compact peer info format
+func encodePeer(addr netip.AddrPort) string {
+	b := addr.Addr().AsSlice()
+	return string(binary.BigEndian.AppendUint16(b, addr.Port()))
+}
+
+// decodePeers decodes the compact peer infos of a get_peers response, the
+// malformed ones are skipped
+func decodePeers(values []string) []peers.Peer {
+	var found []peers.Peer
+	for _, v := range values {
+		if len(v) != 6 && len(v) != 18 {
+			continue
+		}
+
+		addr, _ := netip.AddrFromSlice([]byte(v[:len(v)-2]))
+		found = append(found, peers.Peer{
+			IP:   addr.String(),
+			Port: binary.BigEndian.Uint16([]byte(v[len(v)-2:])),
+		})
+	}
+
+	return found
+}
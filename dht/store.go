@@ -0,0 +1,92 @@
+package dht
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// peerTTL is the time an announced peer is handed out without announcing again
+	peerTTL = 30 * time.Minute
+	// maxValues is the number of peers of a get_peers response, which must fit in a datagram
+	maxValues = 50
+	// maxPeersPerHash bounds the peers stored for an info hash
+	maxPeersPerHash = 2000
+	// maxInfoHashes bounds the info hashes peers are stored for
+	maxInfoHashes = 1000
+)
+
+// peerStore keeps the peers announced to us by info hash
+type peerStore struct {
+	mu    sync.Mutex
+	peers map[ID]map[netip.AddrPort]time.Time
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{peers: make(map[ID]map[netip.AddrPort]time.Time)}
+}
+
+// add stores a peer of an info hash, the peer is dropped when the info hash
+// already has maxPeersPerHash peers or is new and maxInfoHashes are stored
+func (s *peerStore) add(infoHash ID, addr netip.AddrPort) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	swarm, ok := s.peers[infoHash]
+	if !ok {
+		if len(s.peers) >= maxInfoHashes {
+			return false
+		}
+		swarm = make(map[netip.AddrPort]time.Time)
+		s.peers[infoHash] = swarm
+	}
+
+	if _, ok := swarm[addr]; !ok && len(swarm) >= maxPeersPerHash {
+		return false
+	}
+
+	swarm[addr] = time.Now().Add(peerTTL)
+
+	return true
+}
+
+// get returns at most n live peers of an info hash
+func (s *peerStore) get(infoHash ID, n int) []netip.AddrPort {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var addrs []netip.AddrPort
+	for addr, expires := range s.peers[infoHash] {
+		if len(addrs) == n {
+			break
+		}
+
+		if now.Before(expires) {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	return addrs
+}
+
+// expire drops the peers which were not announced again in time
+func (s *peerStore) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for infoHash, swarm := range s.peers {
+		for addr, expires := range swarm {
+			if now.After(expires) {
+				delete(swarm, addr)
+			}
+		}
+
+		if len(swarm) == 0 {
+			delete(s.peers, infoHash)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package dht
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// K is the capacity of a bucket and the number of closest nodes a lookup converges to
+	K = 8
+	// maxFailures is the number of queries in a row a node may fail before it is bad
+	maxFailures = 2
+	// questionableAfter is the time without activity after which a good node becomes questionable
+	questionableAfter = 15 * time.Minute
+)
+
+// entry is a node of the routing table
+type entry struct {
+	Node
+	lastSeen time.Time
+	failures int
+}
+
+func (e *entry) bad() bool {
+	return e.failures >= maxFailures
+}
+
+func (e *entry) questionable(now time.Time) bool {
+	return now.Sub(e.lastSeen) > questionableAfter
+}
+
+type bucket struct {
+	entries []*entry
+	// replacements are the most recently seen nodes which did not fit in the
+	// bucket, they take the place of the nodes going bad
+	replacements []Node
+	lastChanged  time.Time
+}
+
+// table is the routing table of a node. The nodes are kept in 160 buckets
+// indexed by the length of the prefix they share with the own id, so that
+// the table knows more nodes close to the own id than far from it
+type table struct {
+	mu      sync.Mutex
+	id      ID
+	buckets [len(ID{}) * 8]bucket
+}
+
+func newTable(id ID) *table {
+	t := &table{id: id}
+	now := time.Now()
+	for i := range t.buckets {
+		t.buckets[i].lastChanged = now
+	}
+
+	return t
+}
+
+func (t *table) bucketIndex(id ID) int {
+	return min(commonPrefixLen(t.id, id), len(t.buckets)-1)
+}
+
+// seen records a node which sent a message. It returns a questionable node of
+// a full bucket which must be pinged, the new node takes its place if it fails
+func (t *table) seen(n Node) *Node {
+	if n.ID == t.id || !n.Addr.IsValid() {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	b := &t.buckets[t.bucketIndex(n.ID)]
+	for _, e := range b.entries {
+		if e.ID != n.ID {
+			continue
+		}
+
+		// a known id showing up from another address is ignored
+		if e.Addr != n.Addr {
+			return nil
+		}
+
+		e.lastSeen = now
+		e.failures = 0
+		b.lastChanged = now
+		return nil
+	}
+
+	if len(b.entries) < K {
+		b.entries = append(b.entries, &entry{Node: n, lastSeen: now})
+		b.lastChanged = now
+		return nil
+	}
+
+	for _, e := range b.entries {
+		if e.bad() {
+			*e = entry{Node: n, lastSeen: now}
+			b.lastChanged = now
+			return nil
+		}
+	}
+
+	b.addReplacement(n)
+
+	var oldest *entry
+	for _, e := range b.entries {
+		if e.questionable(now) && (oldest == nil || e.lastSeen.Before(oldest.lastSeen)) {
+			oldest = e
+		}
+	}
+
+	if oldest == nil {
+		return nil
+	}
+
+	ping := oldest.Node
+	return &ping
+}
+
+func (b *bucket) addReplacement(n Node) {
+	for i, r := range b.replacements {
+		if r.ID == n.ID {
+			b.replacements = append(b.replacements[:i], b.replacements[i+1:]...)
+			break
+		}
+	}
+
+	if len(b.replacements) == K {
+		b.replacements = b.replacements[1:]
+	}
+	b.replacements = append(b.replacements, n)
+}
+
+// failed records a query to an address which timed out, a node going bad is
+// replaced by the most recent replacement of its bucket
+func (t *table) failed(addr netip.AddrPort) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		for _, e := range b.entries {
+			if e.Addr != addr {
+				continue
+			}
+
+			e.failures++
+			if e.bad() && len(b.replacements) > 0 {
+				last := len(b.replacements) - 1
+				*e = entry{Node: b.replacements[last], lastSeen: time.Now()}
+				b.replacements = b.replacements[:last]
+				b.lastChanged = time.Now()
+			}
+			return
+		}
+	}
+}
+
+// closest returns at most n nodes which are not bad, sorted by distance to target
+func (t *table) closest(target ID, n int) []Node {
+	nodes := t.nodes()
+	sortByDistance(nodes, target)
+
+	return nodes[:min(n, len(nodes))]
+}
+
+// nodes returns the nodes which are not bad
+func (t *table) nodes() []Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var nodes []Node
+	for i := range t.buckets {
+		for _, e := range t.buckets[i].entries {
+			if !e.bad() {
+				nodes = append(nodes, e.Node)
+			}
+		}
+	}
+
+	return nodes
+}
+
+// staleBuckets returns the indexes of the non-empty buckets which did not
+// change for the given duration
+func (t *table) staleBuckets(age time.Duration) []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var stale []int
+	for i := range t.buckets {
+		if len(t.buckets[i].entries) > 0 && time.Since(t.buckets[i].lastChanged) > age {
+			stale = append(stale, i)
+		}
+	}
+
+	return stale
+}
+
+// randomID returns a random id falling in a bucket
+func (t *table) randomID(bucket int) ID {
+	id := RandomID()
+
+	// keep the prefix of the own id, then flip the next bit
+	for i := 0; i < bucket; i++ {
+		setBit(&id, i, bit(t.id, i))
+	}
+	setBit(&id, bucket, !bit(t.id, bucket))
+
+	return id
+}
+
+func bit(id ID, i int) bool {
+	return id[i/8]&(0x80>>(i%8)) != 0
+}
+
+func setBit(id *ID, i int, set bool) {
+	if set {
+		id[i/8] |= 0x80 >> (i % 8)
+	} else {
+		id[i/8] &^= 0x80 >> (i % 8)
+	}
+}
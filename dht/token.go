@@ -0,0 +1,80 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// tokenRotation is the lifetime of a secret, the tokens of the previous
+// secret stay valid so a token is accepted for 5 to 10 minutes
+const tokenRotation = 5 * time.Minute
+
+// tokens hands out the tokens of get_peers responses, which an announce_peer
+// query must return to prove the querying node owns its address
+type tokens struct {
+	mu      sync.Mutex
+	secret  [20]byte
+	prev    [20]byte
+	rotated time.Time
+}
+
+func newTokens() *tokens {
+	t := &tokens{rotated: time.Now()}
+	randomSecret(&t.secret)
+	t.prev = t.secret
+
+	return t
+}
+
+func randomSecret(secret *[20]byte) {
+	if _, err := rand.Read(secret[:]); err != nil {
+		panic(err)
+	}
+}
+
+// rotate replaces the secret once it expired, it must be called with mu held
+func (t *tokens) rotate() {
+	if time.Since(t.rotated) < tokenRotation {
+		return
+	}
+
+	t.prev = t.secret
+	randomSecret(&t.secret)
+	t.rotated = time.Now()
+}
+
+// token returns the token of an ip address
+func (t *tokens) token(addr netip.Addr) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	return tokenOf(t.secret, addr)
+}
+
+// valid reports whether a token was handed out to an ip address
+func (t *tokens) valid(token string, addr netip.Addr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate()
+	for _, secret := range [][20]byte{t.secret, t.prev} {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(tokenOf(secret, addr))) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func tokenOf(secret [20]byte, addr netip.Addr) string {
+	h := sha1.New()
+	h.Write(secret[:])
+	h.Write(addr.Unmap().AsSlice())
+
+	return string(h.Sum(nil)[:8])
+}
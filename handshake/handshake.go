@@ -2,10 +2,68 @@ package handshake
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 )
 
+// Protocol is the protocol string of the BitTorrent handshake
+const Protocol = "BitTorrent protocol"
+
+// DefaultTimeout is a sensible bound for a handshake exchange
+const DefaultTimeout = 10 * time.Second
+
+// ErrSelfConnection is returned when the reply carries our own peer id, as
+// happens when a tracker lists our own address
+var ErrSelfConnection = errors.New("connected to ourselves")
+
+// ErrProtocol is returned when a peer speaks another protocol than BitTorrent
+type ErrProtocol struct {
+	Protocol string
+}
+
+func (e *ErrProtocol) Error() string {
+	return fmt.Sprintf("unsupported protocol %q", e.Protocol)
+}
+
+// ErrInfoHashMismatch is returned when a peer replies with the info hash of
+// another torrent
+type ErrInfoHashMismatch struct {
+	Want []byte
+	Got  []byte
+}
+
+func (e *ErrInfoHashMismatch) Error() string {
+	return fmt.Sprintf("mismatch info hash, want %x, got %x", e.Want, e.Got)
+}
+
+// ErrPeerIDMismatch is returned when a peer replies with another peer id than
+// the one the tracker gave
+type ErrPeerIDMismatch struct {
+	Want []byte
+	Got  []byte
+}
+
+func (e *ErrPeerIDMismatch) Error() string {
+	return fmt.Sprintf("mismatch peer id, want %x, got %x", e.Want, e.Got)
+}
+
+// ExchangeOptions tune the exchange of handshakes with a peer
+type ExchangeOptions struct {
+	// PeerID is the peer id a tracker gave for the peer, the reply must carry
+	// it when set
+	PeerID []byte
+	// Timeout bounds the exchange on streams with deadlines such as a
+	// net.Conn, the deadline is cleared once done. No bound when zero
+	Timeout time.Duration
+}
+
+// deadliner is implemented by the streams supporting deadlines
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
 type Handshake struct {
 	Protocol string
 	Reserved []byte
@@ -15,13 +73,104 @@ type Handshake struct {
 
 func New(infoHash []byte, peerID []byte) *Handshake {
 	return &Handshake{
-		Protocol: "BitTorrent protocol",
+		Protocol: Protocol,
 		Reserved: make([]byte, 8),
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
 }
 
+// ReservedBit is a bit of the reserved bytes advertising support for an
+// extension, numbered from 0 for the most significant bit of the first byte
+// as in BEP 4
+type ReservedBit uint8
+
+// Reserved bits of the known extensions
+const (
+	BitExtensionProtocol ReservedBit = 43 // BEP 10
+	BitV2Upgrade         ReservedBit = 59 // BEP 52
+	BitFast              ReservedBit = 61 // BEP 6
+	BitDHT               ReservedBit = 63 // BEP 5
+)
+
+// knownBits are the known extensions in the order they are reported
+var knownBits = []struct {
+	bit  ReservedBit
+	name string
+}{
+	{BitExtensionProtocol, "extension protocol"},
+	{BitDHT, "dht"},
+	{BitFast, "fast"},
+	{BitV2Upgrade, "v2 upgrade"},
+}
+
+func (b ReservedBit) String() string {
+	for _, known := range knownBits {
+		if known.bit == b {
+			return known.name
+		}
+	}
+
+	return fmt.Sprintf("bit %d", uint8(b))
+}
+
+// position returns the index of the reserved byte holding the bit and its mask
+func (b ReservedBit) position() (int, byte) {
+	return int(b / 8), 0x80 >> (b % 8)
+}
+
+// SetReserved sets reserved bits, the bits outside the 8 reserved bytes are ignored
+func (h *Handshake) SetReserved(bits ...ReservedBit) {
+	for _, b := range bits {
+		i, mask := b.position()
+		if i < len(h.Reserved) {
+			h.Reserved[i] |= mask
+		}
+	}
+}
+
+// HasReserved reports whether a reserved bit is set
+func (h *Handshake) HasReserved(b ReservedBit) bool {
+	i, mask := b.position()
+	return len(h.Reserved) == 8 && i < 8 && h.Reserved[i]&mask != 0
+}
+
+// Negotiate returns the reserved bits set in both our handshake and the reply
+// of the peer, those are the extensions the connection may use
+func (h *Handshake) Negotiate(reply *Handshake) []ReservedBit {
+	var bits []ReservedBit
+	for b := ReservedBit(0); b < 64; b++ {
+		if h.HasReserved(b) && reply.HasReserved(b) {
+			bits = append(bits, b)
+		}
+	}
+
+	return bits
+}
+
+// EnableExtensionProtocol sets the reserved bit advertising support for the
+// extension protocol described in BEP 10
+func (h *Handshake) EnableExtensionProtocol() {
+	h.SetReserved(BitExtensionProtocol)
+}
+
+// SupportsExtensionProtocol reports whether the extension protocol bit is set
+func (h *Handshake) SupportsExtensionProtocol() bool {
+	return h.HasReserved(BitExtensionProtocol)
+}
+
+// Extensions returns the names of the known extensions advertised in the reserved bytes
+func (h *Handshake) Extensions() []string {
+	var names []string
+	for _, known := range knownBits {
+		if h.HasReserved(known.bit) {
+			names = append(names, known.name)
+		}
+	}
+
+	return names
+}
+
 func (h *Handshake) Serialize() []byte {
 	var buf bytes.Buffer
 	buf.WriteByte(byte(len(h.Protocol)))
@@ -33,34 +182,76 @@ func (h *Handshake) Serialize() []byte {
 	return buf.Bytes()
 }
 
-func deserialize(msg []byte) (*Handshake, error) {
-	if len(msg) != 68 {
-		return nil, fmt.Errorf("invalid handshake reply: %s", msg)
+// Read reads a handshake from a stream, the protocol must be BitTorrent
+func Read(r io.Reader) (*Handshake, error) {
+	var pstrlen [1]byte
+	if _, err := io.ReadFull(r, pstrlen[:]); err != nil {
+		return nil, err
 	}
 
-	return New(msg[28:48], msg[48:68]), nil
+	// the protocol is followed by the reserved bytes, the info hash and the peer id
+	buf := make([]byte, int(pstrlen[0])+48)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid handshake: %w", err)
+	}
+
+	protocol := string(buf[:pstrlen[0]])
+	if protocol != Protocol {
+		return nil, &ErrProtocol{Protocol: protocol}
+	}
+	buf = buf[pstrlen[0]:]
+
+	h := New(buf[8:28], buf[28:48])
+	copy(h.Reserved, buf[:8])
+
+	return h, nil
 }
 
 // InitHandshake sends a handshake message to a stream, then reads and
 // returns a handshake reply
 func InitHandshake(rw io.ReadWriter, infoHash []byte, peerID []byte) (*Handshake, error) {
-	msg := New(infoHash, peerID)
+	return Exchange(rw, New(infoHash, peerID))
+}
+
+// Exchange sends the provided handshake message to a stream, then reads and
+// returns a verified handshake reply
+func Exchange(rw io.ReadWriter, msg *Handshake) (*Handshake, error) {
+	return ExchangeWithOptions(rw, msg, ExchangeOptions{})
+}
+
+// ExchangeWithOptions sends the provided handshake message to a stream, then
+// reads and returns a handshake reply verified as set by opts
+func ExchangeWithOptions(rw io.ReadWriter, msg *Handshake, opts ExchangeOptions) (*Handshake, error) {
+	if d, ok := rw.(deadliner); ok && opts.Timeout > 0 {
+		if err := d.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return nil, err
+		}
+		defer d.SetDeadline(time.Time{})
+	}
 
 	_, err := rw.Write(msg.Serialize())
 	if err != nil {
 		return nil, err
 	}
 
-	buf := make([]byte, 68)
-
-	n, err := rw.Read(buf)
+	reply, err := Read(rw)
 	if err != nil {
 		return nil, err
 	}
 
-	reply, err := deserialize(buf[:n])
-	if err != nil {
-		return nil, err
+	if !bytes.Equal(reply.InfoHash, msg.InfoHash) {
+		return nil, &ErrInfoHashMismatch{Want: msg.InfoHash, Got: reply.InfoHash}
+	}
+
+	if bytes.Equal(reply.PeerID, msg.PeerID) {
+		return nil, ErrSelfConnection
+	}
+
+	if len(opts.PeerID) > 0 && !bytes.Equal(reply.PeerID, opts.PeerID) {
+		return nil, &ErrPeerIDMismatch{Want: opts.PeerID, Got: reply.PeerID}
 	}
 
 	return reply, nil
@@ -1,8 +1,12 @@
 package handshake_test
 
 import (
+	"errors"
+	"io"
 	"net"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/kanowfy/btor/handshake"
@@ -153,3 +157,256 @@ func TestInitHandshake(t *testing.T) {
 	}
 
 }
+
+func TestExtensionProtocol(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	h := handshake.New(infoHash, peerID)
+	if h.SupportsExtensionProtocol() {
+		t.Fatal("expected extension protocol to be disabled by default")
+	}
+
+	h.EnableExtensionProtocol()
+	if !h.SupportsExtensionProtocol() {
+		t.Fatal("expected extension protocol to be enabled")
+	}
+
+	want := []byte{0, 0, 0, 0, 0, 0x10, 0, 0}
+	if !cmp.Equal(want, h.Reserved) {
+		t.Error(cmp.Diff(want, h.Reserved))
+	}
+
+	self, peer := net.Pipe()
+	defer self.Close()
+
+	var egr errgroup.Group
+	egr.Go(func() error {
+		defer peer.Close()
+		buf := make([]byte, 68)
+		if _, err := io.ReadFull(peer, buf); err != nil {
+			return err
+		}
+
+		// the reply carries the peer id of the peer
+		copy(buf[48:], "-XX0000-000000000000")
+		_, err := peer.Write(buf)
+		return err
+	})
+
+	// the reserved bytes of the reply are preserved
+	reply, err := handshake.Exchange(self, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reply.SupportsExtensionProtocol() {
+		t.Error("expected reply to support extension protocol")
+	}
+
+	if err := egr.Wait(); err != nil {
+		t.Fatalf("peer error: %v", err)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		reserved []byte
+		output   []string
+	}{
+		{
+			name:     "no extensions",
+			reserved: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+			output:   nil,
+		},
+		{
+			name:     "known extensions",
+			reserved: []byte{0, 0, 0, 0, 0, 0x10, 0, 0x05},
+			output:   []string{"extension protocol", "dht", "fast"},
+		},
+		{
+			name:     "unknown bits are ignored",
+			reserved: []byte{0x80, 0, 0, 0, 0, 0, 0, 0x10},
+			output:   []string{"v2 upgrade"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := &handshake.Handshake{Reserved: tc.reserved}
+			got := h.Extensions()
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		ours   []handshake.ReservedBit
+		theirs []handshake.ReservedBit
+		output []handshake.ReservedBit
+	}{
+		{
+			name:   "bits set on both sides",
+			ours:   []handshake.ReservedBit{handshake.BitExtensionProtocol, handshake.BitDHT},
+			theirs: []handshake.ReservedBit{handshake.BitDHT, handshake.BitExtensionProtocol, handshake.BitFast},
+			output: []handshake.ReservedBit{handshake.BitExtensionProtocol, handshake.BitDHT},
+		},
+		{
+			name:   "unknown bits are negotiated",
+			ours:   []handshake.ReservedBit{0, handshake.BitFast},
+			theirs: []handshake.ReservedBit{0},
+			output: []handshake.ReservedBit{0},
+		},
+		{
+			name:   "no common bits",
+			ours:   []handshake.ReservedBit{handshake.BitExtensionProtocol},
+			theirs: nil,
+			output: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ours := handshake.New(make([]byte, 20), make([]byte, 20))
+			ours.SetReserved(tc.ours...)
+			theirs := handshake.New(make([]byte, 20), make([]byte, 20))
+			theirs.SetReserved(tc.theirs...)
+
+			got := ours.Negotiate(theirs)
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestExchangeWithOptions(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	remoteID := []byte("-XX0000-000000000000")
+
+	reply := func(protocol string, infoHash, peerID []byte) []byte {
+		h := handshake.New(infoHash, peerID)
+		h.Protocol = protocol
+		return h.Serialize()
+	}
+
+	cases := []struct {
+		name  string
+		reply []byte
+		opts  handshake.ExchangeOptions
+		// err checks the error, nil when the exchange must succeed
+		err func(error) bool
+	}{
+		{
+			name:  "reply split across writes",
+			reply: reply(handshake.Protocol, infoHash, remoteID),
+			err:   nil,
+		},
+		{
+			name:  "peer id given by the tracker",
+			reply: reply(handshake.Protocol, infoHash, remoteID),
+			opts:  handshake.ExchangeOptions{PeerID: remoteID},
+			err:   nil,
+		},
+		{
+			name:  "error on other protocol",
+			reply: reply("Other protocol", infoHash, remoteID),
+			err: func(err error) bool {
+				var e *handshake.ErrProtocol
+				return errors.As(err, &e) && e.Protocol == "Other protocol"
+			},
+		},
+		{
+			name:  "error on info hash mismatch",
+			reply: reply(handshake.Protocol, make([]byte, 20), remoteID),
+			err: func(err error) bool {
+				var e *handshake.ErrInfoHashMismatch
+				return errors.As(err, &e)
+			},
+		},
+		{
+			name:  "error on peer id mismatch",
+			reply: reply(handshake.Protocol, infoHash, remoteID),
+			opts:  handshake.ExchangeOptions{PeerID: make([]byte, 20)},
+			err: func(err error) bool {
+				var e *handshake.ErrPeerIDMismatch
+				return errors.As(err, &e)
+			},
+		},
+		{
+			name:  "error on self connection",
+			reply: reply(handshake.Protocol, infoHash, peerID),
+			err: func(err error) bool {
+				return errors.Is(err, handshake.ErrSelfConnection)
+			},
+		},
+		{
+			name:  "error on truncated reply",
+			reply: reply(handshake.Protocol, infoHash, remoteID)[:40],
+			err: func(err error) bool {
+				return errors.Is(err, io.ErrUnexpectedEOF)
+			},
+		},
+		{
+			name:  "error on timeout",
+			reply: nil,
+			opts:  handshake.ExchangeOptions{Timeout: 50 * time.Millisecond},
+			err: func(err error) bool {
+				return errors.Is(err, os.ErrDeadlineExceeded)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			self, peer := net.Pipe()
+			defer self.Close()
+
+			go func() {
+				defer peer.Close()
+				buf := make([]byte, 68)
+				if _, err := io.ReadFull(peer, buf); err != nil {
+					return
+				}
+
+				if tc.reply == nil {
+					// never reply
+					io.Copy(io.Discard, peer)
+					return
+				}
+
+				for _, b := range [][]byte{tc.reply[:10], tc.reply[10:]} {
+					if _, err := peer.Write(b); err != nil {
+						return
+					}
+				}
+			}()
+
+			_, err := handshake.ExchangeWithOptions(self, handshake.New(infoHash, peerID), tc.opts)
+			if tc.err == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if !tc.err(err) {
+				t.Errorf("unexpected error %v", err)
+			}
+		})
+	}
+}
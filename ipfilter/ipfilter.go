@@ -0,0 +1,296 @@
+// Package ipfilter blocks peers by ip address. The blocked ranges are loaded
+// from lists in the eMule ipfilter.dat, PeerGuardian P2P or CIDR formats,
+// which may be mixed in a single list and hold IPv4 and IPv6 ranges
+package ipfilter
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+// maxAllowedLevel is the highest access level of an ipfilter.dat range which
+// is blocked, the ranges of higher levels are allowed
+const maxAllowedLevel = 127
+
+// ipRange is an inclusive range of addresses of a single family
+type ipRange struct {
+	start netip.Addr
+	end   netip.Addr
+}
+
+// Filter holds the blocked ranges, sorted and merged so that an address is
+// looked up with a binary search. A nil Filter blocks nothing
+type Filter struct {
+	ranges  []ipRange
+	blocked atomic.Int64
+}
+
+// Parse reads a list of blocked ranges, one per line in any of the formats:
+//
+//	1.2.3.0 - 1.2.3.255 , 000 , description   (eMule ipfilter.dat)
+//	description:1.2.3.0-1.2.3.255             (PeerGuardian P2P)
+//	1.2.3.0/24                                (CIDR, or a single address)
+//
+// Blank lines and lines starting with # or // are ignored
+func Parse(r io.Reader) (*Filter, error) {
+	var ranges []ipRange
+	if err := parseInto(r, &ranges); err != nil {
+		return nil, err
+	}
+
+	return newFilter(ranges), nil
+}
+
+// LoadFiles reads the lists of blocked ranges of several files into a single filter
+func LoadFiles(paths ...string) (*Filter, error) {
+	var ranges []ipRange
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = parseInto(f, &ranges)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return newFilter(ranges), nil
+}
+
+func parseInto(r io.Reader, ranges *[]ipRange) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		rng, blocked, err := parseLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if blocked {
+			*ranges = append(*ranges, rng)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseLine parses a range in any format, blocked is false for the ipfilter.dat
+// ranges whose access level allows them
+func parseLine(line string) (rng ipRange, blocked bool, err error) {
+	if strings.Contains(line, ",") {
+		if rng, blocked, err := parseDAT(line); err == nil {
+			return rng, blocked, nil
+		}
+	}
+
+	if prefix, err := netip.ParsePrefix(line); err == nil {
+		rng, err = prefixRange(prefix)
+		return rng, true, err
+	}
+
+	if rng, err := parseRange(line); err == nil {
+		return rng, true, nil
+	}
+
+	if addr, err := parseAddr(line); err == nil {
+		return ipRange{start: addr, end: addr}, true, nil
+	}
+
+	// the description of a P2P line may hold any character, its IPv4 addresses
+	// follow the last colon
+	if i := strings.LastIndex(line, ":"); i >= 0 {
+		if rng, err := parseRange(line[i+1:]); err == nil {
+			return rng, true, nil
+		}
+	}
+
+	return ipRange{}, false, fmt.Errorf("unrecognized range %q", line)
+}
+
+// parseDAT parses an eMule ipfilter.dat line
+func parseDAT(line string) (ipRange, bool, error) {
+	fields := strings.SplitN(line, ",", 3)
+
+	rng, err := parseRange(fields[0])
+	if err != nil {
+		return ipRange{}, false, err
+	}
+
+	level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+	if err != nil {
+		return ipRange{}, false, fmt.Errorf("invalid access level %q", strings.TrimSpace(fields[1]))
+	}
+
+	return rng, level <= maxAllowedLevel, nil
+}
+
+// parseRange parses a start-end range of a single family
+func parseRange(s string) (ipRange, error) {
+	startStr, endStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return ipRange{}, fmt.Errorf("invalid range %q", strings.TrimSpace(s))
+	}
+
+	start, err := parseAddr(startStr)
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	end, err := parseAddr(endStr)
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	if start.Is4() != end.Is4() || end.Less(start) {
+		return ipRange{}, fmt.Errorf("invalid range %s-%s", start, end)
+	}
+
+	return ipRange{start: start, end: end}, nil
+}
+
+// parseAddr parses an address, the IPv4 octets may be padded with zeros as
+// they are in ipfilter.dat lists
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, ":") {
+		octets := strings.Split(s, ".")
+		for i, o := range octets {
+			if trimmed := strings.TrimLeft(o, "0"); trimmed != "" {
+				octets[i] = trimmed
+			} else if o != "" {
+				octets[i] = "0"
+			}
+		}
+		s = strings.Join(octets, ".")
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap(), nil
+}
+
+// prefixRange returns the range of the addresses of a prefix
+func prefixRange(prefix netip.Prefix) (ipRange, error) {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return ipRange{}, fmt.Errorf("invalid prefix %s", prefix)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+
+	start := prefix.Addr()
+	end := start.AsSlice()
+	for i, hostBits := len(end)-1, start.BitLen()-prefix.Bits(); hostBits > 0; i, hostBits = i-1, hostBits-8 {
+		end[i] |= byte(1<<min(hostBits, 8) - 1)
+	}
+
+	endAddr, _ := netip.AddrFromSlice(end)
+	return ipRange{start: start, end: endAddr}, nil
+}
+
+// newFilter sorts and merges the overlapping and adjacent ranges
+func newFilter(ranges []ipRange) *Filter {
+	slices.SortFunc(ranges, func(a, b ipRange) int {
+		return cmp.Or(a.start.Compare(b.start), a.end.Compare(b.end))
+	})
+
+	var merged []ipRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			next := last.end.Next()
+			if last.start.Is4() == r.start.Is4() && (!next.IsValid() || !next.Less(r.start)) {
+				if last.end.Less(r.end) {
+					last.end = r.end
+				}
+				continue
+			}
+		}
+		merged = append(merged, r)
+	}
+
+	return &Filter{ranges: merged}
+}
+
+// Len returns the number of blocked ranges, after merging
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+
+	return len(f.ranges)
+}
+
+// Blocks reports whether an address is blocked
+func (f *Filter) Blocks(addr netip.Addr) bool {
+	if f == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+
+	// the first range ending at or after the address
+	i, _ := slices.BinarySearchFunc(f.ranges, addr, func(r ipRange, addr netip.Addr) int {
+		return r.end.Compare(addr)
+	})
+
+	return i < len(f.ranges) && f.ranges[i].start.Compare(addr) <= 0
+}
+
+// Allowed returns the peers which are not blocked and counts the others,
+// the peers given by host name are allowed
+func (f *Filter) Allowed(peerList []peers.Peer) []peers.Peer {
+	if f == nil {
+		return peerList
+	}
+
+	allowed := make([]peers.Peer, 0, len(peerList))
+	for _, p := range peerList {
+		addr, err := netip.ParseAddr(p.IP)
+		if err == nil && f.Reject(addr) {
+			continue
+		}
+		allowed = append(allowed, p)
+	}
+
+	return allowed
+}
+
+// Reject reports whether an address is blocked like Blocks, and counts it if so
+func (f *Filter) Reject(addr netip.Addr) bool {
+	if !f.Blocks(addr) {
+		return false
+	}
+
+	f.blocked.Add(1)
+	return true
+}
+
+// Blocked returns the number of peers blocked so far
+func (f *Filter) Blocked() int64 {
+	if f == nil {
+		return 0
+	}
+
+	return f.blocked.Load()
+}
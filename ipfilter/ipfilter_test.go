@@ -0,0 +1,152 @@
+package ipfilter_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/ipfilter"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		input   string
+		blocked []string
+		allowed []string
+		fails   bool
+	}{
+		{
+			name:    "eMule ipfilter.dat with zero padded addresses",
+			input:   "001.002.003.000 - 001.002.003.255 , 000 , Some ISP\n005.006.007.008 - 005.006.007.008 , 200 , Allowed\n",
+			blocked: []string{"1.2.3.0", "1.2.3.128", "1.2.3.255"},
+			allowed: []string{"1.2.2.255", "1.2.4.0", "5.6.7.8"},
+			fails:   false,
+		},
+		{
+			name:    "PeerGuardian P2P with colons and commas in descriptions",
+			input:   "# comment\nBad: Corp, Inc:10.0.0.0-10.0.0.255\n\nOther/Org:10.0.2.0-10.0.2.10\n",
+			blocked: []string{"10.0.0.7", "10.0.2.10"},
+			allowed: []string{"10.0.1.0", "10.0.2.11"},
+			fails:   false,
+		},
+		{
+			name:    "CIDR and single addresses of both families",
+			input:   "192.168.0.0/16\n2001:db8::/32\n203.0.113.7\n::ffff:198.51.100.0/120\n",
+			blocked: []string{"192.168.10.1", "2001:db8:ffff::1", "203.0.113.7", "::ffff:192.168.0.1", "198.51.100.200"},
+			allowed: []string{"192.169.0.0", "2001:db9::1", "203.0.113.8"},
+			fails:   false,
+		},
+		{
+			name:    "IPv6 ranges in ipfilter.dat",
+			input:   "2001:db8::1 - 2001:db8::ff , 100 , v6\n",
+			blocked: []string{"2001:db8::80"},
+			allowed: []string{"2001:db8::100", "0.0.0.128"},
+			fails:   false,
+		},
+		{
+			name:    "overlapping and adjacent ranges are merged",
+			input:   "1.0.0.0-1.0.0.10\n1.0.0.5-1.0.0.20\n1.0.0.21-1.0.0.30\n255.255.255.0/24\n255.255.255.255\n",
+			blocked: []string{"1.0.0.0", "1.0.0.15", "1.0.0.30", "255.255.255.255"},
+			allowed: []string{"1.0.0.31", "0.255.255.255"},
+			fails:   false,
+		},
+		{
+			name:  "error on mixed families",
+			input: "1.2.3.4 - 2001:db8::1 , 0 , bad\n",
+			fails: true,
+		},
+		{
+			name:  "error on reversed range",
+			input: "1.2.3.4-1.2.3.0\n",
+			fails: true,
+		},
+		{
+			name:  "error on garbage",
+			input: "1.2.3.0/24\nnot a range\n",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := ipfilter.Parse(strings.NewReader(tc.input))
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, addr := range tc.blocked {
+				if !f.Blocks(netip.MustParseAddr(addr)) {
+					t.Errorf("want %s blocked", addr)
+				}
+			}
+
+			for _, addr := range tc.allowed {
+				if f.Blocks(netip.MustParseAddr(addr)) {
+					t.Errorf("want %s allowed", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestFilter_Allowed(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	datPath := filepath.Join(dir, "ipfilter.dat")
+	if err := os.WriteFile(datPath, []byte("001.002.003.000 - 001.002.003.255 , 000 , test\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cidrPath := filepath.Join(dir, "blocklist.txt")
+	if err := os.WriteFile(cidrPath, []byte("2001:db8::/32\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ipfilter.LoadFiles(datPath, cidrPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Len() != 2 {
+		t.Errorf("want 2 ranges, got %d", f.Len())
+	}
+
+	got := f.Allowed([]peers.Peer{
+		{IP: "1.2.3.4", Port: 6881},
+		{IP: "5.6.7.8", Port: 6881},
+		{IP: "2001:db8::1", Port: 6881},
+		{IP: "tracker.example.com", Port: 6881},
+	})
+
+	want := []peers.Peer{
+		{IP: "5.6.7.8", Port: 6881},
+		{IP: "tracker.example.com", Port: 6881},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	if f.Blocked() != 2 {
+		t.Errorf("want 2 blocked peers, got %d", f.Blocked())
+	}
+
+	// a nil filter blocks nothing
+	var none *ipfilter.Filter
+	if got := none.Allowed(want); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
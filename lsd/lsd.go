@@ -0,0 +1,252 @@
+// Package lsd implements the local service discovery described in BEP 14.
+// BitTorrent clients of a local network find each other by multicasting the
+// info hashes of their torrents to a well known group, which keeps the
+// traffic between them on the local network
+package lsd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+const (
+	// Port is the udp port of the multicast groups
+	Port = 6771
+	// AnnounceInterval is the time between two announces of a torrent
+	AnnounceInterval = 5 * time.Minute
+	// maxMessageSize bounds the size of an announce to fit in a datagram
+	maxMessageSize = 1400
+)
+
+var (
+	// IPv4Group is the multicast group of IPv4 local networks
+	IPv4Group = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: Port}
+	// IPv6Group is the multicast group of IPv6 local networks
+	IPv6Group = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: Port}
+)
+
+// Announce is a BT-SEARCH message telling the local network about the torrents of a peer
+type Announce struct {
+	// Port is the port the peer accepts connections on
+	Port       uint16
+	InfoHashes [][]byte
+	// Cookie identifies the announces of a client so it can ignore its own
+	Cookie string
+}
+
+// ParseAnnounce parses a BT-SEARCH message
+func ParseAnnounce(b []byte) (*Announce, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "BT-SEARCH * HTTP/1.1" {
+		return nil, errors.New("not a BT-SEARCH message")
+	}
+
+	var (
+		a    Announce
+		port bool
+	)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "port":
+			p, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || p == 0 {
+				return nil, fmt.Errorf("invalid port %q", value)
+			}
+			a.Port, port = uint16(p), true
+		case "infohash":
+			infoHash, err := hex.DecodeString(value)
+			if err != nil || len(infoHash) != 20 {
+				return nil, fmt.Errorf("invalid info hash %q", value)
+			}
+			a.InfoHashes = append(a.InfoHashes, infoHash)
+		case "cookie":
+			a.Cookie = value
+		}
+	}
+
+	if !port {
+		return nil, errors.New("missing port")
+	}
+
+	if len(a.InfoHashes) == 0 {
+		return nil, errors.New("missing info hash")
+	}
+
+	return &a, nil
+}
+
+// Marshal encodes the announce for the multicast group host
+func (a *Announce) Marshal(host string) []byte {
+	var b bytes.Buffer
+	b.WriteString("BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "Host: %s\r\n", host)
+	fmt.Fprintf(&b, "Port: %d\r\n", a.Port)
+	for _, infoHash := range a.InfoHashes {
+		fmt.Fprintf(&b, "Infohash: %x\r\n", infoHash)
+	}
+	if a.Cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", a.Cookie)
+	}
+	b.WriteString("\r\n\r\n")
+
+	return b.Bytes()
+}
+
+// Service announces torrents to a multicast group and hands the peers
+// announcing the same torrents to their subscribers
+type Service struct {
+	conn   net.PacketConn
+	group  net.Addr
+	port   uint16
+	cookie string
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	handlers map[string]func([]peers.Peer)
+}
+
+// New creates a service announcing to group over conn the torrents we accept
+// connections for on port
+func New(logger *slog.Logger, conn net.PacketConn, group net.Addr, port uint16) *Service {
+	cookie := make([]byte, 8)
+	if _, err := rand.Read(cookie); err != nil {
+		panic(err)
+	}
+
+	s := &Service{
+		conn:     conn,
+		group:    group,
+		port:     port,
+		cookie:   hex.EncodeToString(cookie),
+		logger:   logger.With(slog.String("lsd_group", group.String())),
+		handlers: make(map[string]func([]peers.Peer)),
+	}
+	go s.serve()
+
+	return s
+}
+
+// Listen joins the multicast group of a network, udp4 or udp6, on the
+// default interface. Multicast loopback is disabled, the clients of the same
+// host do not find each other
+func Listen(logger *slog.Logger, network string, port uint16) (*Service, error) {
+	group := IPv4Group
+	if network == "udp6" {
+		group = IPv6Group
+	}
+
+	conn, err := net.ListenMulticastUDP(network, nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(logger, conn, group, port), nil
+}
+
+// Close stops the service
+func (s *Service) Close() error {
+	return s.conn.Close()
+}
+
+// Run announces an info hash every AnnounceInterval until the context is
+// done, the peers announcing it are handed to found
+func (s *Service) Run(ctx context.Context, infoHash []byte, found func([]peers.Peer)) {
+	s.mu.Lock()
+	s.handlers[string(infoHash)] = found
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.handlers, string(infoHash))
+		s.mu.Unlock()
+	}()
+
+	for {
+		if err := s.announce(infoHash); err != nil && ctx.Err() == nil {
+			s.logger.Error("failed to announce to local network", slog.String("info_hash", fmt.Sprintf("%x", infoHash)), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(AnnounceInterval):
+		}
+	}
+}
+
+func (s *Service) announce(infoHash []byte) error {
+	a := Announce{
+		Port:       s.port,
+		InfoHashes: [][]byte{infoHash},
+		Cookie:     s.cookie,
+	}
+
+	_, err := s.conn.WriteTo(a.Marshal(s.group.String()), s.group)
+	return err
+}
+
+// serve reads the announces of the group until the connection is closed
+func (s *Service) serve() {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		a, err := ParseAnnounce(buf[:n])
+		if err != nil {
+			s.logger.Debug("ignoring invalid announce", slog.String("from", addr.String()), "error", err)
+			continue
+		}
+
+		// our own announces come back when multicast loops back to the sender
+		if a.Cookie == s.cookie {
+			continue
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		peer := peers.Peer{IP: udpAddr.IP.String(), Port: a.Port}
+
+		for _, infoHash := range a.InfoHashes {
+			s.mu.Lock()
+			found := s.handlers[string(infoHash)]
+			s.mu.Unlock()
+
+			if found != nil {
+				s.logger.Info("found local peer", slog.String("peer_addr", peer.Addr()))
+				found([]peers.Peer{peer})
+			}
+		}
+	}
+}
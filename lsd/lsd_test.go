@@ -0,0 +1,142 @@
+package lsd_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/lsd"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestParseAnnounce(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		output *lsd.Announce
+		fails  bool
+	}{
+		{
+			name:  "announce with a cookie",
+			input: "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 6881\r\nInfohash: 6161616161616161616161616161616161616161\r\ncookie: abc\r\n\r\n\r\n",
+			output: &lsd.Announce{
+				Port:       6881,
+				InfoHashes: [][]byte{[]byte("aaaaaaaaaaaaaaaaaaaa")},
+				Cookie:     "abc",
+			},
+			fails: false,
+		},
+		{
+			name:  "several info hashes and case insensitive headers",
+			input: "BT-SEARCH * HTTP/1.1\nhost: [ff15::efc0:988f]:6771\nPORT: 51413\ninfohash: 6161616161616161616161616161616161616161\nINFOHASH: 6262626262626262626262626262626262626262\n\n\n",
+			output: &lsd.Announce{
+				Port:       51413,
+				InfoHashes: [][]byte{[]byte("aaaaaaaaaaaaaaaaaaaa"), []byte("bbbbbbbbbbbbbbbbbbbb")},
+			},
+			fails: false,
+		},
+		{
+			name:  "error on other requests",
+			input: "M-SEARCH * HTTP/1.1\r\nHost: 239.255.255.250:1900\r\n\r\n",
+			fails: true,
+		},
+		{
+			name:  "error on missing port",
+			input: "BT-SEARCH * HTTP/1.1\r\nInfohash: 6161616161616161616161616161616161616161\r\n\r\n\r\n",
+			fails: true,
+		},
+		{
+			name:  "error on invalid info hash",
+			input: "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: 6161\r\n\r\n\r\n",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := lsd.ParseAnnounce([]byte(tc.input))
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+
+			// the announce survives a round trip
+			again, err := lsd.ParseAnnounce(got.Marshal(lsd.IPv4Group.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(got, again) {
+				t.Error(cmp.Diff(got, again))
+			}
+		})
+	}
+}
+
+func TestService(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	infoHash := []byte("aaaaaaaaaaaaaaaaaaaa")
+
+	// a unicast address stands in for the multicast group: both services send
+	// to the second one, which also receives its own announces
+	connA, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connB, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := lsd.New(logger, connA, connB.LocalAddr(), 7000)
+	defer a.Close()
+	b := lsd.New(logger, connB, connB.LocalAddr(), 8000)
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	found := make(chan []peers.Peer, 10)
+	go b.Run(ctx, infoHash, func(peerList []peers.Peer) {
+		found <- peerList
+	})
+
+	// give b time to subscribe before a announces
+	time.Sleep(100 * time.Millisecond)
+	go a.Run(ctx, infoHash, func([]peers.Peer) {})
+
+	select {
+	case got := <-found:
+		want := []peers.Peer{{IP: "127.0.0.1", Port: 7000}}
+		if !cmp.Equal(want, got) {
+			t.Error(cmp.Diff(want, got))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the local peer")
+	}
+
+	// b ignores its own announce
+	select {
+	case got := <-found:
+		t.Errorf("want no other peers, got %v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
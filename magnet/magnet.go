@@ -0,0 +1,114 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidMagnet = errors.New("invalid magnet link")
+)
+
+const btihPrefix = "urn:btih:"
+
+// Magnet holds the parameters of a magnet link as described in BEP 9
+type Magnet struct {
+	InfoHash    []byte
+	DisplayName string
+	Trackers    []string
+	// Peers are addresses of peers in the form host:port
+	Peers []string
+}
+
+// Parse parses a magnet URI
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("%w: unexpected scheme %q", ErrInvalidMagnet, u.Scheme)
+	}
+
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+
+	m := &Magnet{
+		DisplayName: q.Get("dn"),
+		Trackers:    q["tr"],
+		Peers:       q["x.pe"],
+	}
+
+	for _, xt := range q["xt"] {
+		if !strings.HasPrefix(xt, btihPrefix) {
+			continue
+		}
+
+		m.InfoHash, err = decodeInfoHash(strings.TrimPrefix(xt, btihPrefix))
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	if m.InfoHash == nil {
+		return nil, fmt.Errorf("%w: missing %s exact topic", ErrInvalidMagnet, btihPrefix)
+	}
+
+	return m, nil
+}
+
+// decodeInfoHash decodes a hex encoded or base32 encoded info hash
+func decodeInfoHash(s string) ([]byte, error) {
+	var (
+		hash []byte
+		err  error
+	)
+
+	switch len(s) {
+	case 40:
+		hash, err = hex.DecodeString(s)
+	case 32:
+		hash, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return nil, fmt.Errorf("%w: info hash must be 40 hex or 32 base32 characters, got %d", ErrInvalidMagnet, len(s))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+
+	return hash, nil
+}
+
+// String encodes the magnet link back into a URI
+func (m *Magnet) String() string {
+	var b strings.Builder
+	b.WriteString("magnet:?xt=")
+	b.WriteString(btihPrefix)
+	b.WriteString(hex.EncodeToString(m.InfoHash))
+
+	if len(m.DisplayName) > 0 {
+		b.WriteString("&dn=")
+		b.WriteString(url.QueryEscape(m.DisplayName))
+	}
+
+	for _, tr := range m.Trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tr))
+	}
+
+	for _, pe := range m.Peers {
+		b.WriteString("&x.pe=")
+		b.WriteString(url.QueryEscape(pe))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package magnet_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/magnet"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+
+	cases := []struct {
+		name   string
+		input  string
+		output *magnet.Magnet
+		fails  bool
+	}{
+		{
+			name:  "correctly parses magnet with all parameters",
+			input: "magnet:?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f&dn=sample.txt&tr=http%3A%2F%2Ftracker.example.com%2Fannounce&tr=udp%3A%2F%2Ftracker.example.com%3A80&x.pe=1.2.3.4:6881&x.pe=%5B::1%5D:6882",
+			output: &magnet.Magnet{
+				InfoHash:    infoHash,
+				DisplayName: "sample.txt",
+				Trackers:    []string{"http://tracker.example.com/announce", "udp://tracker.example.com:80"},
+				Peers:       []string{"1.2.3.4:6881", "[::1]:6882"},
+			},
+			fails: false,
+		},
+		{
+			name:  "correctly parses base32 info hash",
+			input: "magnet:?xt=urn:btih:22PZDZVSVZGFIJDI2EDTU4OU5IJYPGT7",
+			output: &magnet.Magnet{
+				InfoHash: infoHash,
+			},
+			fails: false,
+		},
+		{
+			name:  "correctly parses uppercase hex info hash",
+			input: "magnet:?xt=urn:btih:D69F91E6B2AE4C542468D1073A71D4EA13879A7F&dn=sample.txt",
+			output: &magnet.Magnet{
+				InfoHash:    infoHash,
+				DisplayName: "sample.txt",
+			},
+			fails: false,
+		},
+		{
+			name:   "error on missing exact topic",
+			input:  "magnet:?dn=sample.txt",
+			output: nil,
+			fails:  true,
+		},
+		{
+			name:   "error on wrong scheme",
+			input:  "http://example.com/?xt=urn:btih:d69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+			output: nil,
+			fails:  true,
+		},
+		{
+			name:   "error on invalid info hash length",
+			input:  "magnet:?xt=urn:btih:d69f91",
+			output: nil,
+			fails:  true,
+		},
+		{
+			name:   "error on invalid hex info hash",
+			input:  "magnet:?xt=urn:btih:z69f91e6b2ae4c542468d1073a71d4ea13879a7f",
+			output: nil,
+			fails:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := magnet.Parse(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !cmp.Equal(tc.output, m) {
+					t.Error(cmp.Diff(tc.output, m))
+				}
+			}
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	t.Parallel()
+
+	m := &magnet.Magnet{
+		InfoHash:    []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127},
+		DisplayName: "sample file.txt",
+		Trackers:    []string{"http://tracker.example.com/announce"},
+		Peers:       []string{"1.2.3.4:6881"},
+	}
+
+	got, err := magnet.Parse(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(m, got) {
+		t.Error(cmp.Diff(m, got))
+	}
+}
@@ -0,0 +1,89 @@
+package message
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// ExtendedHandshakeID is the extended message ID reserved for the extension handshake
+const ExtendedHandshakeID byte = 0
+
+// NewExtended creates a new Extended message carrying a payload for the given extended message ID
+func NewExtended(extendedID byte, payload []byte) *Message {
+	buf := make([]byte, 1+len(payload))
+	buf[0] = extendedID
+	copy(buf[1:], payload)
+	return New(MessageExtended, buf)
+}
+
+// ParseExtended parses a message of type Extended and returns the extended message ID and its payload
+func ParseExtended(msg *Message) (byte, []byte, error) {
+	if msg.ID != MessageExtended {
+		return 0, nil, fmt.Errorf("message must be of type %d, got %d", MessageExtended, msg.ID)
+	}
+
+	if len(msg.Payload) < 1 {
+		return 0, nil, fmt.Errorf("expected extended message ID in payload")
+	}
+
+	return msg.Payload[0], msg.Payload[1:], nil
+}
+
+// ExtendedHandshake is the payload of the extension handshake, it tells the
+// extensions a peer supports and the extended message IDs it receives them with
+type ExtendedHandshake struct {
+	// M maps the names of the supported extensions to their extended message
+	// IDs, an ID of 0 disables an extension advertised earlier
+	M map[string]int `bencode:"m"`
+	// V is the name and version of the client
+	V string `bencode:"v,omitempty"`
+	// P is the TCP port the peer listens on
+	P int `bencode:"p,omitempty"`
+	// Reqq is the number of outstanding requests the peer queues
+	Reqq int `bencode:"reqq,omitempty"`
+	// YourIP is the compact address of the receiving peer as the sender sees it
+	YourIP string `bencode:"yourip,omitempty"`
+	// MetadataSize is the size of the info dictionary, as described in BEP 9
+	MetadataSize int `bencode:"metadata_size,omitempty"`
+}
+
+// NewExtendedHandshake creates a new Extended message carrying an extension handshake
+func NewExtendedHandshake(hs *ExtendedHandshake) (*Message, error) {
+	payload, err := bencode.Marshal(hs)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewExtended(ExtendedHandshakeID, payload), nil
+}
+
+// ParseExtendedHandshake parses a message of type Extended carrying an extension handshake
+func ParseExtendedHandshake(msg *Message) (*ExtendedHandshake, error) {
+	id, payload, err := ParseExtended(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != ExtendedHandshakeID {
+		return nil, fmt.Errorf("expected extension handshake, got extended message ID %d", id)
+	}
+
+	if len(payload) == 0 || payload[0] != 'd' {
+		return nil, fmt.Errorf("extension handshake must be a dictionary")
+	}
+
+	hs := new(ExtendedHandshake)
+	if err = bencode.NewDecoder(bytes.NewReader(payload)).Decode(hs); err != nil {
+		return nil, fmt.Errorf("invalid extension handshake: %w", err)
+	}
+
+	for name, id := range hs.M {
+		if id < 0 || id > 255 {
+			return nil, fmt.Errorf("invalid extended message ID %d for %s", id, name)
+		}
+	}
+
+	return hs, nil
+}
@@ -0,0 +1,195 @@
+package message_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/message"
+)
+
+func TestNewExtended(t *testing.T) {
+	t.Parallel()
+
+	got := message.NewExtended(3, []byte("d1:ai1ee")).Serialize()
+	want := []byte{0, 0, 0, 10, 20, 3, 'd', '1', ':', 'a', 'i', '1', 'e', 'e'}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestParseExtended(t *testing.T) {
+	t.Parallel()
+
+	type output struct {
+		id      byte
+		payload []byte
+	}
+
+	cases := []struct {
+		name   string
+		input  *message.Message
+		output output
+		fails  bool
+	}{
+		{
+			name: "correctly parses extended msg",
+			input: &message.Message{
+				ID:      message.MessageExtended,
+				Payload: []byte{1, 'd', 'e'},
+			},
+			output: output{
+				id:      1,
+				payload: []byte{'d', 'e'},
+			},
+			fails: false,
+		},
+		{
+			name: "correctly parses extended msg read from the wire",
+			input: func() *message.Message {
+				msg, _ := message.Read(bytes.NewReader(message.NewExtended(message.ExtendedHandshakeID, []byte("de")).Serialize()))
+				return msg
+			}(),
+			output: output{
+				id:      message.ExtendedHandshakeID,
+				payload: []byte{'d', 'e'},
+			},
+			fails: false,
+		},
+		{
+			name: "error on invalid msg id",
+			input: &message.Message{
+				ID:      message.MessagePiece,
+				Payload: []byte{1, 'd', 'e'},
+			},
+			fails: true,
+		},
+		{
+			name: "error on empty payload",
+			input: &message.Message{
+				ID:      message.MessageExtended,
+				Payload: []byte{},
+			},
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, payload, err := message.ParseExtended(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if id != tc.output.id {
+					t.Errorf("want id %d, got %d", tc.output.id, id)
+				}
+
+				if !cmp.Equal(tc.output.payload, payload) {
+					t.Error(cmp.Diff(tc.output.payload, payload))
+				}
+			}
+		})
+	}
+}
+
+func TestParseExtendedHandshake(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  *message.Message
+		output *message.ExtendedHandshake
+		fails  bool
+	}{
+		{
+			name:  "correctly parses every field",
+			input: message.NewExtended(message.ExtendedHandshakeID, []byte("d1:md11:ut_metadatai1e6:ut_pexi2ee13:metadata_sizei31235e1:pi6881e4:reqqi250e1:v4:btor6:yourip4:\x7f\x00\x00\x01e")),
+			output: &message.ExtendedHandshake{
+				M:            map[string]int{"ut_metadata": 1, "ut_pex": 2},
+				V:            "btor",
+				P:            6881,
+				Reqq:         250,
+				YourIP:       "\x7f\x00\x00\x01",
+				MetadataSize: 31235,
+			},
+			fails: false,
+		},
+		{
+			name:   "unknown keys are ignored",
+			input:  message.NewExtended(message.ExtendedHandshakeID, []byte("d4:ipv64:\x00\x00\x00\x001:mdee")),
+			output: &message.ExtendedHandshake{M: map[string]int{}},
+			fails:  false,
+		},
+		{
+			name:  "error on other extended message ID",
+			input: message.NewExtended(1, []byte("d1:mdee")),
+			fails: true,
+		},
+		{
+			name:  "error on non dictionary payload",
+			input: message.NewExtended(message.ExtendedHandshakeID, []byte("li1ee")),
+			fails: true,
+		},
+		{
+			name:  "error on out of range extended message ID",
+			input: message.NewExtended(message.ExtendedHandshakeID, []byte("d1:md6:ut_pexi256eee")),
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := message.ParseExtendedHandshake(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestNewExtendedHandshake(t *testing.T) {
+	t.Parallel()
+
+	hs := &message.ExtendedHandshake{
+		M: map[string]int{"ut_pex": 1},
+		V: "btor",
+	}
+
+	msg, err := message.NewExtendedHandshake(hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the empty fields are left out
+	want := []byte("\x00d1:md6:ut_pexi1ee1:v4:btore")
+	if !cmp.Equal(want, msg.Payload) {
+		t.Error(cmp.Diff(want, msg.Payload))
+	}
+
+	got, err := message.ParseExtendedHandshake(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(hs, got) {
+		t.Error(cmp.Diff(hs, got))
+	}
+}
@@ -20,6 +20,9 @@ const (
 	MessageCancel
 )
 
+// MessageExtended is used by the extension protocol described in BEP 10
+const MessageExtended MessageID = 20
+
 type Message struct {
 	ID      MessageID
 	Payload []byte
@@ -34,8 +37,9 @@ func New(id MessageID, payload []byte) *Message {
 
 // Serialize encodes the message into byte slice suitable for sending through the wire
 func (m *Message) Serialize() []byte {
-	length := 5 + len(m.Payload)
-	buf := make([]byte, length)
+	// the length prefix covers the message ID and the payload
+	length := 1 + len(m.Payload)
+	buf := make([]byte, 4+length)
 	binary.BigEndian.PutUint32(buf[0:4], uint32(length))
 	buf[4] = byte(m.ID)
 	copy(buf[5:], m.Payload)
@@ -85,6 +89,8 @@ func Read(r io.Reader) (*Message, error) {
 		message.ID = MessagePiece
 	case 8:
 		message.ID = MessageCancel
+	case 20:
+		message.ID = MessageExtended
 	default:
 		return nil, fmt.Errorf("invalid message.ID: %d", msgBuf[0])
 	}
@@ -21,12 +21,12 @@ func TestSerialize(t *testing.T) {
 		{
 			name:   "message with no payload",
 			input:  message.New(message.MessageInterested, nil),
-			output: []byte{0, 0, 0, 5, 2},
+			output: []byte{0, 0, 0, 1, 2},
 		},
 		{
 			name:   "message with payload",
 			input:  message.New(message.MessageRequest, []byte{0, 0, 0, 100}),
-			output: []byte{0, 0, 0, 8, 6, 0, 0, 0, 100},
+			output: []byte{0, 0, 0, 5, 6, 0, 0, 0, 100},
 		},
 	}
 
@@ -35,7 +35,7 @@ func TestSerialize(t *testing.T) {
 			got := tc.input.Serialize()
 
 			if !cmp.Equal(tc.output, got) {
-				cmp.Diff(tc.output, got)
+				t.Error(cmp.Diff(tc.output, got))
 			}
 		})
 	}
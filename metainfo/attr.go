@@ -0,0 +1,33 @@
+package metainfo
+
+import "strings"
+
+// Attr holds the file attribute flags described in BEP 47
+type Attr string
+
+const (
+	AttrPad        = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+// Pad reports whether the file only pads the content to a piece boundary,
+// pad files are filled with zeros and never written to disk
+func (a Attr) Pad() bool {
+	return strings.ContainsRune(string(a), AttrPad)
+}
+
+func (a Attr) Executable() bool {
+	return strings.ContainsRune(string(a), AttrExecutable)
+}
+
+func (a Attr) Hidden() bool {
+	return strings.ContainsRune(string(a), AttrHidden)
+}
+
+// Symlink reports whether the file is a symbolic link to the symlink path,
+// symbolic links have no content
+func (a Attr) Symlink() bool {
+	return strings.ContainsRune(string(a), AttrSymlink)
+}
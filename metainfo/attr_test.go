@@ -0,0 +1,53 @@
+package metainfo_test
+
+import (
+	"testing"
+
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestAttr(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		input      metainfo.Attr
+		pad        bool
+		executable bool
+		hidden     bool
+		symlink    bool
+	}{
+		{
+			name: "no attributes",
+		},
+		{
+			name:  "pad file",
+			input: "p",
+			pad:   true,
+		},
+		{
+			name:       "hidden executable",
+			input:      "xh",
+			executable: true,
+			hidden:     true,
+		},
+		{
+			name:    "symbolic link with unknown attribute",
+			input:   "lz",
+			symlink: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := []bool{tc.input.Pad(), tc.input.Executable(), tc.input.Hidden(), tc.input.Symlink()}
+			want := []bool{tc.pad, tc.executable, tc.hidden, tc.symlink}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("want pad, executable, hidden, symlink %v, got %v", want, got)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package metainfo
+
+// ContentFile is a file of the torrent along with its position in the torrent content
+type ContentFile struct {
+	// Path is relative to the torrent root directory, single file torrents
+	// have a single file named after the torrent
+	Path   []string
+	Offset int
+	Length int
+	Attr   Attr
+	// SymlinkPath is the target of a symbolic link relative to the torrent root directory
+	SymlinkPath []string
+}
+
+// FileSpan is the part of a file covered by a range of the torrent content
+type FileSpan struct {
+	File ContentFile
+	// FileOffset is the position of the span within the file
+	FileOffset int
+	// Offset is the position of the span within the requested range
+	Offset int
+	Length int
+}
+
+// ContentFiles returns the files of the torrent in order. v1 files are laid
+// out back to back while v2 files start at piece boundaries
+func (m *Metainfo) ContentFiles() []ContentFile {
+	var files []ContentFile
+	switch {
+	case m.IsV1() && m.Multifile:
+		var offset int
+		for _, f := range m.Info.Files {
+			files = append(files, ContentFile{f.Path, offset, f.Length, f.Attr, f.SymlinkPath})
+			offset += f.Length
+		}
+	case m.IsV1():
+		files = append(files, ContentFile{[]string{m.Info.Name}, 0, m.Info.Length, m.Info.Attr, nil})
+	default:
+		for _, f := range m.FilesV2 {
+			files = append(files, ContentFile{f.Path, f.Offset, f.Length, f.Attr, f.SymlinkPath})
+		}
+	}
+
+	return files
+}
+
+// Spans maps a range of the torrent content to the parts of the files it
+// covers. Bytes between v2 files are not covered by any span
+func (m *Metainfo) Spans(offset, length int) []FileSpan {
+	var spans []FileSpan
+	end := offset + length
+	for _, f := range m.ContentFiles() {
+		start := max(offset, f.Offset)
+		stop := min(end, f.Offset+f.Length)
+		if start >= stop {
+			continue
+		}
+
+		spans = append(spans, FileSpan{
+			File:       f,
+			FileOffset: start - f.Offset,
+			Offset:     start - offset,
+			Length:     stop - start,
+		})
+	}
+
+	return spans
+}
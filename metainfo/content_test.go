@@ -0,0 +1,139 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestSpans(t *testing.T) {
+	t.Parallel()
+
+	multi := &metainfo.Metainfo{
+		Info: metainfo.Info{
+			Name: "test",
+			Files: []metainfo.FileEntry{
+				{Length: 1000, Path: []string{"foo", "bar.png"}},
+				{Length: 0, Path: []string{"empty"}},
+				{Length: 3000, Path: []string{"foo", "baz.jpg"}},
+			},
+			Length: 4000,
+		},
+		Multifile: true,
+	}
+
+	single := &metainfo.Metainfo{
+		Info: metainfo.Info{
+			Name:   "test.txt",
+			Length: 4000,
+		},
+	}
+
+	barFile := metainfo.ContentFile{Path: []string{"foo", "bar.png"}, Offset: 0, Length: 1000}
+	bazFile := metainfo.ContentFile{Path: []string{"foo", "baz.jpg"}, Offset: 1000, Length: 3000}
+	singleFile := metainfo.ContentFile{Path: []string{"test.txt"}, Offset: 0, Length: 4000}
+
+	cases := []struct {
+		name   string
+		mi     *metainfo.Metainfo
+		offset int
+		length int
+		output []metainfo.FileSpan
+	}{
+		{
+			name:   "range within a single file",
+			mi:     single,
+			offset: 2000,
+			length: 1000,
+			output: []metainfo.FileSpan{{File: singleFile, FileOffset: 2000, Offset: 0, Length: 1000}},
+		},
+		{
+			name:   "range across files skips empty files",
+			mi:     multi,
+			offset: 500,
+			length: 1000,
+			output: []metainfo.FileSpan{
+				{File: barFile, FileOffset: 500, Offset: 0, Length: 500},
+				{File: bazFile, FileOffset: 0, Offset: 500, Length: 500},
+			},
+		},
+		{
+			name:   "range truncated at the end of the content",
+			mi:     multi,
+			offset: 3500,
+			length: 1000,
+			output: []metainfo.FileSpan{{File: bazFile, FileOffset: 2500, Offset: 0, Length: 500}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.mi.Spans(tc.offset, tc.length)
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestSpans_V2(t *testing.T) {
+	t.Parallel()
+
+	torrent, _ := buildTorrent(t, false, nil)
+	mi, err := metainfo.Parse(bytes.NewReader(torrent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bytes between the end of the first file and the next piece boundary are not part of any file
+	got := mi.Spans(32768, 2*32768)
+	want := []metainfo.FileSpan{
+		{File: metainfo.ContentFile{Path: []string{"a", "a.bin"}, Offset: 0, Length: 40000}, FileOffset: 32768, Offset: 0, Length: 40000 - 32768},
+		{File: metainfo.ContentFile{Path: []string{"b.txt"}, Offset: 65536, Length: 100}, FileOffset: 0, Offset: 32768, Length: 100},
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestWebSeeds(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  []byte
+		output []string
+	}{
+		{
+			name:   "url-list as a single string",
+			input:  []byte("d8:announce28:example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e8:url-list19:http://example.com/e"),
+			output: []string{"http://example.com/"},
+		},
+		{
+			name:   "url-list as a list skipping empty and non http urls",
+			input:  []byte("d8:announce28:example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e8:url-listl19:http://example.com/0:13:ftp://foo/bar20:https://example.com/ee"),
+			output: []string{"http://example.com/", "https://example.com/"},
+		},
+		{
+			name:   "empty url-list",
+			input:  []byte("d8:announce28:example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e8:url-list0:e"),
+			output: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mi, err := metainfo.Parse(bytes.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := mi.WebSeeds(); !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
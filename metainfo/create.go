@@ -0,0 +1,273 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	MinPieceLength = 16384    // 16KiB
+	MaxPieceLength = 16777216 // 16MiB
+	// targetNumPieces is the number of pieces aimed for when selecting a piece length
+	targetNumPieces = 1500
+)
+
+var (
+	ErrNoFiles      = errors.New("no files to include in torrent")
+	ErrEmptyContent = errors.New("content to include in torrent is empty")
+)
+
+type CreateOptions struct {
+	// PieceLength is selected from the content size when zero
+	PieceLength int
+	// Exclude holds glob patterns matched against the slash separated path of
+	// every file relative to the root as well as against its base name
+	Exclude []string
+	// Private sets the private flag of the info dictionary
+	Private bool
+	// Workers is the number of pieces hashed in parallel, defaults to the number of CPUs
+	Workers int
+}
+
+// diskFile is a file on disk backing a part of the torrent content
+type diskFile struct {
+	path   string
+	offset int
+	length int
+}
+
+// Create builds the metainfo of a v1 torrent for a file or a directory by
+// hashing its content. Only the info dictionary and the info hash are set,
+// the remaining fields are left to the caller
+func Create(root string, opts CreateOptions) (*Metainfo, error) {
+	stat, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+
+	mi := &Metainfo{
+		Info: Info{
+			Name:    filepath.Base(filepath.Clean(root)),
+			Private: opts.Private,
+		},
+	}
+
+	var files []diskFile
+	if stat.IsDir() {
+		mi.Multifile = true
+		mi.Info.Files, files, err = walkContent(root, opts.Exclude)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if excluded(opts.Exclude, stat.Name(), stat.Name()) {
+			return nil, ErrNoFiles
+		}
+		files = []diskFile{{root, 0, int(stat.Size())}}
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
+
+	for _, f := range files {
+		mi.Info.Length += f.length
+	}
+
+	// a torrent without pieces cannot be downloaded
+	if mi.Info.Length == 0 {
+		return nil, ErrEmptyContent
+	}
+
+	mi.Info.PieceLength = opts.PieceLength
+	if mi.Info.PieceLength == 0 {
+		mi.Info.PieceLength = PieceLengthFor(mi.Info.Length)
+	}
+
+	if mi.Info.PieceLength < MinPieceLength || mi.Info.PieceLength&(mi.Info.PieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two of at least %d, got %d", MinPieceLength, mi.Info.PieceLength)
+	}
+
+	pieces, err := hashPieces(files, mi.Info.Length, mi.Info.PieceLength, opts.Workers)
+	if err != nil {
+		return nil, err
+	}
+	mi.Info.Pieces = string(pieces)
+
+	if err = mi.SetInfoHashes(); err != nil {
+		return nil, err
+	}
+
+	return mi, nil
+}
+
+// PieceLengthFor selects a power of two piece length giving roughly
+// targetNumPieces pieces for the content length
+func PieceLengthFor(length int) int {
+	pieceLength := MinPieceLength
+	for pieceLength < MaxPieceLength && length/pieceLength > targetNumPieces {
+		pieceLength <<= 1
+	}
+
+	return pieceLength
+}
+
+// walkContent lists the regular files under root in lexical order
+func walkContent(root string, exclude []string) ([]FileEntry, []diskFile, error) {
+	var (
+		entries []FileEntry
+		files   []diskFile
+		offset  int
+	)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel == "." {
+			return nil
+		}
+
+		if excluded(exclude, rel, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		// symlinks are included when they point to a regular file
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		length := int(info.Size())
+		entries = append(entries, FileEntry{
+			Length: length,
+			Path:   strings.Split(rel, "/"),
+		})
+		files = append(files, diskFile{p, offset, length})
+		offset += length
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return entries, files, nil
+}
+
+func excluded(patterns []string, rel, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashPieces computes the SHA-1 hashes of every piece of the content in parallel
+func hashPieces(files []diskFile, length, pieceLength, workers int) ([]byte, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	numPieces := (length + pieceLength - 1) / pieceLength
+	hashes := make([]byte, numPieces*sha1.Size)
+
+	var egr errgroup.Group
+	egr.SetLimit(workers)
+
+	for i := range numPieces {
+		egr.Go(func() error {
+			offset := i * pieceLength
+			buf := make([]byte, min(pieceLength, length-offset))
+			if err := readContent(files, offset, buf); err != nil {
+				return err
+			}
+
+			hash := sha1.Sum(buf)
+			copy(hashes[i*sha1.Size:], hash[:])
+			return nil
+		})
+	}
+
+	if err := egr.Wait(); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// readContent fills buf with the content starting at offset, reading across file boundaries
+func readContent(files []diskFile, offset int, buf []byte) error {
+	for _, f := range files {
+		if len(buf) == 0 {
+			break
+		}
+
+		if offset >= f.offset+f.length || f.length == 0 {
+			continue
+		}
+
+		n := min(len(buf), f.offset+f.length-offset)
+		if err := readFileAt(f.path, int64(offset-f.offset), buf[:n]); err != nil {
+			return err
+		}
+
+		buf = buf[n:]
+		offset += n
+	}
+
+	if len(buf) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
+func readFileAt(name string, offset int64, buf []byte) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := f.ReadAt(buf, offset)
+	if n == len(buf) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,166 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func writeFiles(t *testing.T, root string, files map[string][]byte) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	t.Parallel()
+
+	a := bytes.Repeat([]byte("a"), 20000)
+	b := bytes.Repeat([]byte("b"), 30000)
+	c := []byte("c")
+
+	root := filepath.Join(t.TempDir(), "content")
+	writeFiles(t, root, map[string][]byte{
+		"a.bin":         a,
+		"sub/b.bin":     b,
+		"sub/c.txt":     c,
+		"sub/skip.log":  []byte("excluded"),
+		"tmp/junk.bin":  []byte("excluded"),
+		"sub/z/e.empty": nil,
+	})
+
+	mi, err := metainfo.Create(root, metainfo.CreateOptions{
+		PieceLength: 16384,
+		Exclude:     []string{"*.log", "tmp"},
+		Private:     true,
+		Workers:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantFiles := []metainfo.FileEntry{
+		{Length: len(a), Path: []string{"a.bin"}},
+		{Length: len(b), Path: []string{"sub", "b.bin"}},
+		{Length: len(c), Path: []string{"sub", "c.txt"}},
+		{Length: 0, Path: []string{"sub", "z", "e.empty"}},
+	}
+	if !cmp.Equal(wantFiles, mi.Info.Files) {
+		t.Error(cmp.Diff(wantFiles, mi.Info.Files))
+	}
+
+	content := append(append(append([]byte(nil), a...), b...), c...)
+	var pieces []byte
+	for start := 0; start < len(content); start += 16384 {
+		h := sha1.Sum(content[start:min(start+16384, len(content))])
+		pieces = append(pieces, h[:]...)
+	}
+	if mi.Info.Pieces != string(pieces) {
+		t.Error("unexpected piece hashes")
+	}
+
+	if mi.Info.Name != "content" || mi.Info.Length != len(content) || !mi.Multifile || !mi.Info.Private {
+		t.Errorf("unexpected info: name %q, length %d, multifile %v, private %v", mi.Info.Name, mi.Info.Length, mi.Multifile, mi.Info.Private)
+	}
+
+	// the created metainfo round trips through Parse
+	mi.Announce = "http://tracker.example.com/announce"
+	encoded, err := mi.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := metainfo.Parse(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(mi, parsed) {
+		t.Error(cmp.Diff(mi, parsed))
+	}
+}
+
+func TestCreate_SingleFile(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	content := bytes.Repeat([]byte("x"), 50000)
+	writeFiles(t, root, map[string][]byte{"file.bin": content})
+
+	mi, err := metainfo.Create(filepath.Join(root, "file.bin"), metainfo.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mi.Multifile || mi.Info.Name != "file.bin" || mi.Info.Length != len(content) || mi.Info.Files != nil {
+		t.Errorf("unexpected single file info: %+v", mi.Info)
+	}
+
+	if mi.Info.PieceLength != metainfo.MinPieceLength {
+		t.Errorf("want piece length %d, got %d", metainfo.MinPieceLength, mi.Info.PieceLength)
+	}
+
+	if len(mi.Info.Pieces) != 4*sha1.Size {
+		t.Errorf("want 4 pieces, got %d", len(mi.Info.Pieces)/sha1.Size)
+	}
+}
+
+func TestCreate_Errors(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string][]byte{"only.log": []byte("x")})
+
+	if _, err := metainfo.Create(root, metainfo.CreateOptions{Exclude: []string{"*.log"}}); !errors.Is(err, metainfo.ErrNoFiles) {
+		t.Errorf("want %v, got %v", metainfo.ErrNoFiles, err)
+	}
+
+	if _, err := metainfo.Create(root, metainfo.CreateOptions{PieceLength: 20000}); err == nil {
+		t.Error("expect error on invalid piece length, got nil")
+	}
+
+	if _, err := metainfo.Create(filepath.Join(root, "missing"), metainfo.CreateOptions{}); err == nil {
+		t.Error("expect error on missing path, got nil")
+	}
+
+	empty := t.TempDir()
+	writeFiles(t, empty, map[string][]byte{"a.txt": nil, "b/c.txt": nil})
+
+	if _, err := metainfo.Create(empty, metainfo.CreateOptions{}); !errors.Is(err, metainfo.ErrEmptyContent) {
+		t.Errorf("want %v, got %v", metainfo.ErrEmptyContent, err)
+	}
+}
+
+func TestPieceLengthFor(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		length int
+		want   int
+	}{
+		{length: 0, want: 16384},
+		{length: 10 << 20, want: 16384},
+		{length: 1 << 30, want: 1 << 20},
+		{length: 1 << 40, want: metainfo.MaxPieceLength},
+	}
+
+	for _, tc := range cases {
+		if got := metainfo.PieceLengthFor(tc.length); got != tc.want {
+			t.Errorf("length %d: want %d, got %d", tc.length, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package metainfo
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// Torrent is an editable torrent file. Keys outside of the info dictionary can
+// be changed freely, changing the info dictionary gives the torrent new info
+// hashes. Keys that are not edited are written back byte for byte
+type Torrent struct {
+	dict *RawDict
+	info *RawDict
+}
+
+// ReadTorrent reads a torrent file for editing
+func ReadTorrent(r io.Reader) (*Torrent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, err := ParseRawDict(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawInfo, ok := dict.Get("info")
+	if !ok {
+		return nil, errors.New("missing info dictionary")
+	}
+
+	info, err := ParseRawDict(rawInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Torrent{dict, info}, nil
+}
+
+// Dict returns the top level dictionary for edits not covered by the other methods.
+// The info key is overwritten by Info when the info dictionary is modified
+func (t *Torrent) Dict() *RawDict {
+	return t.dict
+}
+
+// Info returns the info dictionary
+func (t *Torrent) Info() *RawDict {
+	return t.info
+}
+
+// InfoModified reports whether the info dictionary, and thus the info hashes, changed
+func (t *Torrent) InfoModified() bool {
+	return t.info.Modified()
+}
+
+// SetAnnounceTiers replaces the trackers, the announce-list is only set when
+// there is more than one tracker. No tiers removes every tracker, empty tiers are dropped
+func (t *Torrent) SetAnnounceTiers(tiers [][]string) error {
+	t.dict.Delete("announce")
+	t.dict.Delete("announce-list")
+
+	tiers = nonEmptyTiers(tiers)
+	if len(tiers) == 0 {
+		return nil
+	}
+
+	if err := t.dict.Set("announce", tiers[0][0]); err != nil {
+		return err
+	}
+
+	if len(tiers) > 1 || len(tiers[0]) > 1 {
+		return t.dict.Set("announce-list", tiers)
+	}
+
+	return nil
+}
+
+// WebSeeds returns the urls of the url-list
+func (t *Torrent) WebSeeds() ([]string, error) {
+	raw, ok := t.dict.Get("url-list")
+	if !ok {
+		return nil, nil
+	}
+
+	var urls stringList
+	if err := bencode.Unmarshal(raw, &urls); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// SetWebSeeds replaces the url-list, no urls removes it
+func (t *Torrent) SetWebSeeds(urls []string) error {
+	if len(urls) == 0 {
+		t.dict.Delete("url-list")
+		return nil
+	}
+
+	return t.dict.Set("url-list", urls)
+}
+
+// SetComment sets the comment, an empty comment removes it
+func (t *Torrent) SetComment(comment string) error {
+	return t.setString(t.dict, "comment", comment)
+}
+
+// SetCreatedBy sets the name of the program which created the torrent, an empty name removes it
+func (t *Torrent) SetCreatedBy(createdBy string) error {
+	return t.setString(t.dict, "created by", createdBy)
+}
+
+// SetCreationDate sets the creation date, the zero time removes it
+func (t *Torrent) SetCreationDate(date time.Time) error {
+	if date.IsZero() {
+		t.dict.Delete("creation date")
+		return nil
+	}
+
+	return t.dict.Set("creation date", date.Unix())
+}
+
+// SetSource sets the source tag of the info dictionary used by private
+// trackers to tell apart otherwise identical torrents, an empty source removes it.
+// This changes the info hashes
+func (t *Torrent) SetSource(source string) error {
+	return t.setString(t.info, "source", source)
+}
+
+// SetPrivate sets or removes the private flag. This changes the info hashes
+func (t *Torrent) SetPrivate(private bool) error {
+	if !private {
+		t.info.Delete("private")
+		return nil
+	}
+
+	return t.info.Set("private", 1)
+}
+
+func (t *Torrent) setString(d *RawDict, key, value string) error {
+	if len(value) == 0 {
+		d.Delete(key)
+		return nil
+	}
+
+	return d.Set(key, value)
+}
+
+// Bytes returns the bencoded torrent file
+func (t *Torrent) Bytes() ([]byte, error) {
+	if t.info.Modified() {
+		if err := t.dict.SetRaw("info", t.info.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.dict.Bytes(), nil
+}
+
+// Write writes the bencoded torrent file to w
+func (t *Torrent) Write(w io.Writer) error {
+	data, err := t.Bytes()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// Metainfo parses the edited torrent, which may have no trackers
+func (t *Torrent) Metainfo() (*Metainfo, error) {
+	data, err := t.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseTorrent(data)
+}
@@ -0,0 +1,220 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestTorrentEdit(t *testing.T) {
+	t.Parallel()
+
+	// unknown keys are preserved
+	input := []byte("d8:announce28:example.tracker.com/announce7:comment3:old4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222226:x-infoi1ee1:zd1:ai1eee")
+
+	cases := []struct {
+		name         string
+		edit         func(tr *metainfo.Torrent) error
+		output       []byte
+		infoModified bool
+	}{
+		{
+			name:   "no edits",
+			edit:   func(tr *metainfo.Torrent) error { return nil },
+			output: input,
+		},
+		{
+			name: "replace trackers and comment",
+			edit: func(tr *metainfo.Torrent) error {
+				if err := tr.SetAnnounceTiers([][]string{{"udp://a.example.com:80"}, {"udp://b.example.com:80"}}); err != nil {
+					return err
+				}
+				return tr.SetComment("new")
+			},
+			output: []byte("d8:announce22:udp://a.example.com:8013:announce-listll22:udp://a.example.com:80el22:udp://b.example.com:80ee7:comment3:new4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222226:x-infoi1ee1:zd1:ai1eee"),
+		},
+		{
+			name: "drop empty tiers",
+			edit: func(tr *metainfo.Torrent) error {
+				return tr.SetAnnounceTiers([][]string{{}, {"", "udp://a.example.com:80"}})
+			},
+			output: []byte("d8:announce22:udp://a.example.com:807:comment3:old4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222226:x-infoi1ee1:zd1:ai1eee"),
+		},
+		{
+			name: "remove trackers and comment, add web seeds and creation date",
+			edit: func(tr *metainfo.Torrent) error {
+				if err := tr.SetAnnounceTiers(nil); err != nil {
+					return err
+				}
+				if err := tr.SetComment(""); err != nil {
+					return err
+				}
+				if err := tr.SetCreationDate(time.Unix(1700000000, 0)); err != nil {
+					return err
+				}
+				return tr.SetWebSeeds([]string{"http://example.com/"})
+			},
+			output: []byte("d13:creation datei1700000000e4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222226:x-infoi1ee8:url-listl19:http://example.com/e1:zd1:ai1eee"),
+		},
+		{
+			name: "setting the current value keeps the info dictionary",
+			edit: func(tr *metainfo.Torrent) error {
+				if err := tr.SetSource(""); err != nil {
+					return err
+				}
+				return tr.SetPrivate(false)
+			},
+			output: input,
+		},
+		{
+			name: "set source and private flag",
+			edit: func(tr *metainfo.Torrent) error {
+				if err := tr.SetSource("TRACKER"); err != nil {
+					return err
+				}
+				return tr.SetPrivate(true)
+			},
+			output:       []byte("d8:announce28:example.tracker.com/announce7:comment3:old4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222227:privatei1e6:source7:TRACKER6:x-infoi1ee1:zd1:ai1eee"),
+			infoModified: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := metainfo.ReadTorrent(bytes.NewReader(input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = tc.edit(tr); err != nil {
+				t.Fatal(err)
+			}
+
+			var buf bytes.Buffer
+			if err = tr.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(tc.output, buf.Bytes()) {
+				t.Errorf("want %q, got %q", tc.output, buf.Bytes())
+			}
+
+			if tr.InfoModified() != tc.infoModified {
+				t.Errorf("want info modified %v, got %v", tc.infoModified, tr.InfoModified())
+			}
+		})
+	}
+}
+
+func TestTorrentWebSeeds(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  []byte
+		output []string
+	}{
+		{
+			name:   "url-list as a single string",
+			input:  []byte("d4:infod4:name1:ae8:url-list19:http://example.com/e"),
+			output: []string{"http://example.com/"},
+		},
+		{
+			name:   "url-list as a list",
+			input:  []byte("d4:infod4:name1:ae8:url-listl19:http://example.com/20:https://example.com/ee"),
+			output: []string{"http://example.com/", "https://example.com/"},
+		},
+		{
+			name:   "no url-list",
+			input:  []byte("d4:infod4:name1:aee"),
+			output: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := metainfo.ReadTorrent(bytes.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := tr.WebSeeds()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestParseRawDict(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  []byte
+		output []string
+		fails  bool
+	}{
+		{
+			name:   "nested values",
+			input:  []byte("d1:ali-1e3:abce1:bd1:cdee1:d0:e"),
+			output: []string{"a", "b", "d"},
+		},
+		{
+			name:  "error on non dictionary",
+			input: []byte("li1ee"),
+			fails: true,
+		},
+		{
+			name:  "error on unterminated dictionary",
+			input: []byte("d1:ai1e"),
+			fails: true,
+		},
+		{
+			name:  "error on trailing data",
+			input: []byte("d1:ai1eee"),
+			fails: true,
+		},
+		{
+			name:  "error on string exceeding data",
+			input: []byte("d1:a10:abce"),
+			fails: true,
+		},
+		{
+			name:  "error on non string key",
+			input: []byte("di1e1:ae"),
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := metainfo.ParseRawDict(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, d.Keys()) {
+				t.Error(cmp.Diff(tc.output, d.Keys()))
+			}
+
+			if !bytes.Equal(tc.input, d.Bytes()) {
+				t.Errorf("want %q, got %q", tc.input, d.Bytes())
+			}
+		})
+	}
+}
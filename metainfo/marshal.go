@@ -0,0 +1,151 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"io"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// Marshal encodes the metainfo into a bencoded torrent file which can be read back with Parse
+func (m *Metainfo) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Write writes the metainfo as a bencoded torrent file to w
+func (m *Metainfo) Write(w io.Writer) error {
+	info, err := m.MarshalInfo()
+	if err != nil {
+		return err
+	}
+
+	tf := torrentFile{
+		Announce:     m.Announce,
+		AnnounceList: m.AnnounceList,
+		Comment:      m.Comment,
+		CreatedBy:    m.CreatedBy,
+		Encoding:     m.Encoding,
+		Info:         info,
+		PieceLayers:  m.PieceLayers,
+		URLList:      m.URLList,
+		Nodes:        m.Nodes,
+		Extra:        m.Extra,
+	}
+
+	if !m.CreationDate.IsZero() {
+		tf.CreationDate = m.CreationDate.Unix()
+	}
+
+	return bencode.NewEncoder(w).Encode(tf)
+}
+
+// MarshalInfo encodes the info dictionary, the info hashes are computed over its result
+func (m *Metainfo) MarshalInfo() ([]byte, error) {
+	return bencode.Marshal(m.Info.toMap(m.Multifile))
+}
+
+// SetInfoHashes computes the info hashes from the current info dictionary
+func (m *Metainfo) SetInfoHashes() error {
+	infoDict, err := m.MarshalInfo()
+	if err != nil {
+		return err
+	}
+
+	if m.IsV1() {
+		hash := sha1.Sum(infoDict)
+		m.InfoHash = hash[:]
+	}
+
+	if m.IsV2() {
+		hash := sha256.Sum256(infoDict)
+		m.InfoHashV2 = hash[:]
+	}
+
+	return nil
+}
+
+func (i *Info) toMap(multifile bool) map[string]interface{} {
+	// the known keys overwrite the extra ones
+	info := extraMap(i.Extra)
+	info["name"] = i.Name
+	info["piece length"] = i.PieceLength
+
+	if len(i.Pieces) > 0 {
+		info["pieces"] = i.Pieces
+	}
+
+	// the length of a multifile torrent is derived from its files
+	if multifile {
+		if i.Files != nil {
+			files := make([]interface{}, len(i.Files))
+			for j, f := range i.Files {
+				file := extraMap(f.Extra)
+				file["length"] = f.Length
+				file["path"] = f.Path
+
+				if len(f.Attr) > 0 {
+					file["attr"] = string(f.Attr)
+				}
+
+				if f.SymlinkPath != nil {
+					file["symlink path"] = f.SymlinkPath
+				}
+
+				if len(f.SHA1) > 0 {
+					file["sha1"] = f.SHA1
+				}
+
+				if len(f.MD5Sum) > 0 {
+					file["md5sum"] = f.MD5Sum
+				}
+
+				files[j] = file
+			}
+			info["files"] = files
+		}
+	} else if i.MetaVersion != 2 || len(i.Pieces) > 0 {
+		info["length"] = i.Length
+	}
+
+	if i.MetaVersion != 0 {
+		info["meta version"] = i.MetaVersion
+	}
+
+	if i.FileTree != nil {
+		info["file tree"] = i.FileTree
+	}
+
+	if len(i.Attr) > 0 {
+		info["attr"] = string(i.Attr)
+	}
+
+	if len(i.Source) > 0 {
+		info["source"] = i.Source
+	}
+
+	if len(i.MD5Sum) > 0 {
+		info["md5sum"] = i.MD5Sum
+	}
+
+	if i.Private {
+		info["private"] = 1
+	}
+
+	return info
+}
+
+func extraMap(extra map[string]bencode.RawMessage) map[string]interface{} {
+	m := make(map[string]interface{}, len(extra))
+	for k, v := range extra {
+		m[k] = v
+	}
+
+	return m
+}
@@ -0,0 +1,103 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	v2, _ := buildTorrent(t, false, nil)
+	hybrid, _ := buildTorrent(t, true, nil)
+
+	cases := []struct {
+		name  string
+		input []byte
+	}{
+		{
+			name:  "single file metainfo",
+			input: []byte("d8:announce28:example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222ee"),
+		},
+		{
+			name:  "multifile metainfo",
+			input: []byte("d8:announce28:example.tracker.com/announce4:infod5:filesld6:lengthi1000e4:pathl3:foo7:bar.pngeed6:lengthi3000e4:pathl3:foo7:baz.jpgeee4:name4:test12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222ee"),
+		},
+		{
+			name:  "metainfo with optional fields",
+			input: []byte("d8:announce28:example.tracker.com/announce13:announce-listll28:example.tracker.com/announceel27:backup.tracker.com/announceee7:comment5:hello10:created by4:btor13:creation datei1700000000e4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222227:privatei1ee8:url-listl19:http://example.com/ee"),
+		},
+		{
+			name:  "metainfo with file attributes",
+			input: []byte("d8:announce28:example.tracker.com/announce4:infod5:filesld4:attr1:x6:lengthi1000e4:pathl3:foo7:bar.bine4:sha120:33333333333333333333ed4:attr1:l6:lengthi0e4:pathl4:linke12:symlink pathl3:foo7:bar.bineed4:attr1:p6:lengthi4000e4:pathl4:.pad4:4000eee4:name4:test12:piece lengthi5000e6:pieces20:11111111111111111111ee"),
+		},
+		{
+			name:  "metainfo with checksums, source and unknown keys",
+			input: []byte("d8:announce28:example.tracker.com/announce8:encoding5:UTF-84:infod5:filesld6:lengthi1000e6:md5sum32:0123456789abcdef0123456789abcdef4:pathl3:foo7:bar.bine3:x-yi1eee4:name4:test10:name.utf-84:test12:piece lengthi5000e6:pieces20:111111111111111111116:source3:fooe9:publisher4:acmee"),
+		},
+		{
+			name:  "v2 metainfo",
+			input: v2,
+		},
+		{
+			name:  "hybrid metainfo",
+			input: hybrid,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			want, err := metainfo.Parse(bytes.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoded, err := want.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// canonical input is reproduced byte for byte
+			if !bytes.Equal(tc.input, encoded) {
+				t.Errorf("want %q, got %q", tc.input, encoded)
+			}
+
+			got, err := metainfo.Parse(bytes.NewReader(encoded))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(want, got) {
+				t.Error(cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestSetInfoHashes(t *testing.T) {
+	t.Parallel()
+
+	mi := &metainfo.Metainfo{
+		Announce: "example.tracker.com/announce",
+		Info: metainfo.Info{
+			Length:      10000,
+			Name:        "test.txt",
+			PieceLength: 5000,
+			Pieces:      "1111111111111111111122222222222222222222",
+		},
+		CreationDate: time.Unix(1700000000, 0),
+	}
+
+	if err := mi.SetInfoHashes(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{84, 239, 11, 8, 172, 68, 174, 25, 34, 176, 23, 187, 185, 190, 201, 204, 180, 99, 219, 216}
+	if !cmp.Equal(want, mi.InfoHash) {
+		t.Error(cmp.Diff(want, mi.InfoHash))
+	}
+}
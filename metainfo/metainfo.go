@@ -2,76 +2,319 @@ package metainfo
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/jackpal/bencode-go"
-	"github.com/mitchellh/mapstructure"
+	"github.com/kanowfy/btor/bencode"
 )
 
 var (
 	ErrUnsupportedProtocol = errors.New("unsupported protocol")
+	ErrMissingPieceLayers  = errors.New("missing piece layers, v2 only torrents need their torrent file")
 )
 
 type Info struct {
-	Length      int         `mapstructure:"length"`
-	Files       []FileEntry `mapstructure:"files"`
-	Name        string      `mapstructure:"name"`
-	PieceLength int         `mapstructure:"piece length"`
-	Pieces      string      `mapstructure:"pieces"`
+	Length      int                    `bencode:"length"`
+	Files       []FileEntry            `bencode:"files"`
+	Name        string                 `bencode:"name"`
+	PieceLength int                    `bencode:"piece length"`
+	Pieces      string                 `bencode:"pieces"`
+	MetaVersion int                    `bencode:"meta version"`
+	FileTree    map[string]interface{} `bencode:"file tree"`
+	Private     bool                   `bencode:"private"`
+	// Attr holds the attributes of the file of a single file torrent
+	Attr Attr `bencode:"attr"`
+	// Source tells apart otherwise identical torrents, mostly set by private trackers
+	Source string `bencode:"source"`
+	// MD5Sum is an optional hex encoded MD5 hash of the file of a single file torrent
+	MD5Sum string `bencode:"md5sum"`
+	// Extra holds the keys not covered by the other fields, they are written
+	// back so the info hashes are preserved
+	Extra map[string]bencode.RawMessage `bencode:",remain"`
 }
 
 type FileEntry struct {
-	Length int      `mapstructure:"length"`
-	Path   []string `mapstructure:"path"`
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+	Attr   Attr     `bencode:"attr"`
+	// SymlinkPath is the target of a symbolic link relative to the torrent root directory
+	SymlinkPath []string `bencode:"symlink path"`
+	// SHA1 is an optional hash of the file content
+	SHA1 string `bencode:"sha1"`
+	// MD5Sum is an optional hex encoded MD5 hash of the file content
+	MD5Sum string                        `bencode:"md5sum"`
+	Extra  map[string]bencode.RawMessage `bencode:",remain"`
 }
 
+// Metainfo is a parsed torrent file, see torrentFile for its encoding
 type Metainfo struct {
-	Announce  string `mapstructure:"announce"`
-	Info      Info   `mapstructure:"info"`
-	InfoHash  []byte
+	Announce     string
+	AnnounceList [][]string
+	Info         Info
+	PieceLayers  map[string]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	// Encoding is the character encoding of the strings of the info dictionary
+	Encoding string
+	URLList  []string
+	// Nodes are DHT nodes to bootstrap from, mostly given by trackerless torrents
+	Nodes []Node
+	// Extra holds the top level keys not covered by the other fields
+	Extra map[string]bencode.RawMessage
+	// InfoHash is the SHA-1 info hash, set for v1 and hybrid torrents
+	InfoHash []byte
+	// InfoHashV2 is the SHA-256 info hash, set for v2 and hybrid torrents
+	InfoHashV2 []byte
+	// FilesV2 are the files of the v2 file tree in order
+	FilesV2   []FileV2
 	Multifile bool
 }
 
+// torrentFile is the encoding of a torrent file, the info dictionary is kept
+// as is since the info hashes are computed over its exact bytes
+type torrentFile struct {
+	Announce     string                        `bencode:"announce,omitempty"`
+	AnnounceList [][]string                    `bencode:"announce-list,omitempty"`
+	Comment      string                        `bencode:"comment,omitempty"`
+	CreatedBy    string                        `bencode:"created by,omitempty"`
+	CreationDate int64                         `bencode:"creation date,omitempty"`
+	Encoding     string                        `bencode:"encoding,omitempty"`
+	Info         bencode.RawMessage            `bencode:"info"`
+	PieceLayers  map[string]string             `bencode:"piece layers,omitempty"`
+	URLList      stringList                    `bencode:"url-list,omitempty"`
+	Nodes        []Node                        `bencode:"nodes,omitempty"`
+	Extra        map[string]bencode.RawMessage `bencode:",remain"`
+}
+
+// Node is a DHT node of a trackerless torrent, encoded as a list of its
+// host and port as described in BEP 5
+type Node struct {
+	Host string
+	Port int
+}
+
+func (n Node) MarshalBencode() ([]byte, error) {
+	return bencode.Marshal([]interface{}{n.Host, n.Port})
+}
+
+func (n *Node) UnmarshalBencode(data []byte) error {
+	var l []interface{}
+	if err := bencode.Unmarshal(data, &l); err != nil {
+		return err
+	}
+
+	if len(l) != 2 {
+		return fmt.Errorf("node must be a list of a host and a port")
+	}
+
+	host, ok := l[0].(string)
+	if !ok {
+		return fmt.Errorf("node host must be a string")
+	}
+
+	port, ok := l[1].(int64)
+	if !ok || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid node port %v", l[1])
+	}
+
+	n.Host, n.Port = host, int(port)
+	return nil
+}
+
+// String returns the host:port address of the node
+func (n Node) String() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.Port))
+}
+
+// stringList is a list of strings which may also be encoded as a single string
+type stringList []string
+
+func (l *stringList) UnmarshalBencode(data []byte) error {
+	var s string
+	if err := bencode.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+
+	return bencode.Unmarshal(data, (*[]string)(l))
+}
+
 // Parse parses a stream into Metainfo
 func Parse(r io.Reader) (*Metainfo, error) {
-	decoded, err := bencode.Decode(r)
+	var tf torrentFile
+	if err := bencode.NewDecoder(r).Decode(&tf); err != nil {
+		return nil, err
+	}
+
+	mi, err := tf.metainfo()
 	if err != nil {
 		return nil, err
 	}
 
-	mi := &Metainfo{}
+	// trackerless torrents find their peers through the DHT, which private
+	// torrents may not use
+	if len(mi.AnnounceTiers()) == 0 && mi.Info.Private {
+		return nil, ErrUnsupportedProtocol
+	}
+
+	return mi, nil
+}
 
-	if err = mapstructure.Decode(decoded, &mi); err != nil {
+// parseTorrent builds a Metainfo from a torrent file regardless of its trackers
+func parseTorrent(data []byte) (*Metainfo, error) {
+	var tf torrentFile
+	if err := bencode.Unmarshal(data, &tf); err != nil {
 		return nil, err
 	}
 
-	if len(mi.Announce) == 0 {
-		return nil, ErrUnsupportedProtocol
+	return tf.metainfo()
+}
+
+func (tf *torrentFile) metainfo() (*Metainfo, error) {
+	if len(tf.Info) == 0 {
+		return nil, errors.New("missing info dictionary")
+	}
+
+	mi := &Metainfo{
+		Announce:     tf.Announce,
+		AnnounceList: tf.AnnounceList,
+		PieceLayers:  tf.PieceLayers,
+		Comment:      tf.Comment,
+		CreatedBy:    tf.CreatedBy,
+		Encoding:     tf.Encoding,
+		URLList:      tf.URLList,
+		Nodes:        tf.Nodes,
+		Extra:        tf.Extra,
 	}
 
-	// calculate infohash
-	m := make(map[string]interface{})
-	if err = mapstructure.Decode(decoded, &m); err != nil {
+	if tf.CreationDate != 0 {
+		mi.CreationDate = time.Unix(tf.CreationDate, 0)
+	}
+
+	if err := bencode.Unmarshal(tf.Info, &mi.Info); err != nil {
+		return nil, err
+	}
+
+	// unlike torrents obtained through the metadata exchange, torrent files
+	// must carry a piece layer for every v2 file spanning multiple pieces
+	if mi.IsV2() && mi.PieceLayers == nil {
+		mi.PieceLayers = make(map[string]string)
+	}
+
+	if err := mi.setInfoDict(tf.Info); err != nil {
 		return nil, err
 	}
 
-	infoHash := sha1.New()
-	if err = bencode.Marshal(infoHash, m["info"]); err != nil {
+	return mi, nil
+}
+
+// FromInfoDict builds a Metainfo from a bencoded info dictionary, such as one
+// obtained from peers with the metadata exchange, and tiers of tracker urls
+func FromInfoDict(infoDict []byte, tiers [][]string) (*Metainfo, error) {
+	mi := &Metainfo{}
+	if err := bencode.Unmarshal(infoDict, &mi.Info); err != nil {
 		return nil, err
 	}
 
-	mi.InfoHash = infoHash.Sum(nil)
-	if mi.Info.Files != nil {
-		mi.Multifile = true
-		for _, file := range mi.Info.Files {
-			mi.Info.Length += file.Length
+	tiers = nonEmptyTiers(tiers)
+	if len(tiers) > 0 {
+		mi.Announce = tiers[0][0]
+		if len(tiers) > 1 || len(tiers[0]) > 1 {
+			mi.AnnounceList = tiers
 		}
 	}
 
+	if err := mi.setInfoDict(infoDict); err != nil {
+		return nil, err
+	}
+
 	return mi, nil
 }
 
+// WriteTorrent writes a torrent file containing the bencoded info dictionary
+// as is, so the info hash is preserved, and tiers of tracker urls
+func WriteTorrent(w io.Writer, infoDict []byte, tiers [][]string) error {
+	tf := torrentFile{Info: infoDict}
+	tiers = nonEmptyTiers(tiers)
+	if len(tiers) > 0 {
+		tf.Announce = tiers[0][0]
+	}
+
+	if len(tiers) > 1 || (len(tiers) == 1 && len(tiers[0]) > 1) {
+		tf.AnnounceList = tiers
+	}
+
+	return bencode.NewEncoder(w).Encode(tf)
+}
+
+// setInfoDict computes the info hashes from the bencoded info dictionary and
+// the fields derived from the decoded info
+func (m *Metainfo) setInfoDict(infoDict []byte) error {
+	if m.IsV2() {
+		if err := m.setFilesV2(); err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(infoDict)
+		m.InfoHashV2 = hash[:]
+	} else if m.Info.MetaVersion != 0 {
+		return fmt.Errorf("%w: meta version %d", ErrUnsupportedProtocol, m.Info.MetaVersion)
+	}
+
+	if !m.IsV1() {
+		return nil
+	}
+
+	hash := sha1.Sum(infoDict)
+	m.InfoHash = hash[:]
+
+	if m.Info.Files != nil {
+		m.Multifile = true
+		for _, file := range m.Info.Files {
+			m.Info.Length += file.Length
+		}
+	}
+
+	return nil
+}
+
+// IsV1 reports whether the torrent can be downloaded from a v1 swarm
+func (m *Metainfo) IsV1() bool {
+	return m.Info.MetaVersion != 2 || len(m.Info.Pieces) > 0
+}
+
+// IsV2 reports whether the torrent can be downloaded from a v2 swarm, as described in BEP 52
+func (m *Metainfo) IsV2() bool {
+	return m.Info.MetaVersion == 2
+}
+
+// IsHybrid reports whether the torrent can be downloaded from both v1 and v2 swarms
+func (m *Metainfo) IsHybrid() bool {
+	return m.IsV1() && m.IsV2()
+}
+
+// InfoHashes returns the info hashes identifying the swarms of the torrent as
+// used in handshakes and tracker announces, the v2 info hash is truncated to 20 bytes
+func (m *Metainfo) InfoHashes() [][]byte {
+	var hashes [][]byte
+	if m.IsV1() {
+		hashes = append(hashes, m.InfoHash)
+	}
+
+	if m.IsV2() {
+		hashes = append(hashes, m.InfoHashV2[:20])
+	}
+
+	return hashes
+}
+
 // PieceHashes returns a hash slice of pieces in a file
 func (m *Metainfo) PieceHashes() [][]byte {
 	numPiece := len(m.Info.Pieces) / 20
@@ -82,3 +325,46 @@ func (m *Metainfo) PieceHashes() [][]byte {
 
 	return hashes
 }
+
+// AnnounceTiers returns the tracker urls grouped by tier. As described in
+// BEP 12, the announce-list takes precedence over the announce url when present
+func (m *Metainfo) AnnounceTiers() [][]string {
+	tiers := nonEmptyTiers(m.AnnounceList)
+	if len(tiers) == 0 && len(m.Announce) > 0 {
+		tiers = [][]string{{m.Announce}}
+	}
+
+	return tiers
+}
+
+// nonEmptyTiers returns the tiers without their empty urls, the tiers left
+// without urls are dropped
+func nonEmptyTiers(tiers [][]string) [][]string {
+	var nonEmpty [][]string
+	for _, tier := range tiers {
+		var urls []string
+		for _, u := range tier {
+			if len(u) > 0 {
+				urls = append(urls, u)
+			}
+		}
+
+		if len(urls) > 0 {
+			nonEmpty = append(nonEmpty, urls)
+		}
+	}
+
+	return nonEmpty
+}
+
+// WebSeeds returns the http urls of the url-list, as described in BEP 19
+func (m *Metainfo) WebSeeds() []string {
+	var seeds []string
+	for _, u := range m.URLList {
+		if strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://") {
+			seeds = append(seeds, u)
+		}
+	}
+
+	return seeds
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"crypto/rand"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
 	"github.com/kanowfy/btor/metainfo"
 )
 
@@ -59,6 +61,79 @@ func TestParse(t *testing.T) {
 			},
 			fails: false,
 		},
+		{
+			name:  "correctly parses metainfo with announce-list only",
+			input: []byte("d13:announce-listll28:udp://tracker.example.com:8029:http://backup.example.com/annel0:ee4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222ee"),
+			output: &metainfo.Metainfo{
+				AnnounceList: [][]string{{"udp://tracker.example.com:80", "http://backup.example.com/ann"}, {""}},
+				Info: metainfo.Info{
+					Length:      10000,
+					Name:        "test.txt",
+					PieceLength: 5000,
+					Pieces:      "1111111111111111111122222222222222222222",
+				},
+				InfoHash: []byte{84, 239, 11, 8, 172, 68, 174, 25, 34, 176, 23, 187, 185, 190, 201, 204, 180, 99, 219, 216},
+			},
+			fails: false,
+		},
+		{
+			name:  "correctly parses private metainfo",
+			input: []byte("d8:announce28:example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222227:privatei1eee"),
+			output: &metainfo.Metainfo{
+				Announce: "example.tracker.com/announce",
+				Info: metainfo.Info{
+					Length:      10000,
+					Name:        "test.txt",
+					PieceLength: 5000,
+					Pieces:      "1111111111111111111122222222222222222222",
+					Private:     true,
+				},
+				InfoHash: []byte{110, 239, 194, 104, 244, 43, 112, 203, 215, 127, 104, 250, 61, 141, 156, 36, 249, 98, 166, 136},
+			},
+			fails: false,
+		},
+		{
+			name:  "correctly parses optional fields and unknown keys",
+			input: []byte("d8:announce28:example.tracker.com/announce7:comment5:hello10:created by4:btor13:creation datei1700000000e8:encoding5:UTF-84:infod6:lengthi10000e6:md5sum32:0123456789abcdef0123456789abcdef4:name8:test.txt10:name.utf-88:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222226:source3:fooe9:publisher4:acmee"),
+			output: &metainfo.Metainfo{
+				Announce:     "example.tracker.com/announce",
+				Comment:      "hello",
+				CreatedBy:    "btor",
+				CreationDate: time.Unix(1700000000, 0),
+				Encoding:     "UTF-8",
+				Extra: map[string]bencode.RawMessage{
+					"publisher": bencode.RawMessage("4:acme"),
+				},
+				Info: metainfo.Info{
+					Length:      10000,
+					Name:        "test.txt",
+					PieceLength: 5000,
+					Pieces:      "1111111111111111111122222222222222222222",
+					Source:      "foo",
+					MD5Sum:      "0123456789abcdef0123456789abcdef",
+					Extra: map[string]bencode.RawMessage{
+						"name.utf-8": bencode.RawMessage("8:test.txt"),
+					},
+				},
+				InfoHash: []byte{50, 240, 235, 139, 202, 212, 52, 165, 121, 164, 250, 29, 134, 179, 196, 49, 220, 109, 245, 114},
+			},
+			fails: false,
+		},
+		{
+			name:  "info hash is computed over the info dictionary as is",
+			input: []byte("d8:announce28:example.tracker.com/announce4:infod4:name8:test.txt6:lengthi10000e12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222ee"),
+			output: &metainfo.Metainfo{
+				Announce: "example.tracker.com/announce",
+				Info: metainfo.Info{
+					Length:      10000,
+					Name:        "test.txt",
+					PieceLength: 5000,
+					Pieces:      "1111111111111111111122222222222222222222",
+				},
+				InfoHash: []byte{187, 174, 207, 86, 240, 45, 46, 186, 153, 129, 164, 143, 151, 176, 137, 110, 73, 62, 6, 102},
+			},
+			fails: false,
+		},
 		{
 			name:   "error on invalid bencode",
 			input:  []byte("foobar"),
@@ -72,8 +147,32 @@ func TestParse(t *testing.T) {
 			fails:  true,
 		},
 		{
-			name:   "error on missing announce field",
-			input:  []byte("d4:infod6:lengthi1000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222ee"),
+			name:  "correctly parses trackerless metainfo with dht nodes",
+			input: []byte("d4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e5:nodesll9:127.0.0.1i6881eel21:router.bittorrent.comi6881eeee"),
+			output: &metainfo.Metainfo{
+				Info: metainfo.Info{
+					Length:      10000,
+					Name:        "test.txt",
+					PieceLength: 5000,
+					Pieces:      "1111111111111111111122222222222222222222",
+				},
+				Nodes: []metainfo.Node{
+					{Host: "127.0.0.1", Port: 6881},
+					{Host: "router.bittorrent.com", Port: 6881},
+				},
+				InfoHash: []byte{84, 239, 11, 8, 172, 68, 174, 25, 34, 176, 23, 187, 185, 190, 201, 204, 180, 99, 219, 216},
+			},
+			fails: false,
+		},
+		{
+			name:   "error on private metainfo without trackers",
+			input:  []byte("d4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:11111111111111111111222222222222222222227:privatei1eee"),
+			output: nil,
+			fails:  true,
+		},
+		{
+			name:   "error on invalid dht node",
+			input:  []byte("d4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e5:nodesll9:127.0.0.1eee"),
 			output: nil,
 			fails:  true,
 		},
@@ -127,3 +226,142 @@ func TestPieceHashes(t *testing.T) {
 		t.Error(cmp.Diff(want, got))
 	}
 }
+
+func TestAnnounceTiers(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  *metainfo.Metainfo
+		output [][]string
+	}{
+		{
+			name: "announce only",
+			input: &metainfo.Metainfo{
+				Announce: "http://a.example.com/announce",
+			},
+			output: [][]string{{"http://a.example.com/announce"}},
+		},
+		{
+			name: "announce-list takes precedence over announce",
+			input: &metainfo.Metainfo{
+				Announce:     "http://a.example.com/announce",
+				AnnounceList: [][]string{{"udp://b.example.com:80", "udp://c.example.com:80"}, {"http://d.example.com/announce"}},
+			},
+			output: [][]string{{"udp://b.example.com:80", "udp://c.example.com:80"}, {"http://d.example.com/announce"}},
+		},
+		{
+			name: "empty urls and tiers are dropped",
+			input: &metainfo.Metainfo{
+				AnnounceList: [][]string{{""}, {"udp://b.example.com:80", ""}, {}},
+			},
+			output: [][]string{{"udp://b.example.com:80"}},
+		},
+		{
+			name: "empty announce-list falls back to announce",
+			input: &metainfo.Metainfo{
+				Announce:     "http://a.example.com/announce",
+				AnnounceList: [][]string{{""}},
+			},
+			output: [][]string{{"http://a.example.com/announce"}},
+		},
+		{
+			name:   "no trackers",
+			input:  &metainfo.Metainfo{},
+			output: nil,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.input.AnnounceTiers()
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestFromInfoDict(t *testing.T) {
+	t.Parallel()
+
+	infoDict := []byte("d5:filesld6:lengthi1000e4:pathl3:foo7:bar.pngeed6:lengthi3000e4:pathl3:foo7:baz.jpgeee4:name4:test12:piece lengthi5000e6:pieces40:1111111111111111111122222222222222222222e")
+	tiers := [][]string{{"http://a.example.com/announce", "udp://b.example.com:80"}}
+
+	want := &metainfo.Metainfo{
+		Announce:     "http://a.example.com/announce",
+		AnnounceList: tiers,
+		Info: metainfo.Info{
+			Length: 4000,
+			Files: []metainfo.FileEntry{
+				{
+					Length: 1000,
+					Path:   []string{"foo", "bar.png"},
+				},
+				{
+					Length: 3000,
+					Path:   []string{"foo", "baz.jpg"},
+				},
+			},
+			Name:        "test",
+			PieceLength: 5000,
+			Pieces:      "1111111111111111111122222222222222222222",
+		},
+		InfoHash:  []byte{19, 85, 72, 65, 98, 91, 234, 87, 65, 183, 110, 221, 118, 79, 78, 161, 144, 30, 178, 227},
+		Multifile: true,
+	}
+
+	got, err := metainfo.FromInfoDict(infoDict, tiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	// the written torrent parses back into the same metainfo
+	var buf bytes.Buffer
+	if err := metainfo.WriteTorrent(&buf, infoDict, tiers); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := metainfo.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(want, parsed) {
+		t.Error(cmp.Diff(want, parsed))
+	}
+}
+
+func TestFromInfoDict_EmptyTiers(t *testing.T) {
+	t.Parallel()
+
+	infoDict := []byte("d6:lengthi1000e4:name8:test.txt12:piece lengthi16384e6:pieces20:11111111111111111111e")
+	tiers := [][]string{{}, {""}, {"http://a.example.com/announce"}}
+
+	got, err := metainfo.FromInfoDict(infoDict, tiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Announce != "http://a.example.com/announce" || got.AnnounceList != nil {
+		t.Errorf("want the tracker of the only non-empty tier, got %q and %v", got.Announce, got.AnnounceList)
+	}
+
+	var buf bytes.Buffer
+	if err := metainfo.WriteTorrent(&buf, infoDict, [][]string{{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := metainfo.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parsed.AnnounceTiers()) != 0 {
+		t.Errorf("want no trackers, got %v", parsed.AnnounceTiers())
+	}
+}
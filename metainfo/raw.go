@@ -0,0 +1,158 @@
+package metainfo
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strconv"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// RawDict is a bencoded dictionary whose values are kept in their original
+// encoding, so that rewriting it leaves the untouched keys byte for byte identical
+type RawDict struct {
+	fields []rawField
+	// raw is the original encoding, reused until a key is modified
+	raw []byte
+}
+
+type rawField struct {
+	key   string
+	value []byte
+}
+
+// ParseRawDict splits a bencoded dictionary into its keys and raw values
+func ParseRawDict(data []byte) (*RawDict, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, &bencode.SyntaxError{Offset: 0, Msg: "not a dictionary"}
+	}
+
+	d := &RawDict{}
+	dec := bencode.NewDecoder(bytes.NewReader(data[1:]))
+	for {
+		pos := 1 + int(dec.InputOffset())
+		if pos >= len(data) {
+			return nil, &bencode.SyntaxError{Offset: int64(pos), Msg: "unterminated dictionary", Err: io.ErrUnexpectedEOF}
+		}
+
+		if data[pos] == 'e' {
+			if pos != len(data)-1 {
+				return nil, &bencode.SyntaxError{Offset: int64(pos + 1), Msg: "trailing data after value"}
+			}
+			break
+		}
+
+		var (
+			key   string
+			value bencode.RawMessage
+		)
+		if err := dec.Decode(&key); err != nil {
+			return nil, err
+		}
+
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		d.fields = append(d.fields, rawField{key, value})
+	}
+
+	d.raw = data
+	return d, nil
+}
+
+// Keys returns the keys in their encoded order
+func (d *RawDict) Keys() []string {
+	keys := make([]string, len(d.fields))
+	for i, f := range d.fields {
+		keys[i] = f.key
+	}
+
+	return keys
+}
+
+// Get returns the raw encoding of the value of a key
+func (d *RawDict) Get(key string) ([]byte, bool) {
+	i := d.index(key)
+	if i < 0 {
+		return nil, false
+	}
+
+	return d.fields[i].value, true
+}
+
+// Set bencodes the value and stores it under the key, new keys are inserted
+// in sorted order
+func (d *RawDict) Set(key string, value interface{}) error {
+	data, err := bencode.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return d.SetRaw(key, data)
+}
+
+// SetRaw stores an already bencoded value under the key
+func (d *RawDict) SetRaw(key string, value []byte) error {
+	if err := bencode.Unmarshal(value, &bencode.RawMessage{}); err != nil {
+		return err
+	}
+
+	if i := d.index(key); i >= 0 {
+		if !bytes.Equal(d.fields[i].value, value) {
+			d.fields[i].value = value
+			d.raw = nil
+		}
+		return nil
+	}
+
+	i := slices.IndexFunc(d.fields, func(f rawField) bool {
+		return f.key > key
+	})
+	if i < 0 {
+		i = len(d.fields)
+	}
+
+	d.fields = slices.Insert(d.fields, i, rawField{key, value})
+	d.raw = nil
+	return nil
+}
+
+// Delete removes a key, it does nothing if the key is not present
+func (d *RawDict) Delete(key string) {
+	if i := d.index(key); i >= 0 {
+		d.fields = slices.Delete(d.fields, i, i+1)
+		d.raw = nil
+	}
+}
+
+// Modified reports whether the dictionary differs from the parsed one
+func (d *RawDict) Modified() bool {
+	return d.raw == nil
+}
+
+// Bytes returns the bencoded dictionary
+func (d *RawDict) Bytes() []byte {
+	if d.raw != nil {
+		return d.raw
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('d')
+	for _, f := range d.fields {
+		buf.WriteString(strconv.Itoa(len(f.key)))
+		buf.WriteByte(':')
+		buf.WriteString(f.key)
+		buf.Write(f.value)
+	}
+	buf.WriteByte('e')
+
+	return buf.Bytes()
+}
+
+func (d *RawDict) index(key string) int {
+	return slices.IndexFunc(d.fields, func(f rawField) bool {
+		return f.key == key
+	})
+}
@@ -0,0 +1,317 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+// BlockLength is the size of the leaf blocks of v2 merkle trees
+const BlockLength = 16384 // 2^14
+
+// FileV2 is a file of a v2 file tree
+type FileV2 struct {
+	Path       []string
+	Length     int
+	PiecesRoot []byte
+	Attr       Attr
+	// SymlinkPath is the target of a symbolic link relative to the torrent root directory
+	SymlinkPath []string
+	// Offset is the position of the file in the torrent content, every file
+	// of a v2 torrent starts at a piece boundary
+	Offset int
+}
+
+type fileTreeEntry struct {
+	Length      int      `bencode:"length"`
+	PiecesRoot  string   `bencode:"pieces root"`
+	Attr        Attr     `bencode:"attr"`
+	SymlinkPath []string `bencode:"symlink path"`
+}
+
+// setFilesV2 flattens the file tree and validates the piece layers against the pieces roots
+func (m *Metainfo) setFilesV2() error {
+	pieceLength := m.Info.PieceLength
+	if pieceLength < BlockLength || pieceLength&(pieceLength-1) != 0 {
+		return fmt.Errorf("v2 piece length must be a power of two of at least %d, got %d", BlockLength, pieceLength)
+	}
+
+	if len(m.Info.FileTree) == 0 {
+		return fmt.Errorf("missing file tree")
+	}
+
+	var files []FileV2
+	if err := walkFileTree(m.Info.FileTree, nil, &files); err != nil {
+		return err
+	}
+
+	var offset, length int
+	for i := range files {
+		f := &files[i]
+		f.Offset = offset
+		offset += (f.Length + pieceLength - 1) / pieceLength * pieceLength
+		length += f.Length
+
+		if f.Length == 0 {
+			continue
+		}
+
+		if len(f.PiecesRoot) != sha256.Size {
+			return fmt.Errorf("invalid pieces root for file %v", f.Path)
+		}
+
+		if f.Length <= pieceLength {
+			continue
+		}
+
+		layer, ok := m.PieceLayers[string(f.PiecesRoot)]
+		if !ok {
+			// the metadata exchange does not carry the piece layers, hybrid
+			// torrents verify these pieces with their v1 hashes instead
+			if m.PieceLayers == nil {
+				if m.IsV1() {
+					continue
+				}
+				return fmt.Errorf("%w: file %v spans more than one piece", ErrMissingPieceLayers, f.Path)
+			}
+			return fmt.Errorf("missing piece layer for file %v", f.Path)
+		}
+
+		numPieces := (f.Length + pieceLength - 1) / pieceLength
+		if len(layer) != numPieces*sha256.Size {
+			return fmt.Errorf("invalid piece layer length for file %v, want %d, got %d", f.Path, numPieces*sha256.Size, len(layer))
+		}
+
+		hashes := make([][]byte, numPieces)
+		for j := range hashes {
+			hashes[j] = []byte(layer[j*sha256.Size : (j+1)*sha256.Size])
+		}
+
+		pad := merkleRoot(make([][]byte, pieceLength/BlockLength))
+		if root := merkleRoot(padLeaves(hashes, pad)); !bytes.Equal(root, f.PiecesRoot) {
+			return fmt.Errorf("piece layer does not match pieces root for file %v", f.Path)
+		}
+	}
+
+	m.FilesV2 = files
+
+	// the v1 fields take precedence for hybrid torrents
+	if !m.IsV1() {
+		m.Info.Length = length
+		m.Multifile = len(files) != 1 || !slices.Equal(files[0].Path, []string{m.Info.Name})
+	}
+
+	return nil
+}
+
+func walkFileTree(tree map[string]interface{}, path []string, files *[]FileV2) error {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		node, ok := tree[k].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid file tree node %q", k)
+		}
+
+		nodePath := append(slices.Clone(path), k)
+
+		props, isFile := node[""]
+		if !isFile {
+			if err := walkFileTree(node, nodePath, files); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// the file tree is kept decoded so it can be written back, the
+		// properties are encoded again to decode them into their fields
+		data, err := bencode.Marshal(props)
+		if err != nil {
+			return fmt.Errorf("invalid file tree entry %v: %w", nodePath, err)
+		}
+
+		var entry fileTreeEntry
+		if err = bencode.Unmarshal(data, &entry); err != nil {
+			return fmt.Errorf("invalid file tree entry %v: %w", nodePath, err)
+		}
+
+		*files = append(*files, FileV2{
+			Path:        nodePath,
+			Length:      entry.Length,
+			PiecesRoot:  []byte(entry.PiecesRoot),
+			Attr:        entry.Attr,
+			SymlinkPath: entry.SymlinkPath,
+		})
+	}
+
+	return nil
+}
+
+// Piece describes a piece of the torrent content and the hashes used to verify it
+type Piece struct {
+	Index int
+	// Offset is the position of the piece in the torrent content
+	Offset int
+	Length int
+	// Hash is the SHA-1 hash of the piece, set for v1 and hybrid torrents
+	Hash []byte
+	// Root is the root of the merkle tree over the piece blocks, set for v2
+	// and hybrid torrents
+	Root []byte
+	// RootLength is the number of bytes of the piece covered by Root, the
+	// remaining bytes are padding
+	RootLength int
+	// Leaves is the number of blocks in the merkle tree of Root
+	Leaves int
+}
+
+// Pieces returns the pieces of the torrent content
+func (m *Metainfo) Pieces() []Piece {
+	var pieces []Piece
+	if m.IsV2() {
+		pieces = m.piecesV2()
+	}
+
+	if !m.IsV1() {
+		return pieces
+	}
+
+	// pad files in hybrid torrents align the v1 pieces with the v2 ones
+	hashes := m.PieceHashes()
+	for i, hash := range hashes {
+		offset := i * m.Info.PieceLength
+		if i >= len(pieces) {
+			pieces = append(pieces, Piece{Index: i})
+		}
+
+		pieces[i].Offset = offset
+		pieces[i].Length = min(m.Info.PieceLength, m.Info.Length-offset)
+		pieces[i].Hash = hash
+	}
+
+	return pieces
+}
+
+func (m *Metainfo) piecesV2() []Piece {
+	var pieces []Piece
+	pieceLength := m.Info.PieceLength
+	for _, f := range m.FilesV2 {
+		numPieces := (f.Length + pieceLength - 1) / pieceLength
+		layer := m.PieceLayers[string(f.PiecesRoot)]
+
+		for j := range numPieces {
+			p := Piece{
+				Index:      len(pieces),
+				Offset:     f.Offset + j*pieceLength,
+				Length:     min(pieceLength, f.Length-j*pieceLength),
+				RootLength: min(pieceLength, f.Length-j*pieceLength),
+			}
+
+			if numPieces == 1 {
+				// the pieces root covers the whole file
+				p.Root = f.PiecesRoot
+				p.Leaves = nextPowerOfTwo((f.Length + BlockLength - 1) / BlockLength)
+			} else if len(layer) > 0 {
+				p.Root = []byte(layer[j*sha256.Size : (j+1)*sha256.Size])
+				p.Leaves = pieceLength / BlockLength
+			}
+
+			pieces = append(pieces, p)
+		}
+	}
+
+	return pieces
+}
+
+// Verify checks the piece data against every hash known for the piece
+func (p Piece) Verify(data []byte) error {
+	if p.Hash == nil && p.Root == nil {
+		return fmt.Errorf("no hash to verify piece %d", p.Index)
+	}
+
+	if p.Hash != nil {
+		checksum := sha1.Sum(data)
+		if !bytes.Equal(checksum[:], p.Hash) {
+			return fmt.Errorf("mismatch piece hash, want %x, got %x", p.Hash, checksum)
+		}
+	}
+
+	if p.Root != nil {
+		if len(data) < p.RootLength {
+			return fmt.Errorf("piece too short, want at least %d bytes, got %d", p.RootLength, len(data))
+		}
+
+		data = data[:p.RootLength]
+		leaves := make([][]byte, 0, p.Leaves)
+		for start := 0; start < len(data); start += BlockLength {
+			block := sha256.Sum256(data[start:min(start+BlockLength, len(data))])
+			leaves = append(leaves, block[:])
+		}
+
+		if len(leaves) > p.Leaves {
+			return fmt.Errorf("piece spans %d blocks, want at most %d", len(leaves), p.Leaves)
+		}
+
+		for len(leaves) < p.Leaves {
+			leaves = append(leaves, make([]byte, sha256.Size))
+		}
+
+		if root := merkleRoot(leaves); !bytes.Equal(root, p.Root) {
+			return fmt.Errorf("mismatch piece merkle root, want %x, got %x", p.Root, root)
+		}
+	}
+
+	return nil
+}
+
+// merkleRoot computes the root of a merkle tree from a power of two number of
+// leaf hashes, nil leaves are treated as zero hashes
+func merkleRoot(leaves [][]byte) []byte {
+	layer := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		if leaf == nil {
+			leaf = make([]byte, sha256.Size)
+		}
+		layer[i] = leaf
+	}
+
+	for len(layer) > 1 {
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			h := sha256.New()
+			h.Write(layer[2*i])
+			h.Write(layer[2*i+1])
+			next[i] = h.Sum(nil)
+		}
+		layer = next
+	}
+
+	return layer[0]
+}
+
+// padLeaves pads hashes with pad up to the next power of two
+func padLeaves(hashes [][]byte, pad []byte) [][]byte {
+	padded := slices.Clone(hashes)
+	for len(padded) < nextPowerOfTwo(len(hashes)) {
+		padded = append(padded, pad)
+	}
+
+	return padded
+}
+
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+
+	return p
+}
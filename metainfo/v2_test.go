@@ -0,0 +1,353 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+const testPieceLength = 32768
+
+// merkle computes the root over 16KiB blocks of data padded with zero hashes to width leaves
+func merkle(data []byte, width int) []byte {
+	var layer [][]byte
+	for start := 0; start < len(data); start += metainfo.BlockLength {
+		h := sha256.Sum256(data[start:min(start+metainfo.BlockLength, len(data))])
+		layer = append(layer, h[:])
+	}
+	for len(layer) < width {
+		layer = append(layer, make([]byte, 32))
+	}
+	return reduce(layer)
+}
+
+func reduce(layer [][]byte) []byte {
+	for len(layer) > 1 {
+		var next [][]byte
+		for i := 0; i < len(layer); i += 2 {
+			h := sha256.Sum256(append(append([]byte(nil), layer[i]...), layer[i+1]...))
+			next = append(next, h[:])
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+// testContent returns the content of a two file torrent, the first file
+// spans two pieces and the second fits in a single block
+func testContent() (a, b []byte) {
+	a = make([]byte, 40000)
+	for i := range a {
+		a[i] = byte(i % 251)
+	}
+	b = bytes.Repeat([]byte("b"), 100)
+	return a, b
+}
+
+// buildTorrent bencodes a v2 torrent of testContent, optionally with the v1
+// fields of a hybrid torrent
+func buildTorrent(t *testing.T, hybrid bool, mutate func(torrent, info map[string]interface{})) ([]byte, []byte) {
+	t.Helper()
+
+	a, b := testContent()
+
+	aLayer := append(merkle(a[:testPieceLength], 2), merkle(a[testPieceLength:], 2)...)
+	aRoot := reduce([][]byte{aLayer[:32], aLayer[32:]})
+	bRoot := merkle(b, 1)
+
+	info := map[string]interface{}{
+		"name":         "test",
+		"piece length": testPieceLength,
+		"meta version": 2,
+		"file tree": map[string]interface{}{
+			"a": map[string]interface{}{
+				"a.bin": map[string]interface{}{
+					"": map[string]interface{}{"length": len(a), "pieces root": string(aRoot)},
+				},
+			},
+			"b.txt": map[string]interface{}{
+				"": map[string]interface{}{"length": len(b), "pieces root": string(bRoot)},
+			},
+		},
+	}
+
+	if hybrid {
+		padLen := 2*testPieceLength - len(a)
+		content := append(append(append([]byte(nil), a...), make([]byte, padLen)...), b...)
+		var pieces []byte
+		for start := 0; start < len(content); start += testPieceLength {
+			h := sha1.Sum(content[start:min(start+testPieceLength, len(content))])
+			pieces = append(pieces, h[:]...)
+		}
+
+		info["pieces"] = string(pieces)
+		info["files"] = []interface{}{
+			map[string]interface{}{"length": len(a), "path": []interface{}{"a", "a.bin"}},
+			map[string]interface{}{"length": padLen, "path": []interface{}{".pad", "25536"}, "attr": "p"},
+			map[string]interface{}{"length": len(b), "path": []interface{}{"b.txt"}},
+		}
+	}
+
+	torrent := map[string]interface{}{
+		"announce": "http://tracker.example.com/announce",
+		"info":     info,
+		"piece layers": map[string]interface{}{
+			string(aRoot): string(aLayer),
+		},
+	}
+
+	if mutate != nil {
+		mutate(torrent, info)
+	}
+
+	infoDict, err := bencode.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bencode.Marshal(torrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data, infoDict
+}
+
+func TestParse_V2(t *testing.T) {
+	t.Parallel()
+
+	for _, hybrid := range []bool{false, true} {
+		torrent, info := buildTorrent(t, hybrid, nil)
+
+		m, err := metainfo.Parse(bytes.NewReader(torrent))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !m.IsV2() || m.IsV1() != hybrid || m.IsHybrid() != hybrid {
+			t.Fatalf("unexpected versions, v1: %v, v2: %v", m.IsV1(), m.IsV2())
+		}
+
+		infoHashV2 := sha256.Sum256(info)
+		if !cmp.Equal(m.InfoHashV2, infoHashV2[:]) {
+			t.Errorf("want v2 info hash %x, got %x", infoHashV2, m.InfoHashV2)
+		}
+
+		wantHashes := [][]byte{infoHashV2[:20]}
+		if hybrid {
+			infoHash := sha1.Sum(info)
+			wantHashes = [][]byte{infoHash[:], infoHashV2[:20]}
+		}
+		if !cmp.Equal(wantHashes, m.InfoHashes()) {
+			t.Error(cmp.Diff(wantHashes, m.InfoHashes()))
+		}
+
+		gotPaths := [][]string{m.FilesV2[0].Path, m.FilesV2[1].Path}
+		if !cmp.Equal([][]string{{"a", "a.bin"}, {"b.txt"}}, gotPaths) {
+			t.Errorf("unexpected file tree paths: %v", gotPaths)
+		}
+
+		if m.FilesV2[1].Offset != 2*testPieceLength {
+			t.Errorf("want second file aligned at %d, got %d", 2*testPieceLength, m.FilesV2[1].Offset)
+		}
+
+		if !m.Multifile {
+			t.Error("expected multifile torrent")
+		}
+	}
+}
+
+func TestPieces_V2(t *testing.T) {
+	t.Parallel()
+
+	a, b := testContent()
+
+	for _, hybrid := range []bool{false, true} {
+		torrent, _ := buildTorrent(t, hybrid, nil)
+		m, err := metainfo.Parse(bytes.NewReader(torrent))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pieces := m.Pieces()
+		if len(pieces) != 3 {
+			t.Fatalf("want 3 pieces, got %d", len(pieces))
+		}
+
+		lastPiece := a[testPieceLength:]
+		if hybrid {
+			// the v1 piece includes the pad file
+			lastPiece = append(append([]byte(nil), lastPiece...), make([]byte, 2*testPieceLength-len(a))...)
+		}
+
+		data := [][]byte{a[:testPieceLength], lastPiece, b}
+		offsets := []int{0, testPieceLength, 2 * testPieceLength}
+		for i, p := range pieces {
+			if p.Offset != offsets[i] || p.Length != len(data[i]) {
+				t.Errorf("piece %d: want offset %d length %d, got offset %d length %d", i, offsets[i], len(data[i]), p.Offset, p.Length)
+			}
+
+			if p.Root == nil || (p.Hash != nil) != hybrid {
+				t.Errorf("piece %d: unexpected hashes, root: %x, hash: %x", i, p.Root, p.Hash)
+			}
+
+			if err := p.Verify(data[i]); err != nil {
+				t.Errorf("piece %d: %v", i, err)
+			}
+
+			corrupt := append([]byte(nil), data[i]...)
+			corrupt[0] ^= 0xff
+			if err := p.Verify(corrupt); err == nil {
+				t.Errorf("piece %d: expected error on corrupt data, got nil", i)
+			}
+		}
+	}
+}
+
+func TestParse_V2Errors(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		mutate func(torrent, info map[string]interface{})
+	}{
+		{
+			name: "error on missing piece layers",
+			mutate: func(torrent, info map[string]interface{}) {
+				delete(torrent, "piece layers")
+			},
+		},
+		{
+			name: "error on piece layer not matching pieces root",
+			mutate: func(torrent, info map[string]interface{}) {
+				for root, layer := range torrent["piece layers"].(map[string]interface{}) {
+					torrent["piece layers"].(map[string]interface{})[root] = string(bytes.Repeat([]byte{1}, len(layer.(string))))
+				}
+			},
+		},
+		{
+			name: "error on piece length not power of two",
+			mutate: func(torrent, info map[string]interface{}) {
+				info["piece length"] = 30000
+			},
+		},
+		{
+			name: "error on unsupported meta version",
+			mutate: func(torrent, info map[string]interface{}) {
+				info["meta version"] = 3
+			},
+		},
+		{
+			name: "error on invalid file tree node",
+			mutate: func(torrent, info map[string]interface{}) {
+				info["file tree"].(map[string]interface{})["c"] = "not a dictionary"
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			torrent, _ := buildTorrent(t, false, tc.mutate)
+			if _, err := metainfo.Parse(bytes.NewReader(torrent)); err == nil {
+				t.Fatal("expect error, got nil")
+			}
+		})
+	}
+}
+
+func TestPieceVerify(t *testing.T) {
+	t.Parallel()
+
+	data := []byte("hello world")
+	hash := sha1.Sum(data)
+
+	cases := []struct {
+		name  string
+		piece metainfo.Piece
+		data  []byte
+		fails bool
+	}{
+		{
+			name:  "matching sha1 hash",
+			piece: metainfo.Piece{Hash: hash[:], Length: len(data)},
+			data:  data,
+			fails: false,
+		},
+		{
+			name:  "mismatch sha1 hash",
+			piece: metainfo.Piece{Hash: hash[:], Length: len(data)},
+			data:  []byte("hello there"),
+			fails: true,
+		},
+		{
+			name:  "matching merkle root",
+			piece: metainfo.Piece{Root: merkle(data, 4), RootLength: len(data), Leaves: 4},
+			data:  data,
+			fails: false,
+		},
+		{
+			name:  "error on data shorter than root length",
+			piece: metainfo.Piece{Root: merkle(data, 4), RootLength: len(data), Leaves: 4},
+			data:  data[:5],
+			fails: true,
+		},
+		{
+			name:  "error without hashes",
+			piece: metainfo.Piece{Length: len(data)},
+			data:  data,
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.piece.Verify(tc.data)
+			if tc.fails && err == nil {
+				t.Fatal("expect error, got nil")
+			}
+			if !tc.fails && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestFromInfoDict_V2(t *testing.T) {
+	t.Parallel()
+
+	// the info dictionary fetched with the metadata exchange has no piece layers
+	_, info := buildTorrent(t, false, nil)
+	if _, err := metainfo.FromInfoDict(info, nil); !errors.Is(err, metainfo.ErrMissingPieceLayers) {
+		t.Errorf("want %v, got %v", metainfo.ErrMissingPieceLayers, err)
+	}
+
+	_, info = buildTorrent(t, true, nil)
+	m, err := metainfo.FromInfoDict(info, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := testContent()
+	content := append(append(append([]byte(nil), a...), make([]byte, 2*testPieceLength-len(a))...), b...)
+
+	pieces := m.Pieces()
+	if len(pieces) != 3 {
+		t.Fatalf("want 3 pieces, got %d", len(pieces))
+	}
+
+	// hybrid pieces without a piece layer are verified with their v1 hash
+	for i, p := range pieces {
+		if p.Hash == nil {
+			t.Errorf("piece %d: want a v1 hash", i)
+		}
+
+		if err := p.Verify(content[p.Offset : p.Offset+p.Length]); err != nil {
+			t.Errorf("piece %d: %v", i, err)
+		}
+	}
+}
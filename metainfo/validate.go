@@ -0,0 +1,570 @@
+package metainfo
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+type Severity int
+
+const (
+	// SeverityWarning problems are tolerated by clients but likely unintended
+	SeverityWarning Severity = iota
+	// SeverityError problems make the torrent unusable or ambiguous
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem is an inconsistency found in a torrent file
+type Problem struct {
+	Severity Severity
+	// Field is the path to the offending key, such as info.files[2].path
+	Field   string
+	Message string
+}
+
+func (p Problem) String() string {
+	if len(p.Field) == 0 {
+		return fmt.Sprintf("%s: %s", p.Severity, p.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Field, p.Message)
+}
+
+// HasErrors reports whether any of the problems is an error
+func HasErrors(problems []Problem) bool {
+	return slices.ContainsFunc(problems, func(p Problem) bool {
+		return p.Severity == SeverityError
+	})
+}
+
+// Validate reads a torrent file and reports every problem found in it. Unlike
+// Parse, it does not stop at the first problem and also reports suspicious
+// values that Parse accepts. The error is only set when the stream is not valid bencode
+func Validate(r io.Reader) ([]Problem, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err = bencode.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	v := &validator{}
+	v.validateTorrent(decoded)
+
+	// the structure is sound, let Parse check the rest such as the v2 piece layers
+	if !HasErrors(v.problems) {
+		if _, err := parseTorrent(data); err != nil {
+			v.errorf("", "%v", err)
+		}
+	}
+
+	return v.problems, nil
+}
+
+// ValidateInfo reports every problem found in an info dictionary, such as one
+// fetched from peers for a magnet link
+func ValidateInfo(infoDict []byte) ([]Problem, error) {
+	var decoded interface{}
+	if err := bencode.Unmarshal(infoDict, &decoded); err != nil {
+		return nil, err
+	}
+
+	v := &validator{}
+	if info, ok := decoded.(map[string]interface{}); ok {
+		v.validateInfo(info)
+	} else {
+		v.errorf("info", "must be a dictionary")
+	}
+
+	return v.problems, nil
+}
+
+// ValidatePath reports the path components of a content file which would
+// escape or collide with the download directory
+func ValidatePath(field string, path []string) []Problem {
+	v := &validator{}
+	if len(path) == 0 {
+		v.errorf(field, "empty path")
+	}
+
+	for i, name := range path {
+		v.validatePathComponent(fmt.Sprintf("%s[%d]", field, i), name)
+	}
+
+	return v.problems
+}
+
+type validator struct {
+	problems []Problem
+}
+
+func (v *validator) errorf(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{SeverityError, field, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(field, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{SeverityWarning, field, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validateTorrent(decoded interface{}) {
+	torrent, ok := decoded.(map[string]interface{})
+	if !ok {
+		v.errorf("", "torrent is not a dictionary")
+		return
+	}
+
+	v.validateTrackers(torrent)
+
+	if urls, ok := torrent["url-list"]; ok {
+		v.validateURLList(urls)
+	}
+
+	if nodes, ok := torrent["nodes"]; ok {
+		v.validateNodes(nodes)
+	}
+
+	if date, ok := torrent["creation date"]; ok {
+		if d, ok := date.(int64); !ok {
+			v.errorf("creation date", "must be an integer")
+		} else if d <= 0 || time.Unix(d, 0).After(time.Now()) {
+			v.warnf("creation date", "%s is not a plausible creation date", time.Unix(d, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	for _, key := range []string{"comment", "created by", "encoding"} {
+		if value, ok := torrent[key]; ok {
+			if _, ok := value.(string); !ok {
+				v.errorf(key, "must be a string")
+			}
+		}
+	}
+
+	info, ok := torrent["info"]
+	if !ok {
+		v.errorf("info", "missing info dictionary")
+		return
+	}
+
+	infoDict, ok := info.(map[string]interface{})
+	if !ok {
+		v.errorf("info", "must be a dictionary")
+		return
+	}
+
+	v.validateInfo(infoDict)
+}
+
+func (v *validator) validateTrackers(torrent map[string]interface{}) {
+	var numTrackers int
+
+	if announce, ok := torrent["announce"]; ok {
+		if u, ok := announce.(string); !ok {
+			v.errorf("announce", "must be a string")
+		} else {
+			v.validateTrackerURL("announce", u)
+			numTrackers++
+		}
+	}
+
+	if announceList, ok := torrent["announce-list"]; ok {
+		tiers, ok := announceList.([]interface{})
+		if !ok {
+			v.errorf("announce-list", "must be a list of tiers")
+			return
+		}
+
+		for i, tier := range tiers {
+			field := fmt.Sprintf("announce-list[%d]", i)
+			urls, ok := tier.([]interface{})
+			if !ok {
+				v.errorf(field, "must be a list of urls")
+				continue
+			}
+
+			if len(urls) == 0 {
+				v.warnf(field, "empty tier")
+			}
+
+			for j, u := range urls {
+				field := fmt.Sprintf("%s[%d]", field, j)
+				if s, ok := u.(string); !ok {
+					v.errorf(field, "must be a string")
+				} else {
+					v.validateTrackerURL(field, s)
+					numTrackers++
+				}
+			}
+		}
+	}
+
+	info, _ := torrent["info"].(map[string]interface{})
+	private, _ := info["private"].(int64)
+	if _, ok := torrent["nodes"]; numTrackers == 0 && private == 1 {
+		v.errorf("announce", "private torrent without trackers, its peers cannot be found")
+	} else if numTrackers == 0 && !ok {
+		v.warnf("announce", "no trackers, peers can only be found through other means")
+	}
+}
+
+func (v *validator) validateTrackerURL(field, trackerURL string) {
+	if len(trackerURL) == 0 {
+		v.warnf(field, "empty tracker url")
+		return
+	}
+
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		v.warnf(field, "invalid tracker url %q", trackerURL)
+		return
+	}
+
+	switch u.Scheme {
+	case "http", "https", "udp":
+	default:
+		v.warnf(field, "unsupported tracker url %q", trackerURL)
+	}
+}
+
+func (v *validator) validateNodes(nodes interface{}) {
+	list, ok := nodes.([]interface{})
+	if !ok {
+		v.errorf("nodes", "must be a list of nodes")
+		return
+	}
+
+	for i, n := range list {
+		field := fmt.Sprintf("nodes[%d]", i)
+		node, ok := n.([]interface{})
+		if !ok || len(node) != 2 {
+			v.errorf(field, "must be a list of a host and a port")
+			continue
+		}
+
+		if host, ok := node[0].(string); !ok || len(host) == 0 {
+			v.errorf(field, "host must be a non-empty string")
+		}
+
+		if port, ok := node[1].(int64); !ok || port <= 0 || port > 65535 {
+			v.errorf(field, "port must be an integer between 1 and 65535")
+		}
+	}
+}
+
+func (v *validator) validateURLList(urls interface{}) {
+	var list []interface{}
+	switch u := urls.(type) {
+	case string:
+		// a single url may be given as a string
+		if len(u) > 0 {
+			list = []interface{}{u}
+		}
+	case []interface{}:
+		list = u
+	default:
+		v.errorf("url-list", "must be a url or a list of urls")
+		return
+	}
+
+	for i, u := range list {
+		field := fmt.Sprintf("url-list[%d]", i)
+		s, ok := u.(string)
+		if !ok {
+			v.errorf(field, "must be a string")
+			continue
+		}
+
+		if parsed, err := url.Parse(s); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			v.warnf(field, "unsupported web seed url %q", s)
+		}
+	}
+}
+
+func (v *validator) validateInfo(info map[string]interface{}) {
+	if name, ok := info["name"].(string); !ok {
+		v.errorf("info.name", "missing name")
+	} else {
+		v.validatePathComponent("info.name", name)
+	}
+
+	pieceLength, ok := info["piece length"].(int64)
+	if !ok || pieceLength <= 0 {
+		v.errorf("info.piece length", "must be a positive integer")
+		pieceLength = 0
+	} else if pieceLength&(pieceLength-1) != 0 {
+		v.warnf("info.piece length", "%d is not a power of two", pieceLength)
+	} else if pieceLength < MinPieceLength {
+		v.warnf("info.piece length", "%d is smaller than %d", pieceLength, MinPieceLength)
+	}
+
+	if attr, ok := info["attr"]; ok {
+		v.validateAttr("info.attr", attr)
+	}
+
+	if source, ok := info["source"]; ok {
+		if _, ok := source.(string); !ok {
+			v.errorf("info.source", "must be a string")
+		}
+	}
+
+	if md5sum, ok := info["md5sum"]; ok {
+		v.validateMD5Sum("info.md5sum", md5sum)
+	}
+
+	if private, ok := info["private"]; ok {
+		if p, ok := private.(int64); !ok || (p != 0 && p != 1) {
+			v.warnf("info.private", "must be 0 or 1")
+		}
+	}
+
+	var metaVersion int64
+	if mv, ok := info["meta version"]; ok {
+		metaVersion, ok = mv.(int64)
+		if !ok || metaVersion != 2 {
+			v.errorf("info.meta version", "unsupported meta version %v", mv)
+			return
+		}
+	}
+
+	_, hasPieces := info["pieces"]
+	if metaVersion == 2 && !hasPieces {
+		// v2 only torrents are described by their file tree, which Parse validates
+		for _, key := range []string{"length", "files"} {
+			if _, ok := info[key]; ok {
+				v.warnf("info."+key, "ignored by v2 only torrents")
+			}
+		}
+		return
+	}
+
+	length, ok := v.validateContentLength(info)
+	if !ok {
+		return
+	}
+
+	pieces, ok := info["pieces"].(string)
+	if !ok {
+		v.errorf("info.pieces", "missing piece hashes")
+		return
+	}
+
+	if len(pieces)%sha1.Size != 0 {
+		v.errorf("info.pieces", "length %d is not a multiple of %d", len(pieces), sha1.Size)
+		return
+	}
+
+	if pieceLength == 0 {
+		return
+	}
+
+	if want := (length + pieceLength - 1) / pieceLength; int64(len(pieces)/sha1.Size) != want {
+		v.errorf("info.pieces", "has %d piece hashes, want %d for %d bytes with %d byte pieces", len(pieces)/sha1.Size, want, length, pieceLength)
+	}
+}
+
+// validateContentLength checks the length or the files of a v1 info
+// dictionary and returns the total length of the content
+func (v *validator) validateContentLength(info map[string]interface{}) (int64, bool) {
+	lengthValue, hasLength := info["length"]
+	filesValue, hasFiles := info["files"]
+
+	switch {
+	case hasLength && hasFiles:
+		v.errorf("info", "both length and files are set")
+		return 0, false
+	case !hasLength && !hasFiles:
+		v.errorf("info", "either length or files must be set")
+		return 0, false
+	case hasLength:
+		length, ok := lengthValue.(int64)
+		if !ok || length < 0 {
+			v.errorf("info.length", "must be a non-negative integer")
+			return 0, false
+		}
+
+		if length == 0 {
+			v.warnf("info.length", "torrent is empty")
+		}
+
+		return length, true
+	}
+
+	files, ok := filesValue.([]interface{})
+	if !ok {
+		v.errorf("info.files", "must be a list of files")
+		return 0, false
+	}
+
+	if len(files) == 0 {
+		v.errorf("info.files", "no files")
+		return 0, false
+	}
+
+	var total int64
+	valid := true
+	seen := make(map[string]bool)
+	for i, f := range files {
+		field := fmt.Sprintf("info.files[%d]", i)
+		file, ok := f.(map[string]interface{})
+		if !ok {
+			v.errorf(field, "must be a dictionary")
+			valid = false
+			continue
+		}
+
+		length, ok := file["length"].(int64)
+		if !ok || length < 0 {
+			v.errorf(field+".length", "must be a non-negative integer")
+			valid = false
+		}
+		total += length
+
+		path, ok := file["path"].([]interface{})
+		if !ok || len(path) == 0 {
+			v.errorf(field+".path", "must be a non-empty list of path components")
+			valid = false
+			continue
+		}
+
+		components := make([]string, len(path))
+		for j, c := range path {
+			s, ok := c.(string)
+			if !ok {
+				v.errorf(fmt.Sprintf("%s.path[%d]", field, j), "must be a string")
+				valid = false
+				continue
+			}
+
+			if !v.validatePathComponent(fmt.Sprintf("%s.path[%d]", field, j), s) {
+				valid = false
+			}
+			components[j] = s
+		}
+
+		attr, _ := file["attr"].(string)
+		if _, ok := file["attr"]; ok {
+			v.validateAttr(field+".attr", file["attr"])
+		}
+
+		if sha, ok := file["sha1"]; ok {
+			if s, ok := sha.(string); !ok || len(s) != sha1.Size {
+				v.errorf(field+".sha1", "must be a %d byte hash", sha1.Size)
+			}
+		}
+
+		if md5sum, ok := file["md5sum"]; ok {
+			v.validateMD5Sum(field+".md5sum", md5sum)
+		}
+
+		if Attr(attr).Symlink() {
+			if !v.validateSymlinkPath(field+".symlink path", file["symlink path"]) {
+				valid = false
+			}
+		}
+
+		// pad files of the same length may share a path
+		key := strings.Join(components, "/")
+		if seen[key] && !Attr(attr).Pad() {
+			v.errorf(field+".path", "duplicate path %q", key)
+			valid = false
+		}
+		seen[key] = true
+	}
+
+	if valid && total == 0 {
+		v.warnf("info.files", "torrent is empty")
+	}
+
+	return total, valid
+}
+
+func (v *validator) validateAttr(field string, attr interface{}) {
+	s, ok := attr.(string)
+	if !ok {
+		v.errorf(field, "must be a string")
+		return
+	}
+
+	for _, c := range s {
+		switch c {
+		case AttrPad, AttrExecutable, AttrHidden, AttrSymlink:
+		default:
+			v.warnf(field, "unknown attribute %q", c)
+		}
+	}
+}
+
+func (v *validator) validateMD5Sum(field string, md5sum interface{}) {
+	s, ok := md5sum.(string)
+	if !ok {
+		v.errorf(field, "must be a string")
+		return
+	}
+
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 2*md5.Size {
+		v.warnf(field, "%q is not a hex encoded MD5 hash", s)
+	}
+}
+
+func (v *validator) validateSymlinkPath(field string, symlinkPath interface{}) bool {
+	path, ok := symlinkPath.([]interface{})
+	if !ok || len(path) == 0 {
+		v.errorf(field, "symbolic links must have a non-empty list of path components")
+		return false
+	}
+
+	valid := true
+	for i, c := range path {
+		s, ok := c.(string)
+		if !ok {
+			v.errorf(fmt.Sprintf("%s[%d]", field, i), "must be a string")
+			valid = false
+			continue
+		}
+
+		if !v.validatePathComponent(fmt.Sprintf("%s[%d]", field, i), s) {
+			valid = false
+		}
+	}
+
+	return valid
+}
+
+// validatePathComponent rejects names which would escape or collide with the
+// download directory once joined into a file path
+func (v *validator) validatePathComponent(field, name string) bool {
+	switch {
+	case len(name) == 0:
+		v.errorf(field, "empty path component")
+	case name == "." || name == "..":
+		v.errorf(field, "path component %q is not allowed", name)
+	case strings.ContainsAny(name, "/\\\x00"):
+		v.errorf(field, "path component %q contains a path separator or a null byte", name)
+	default:
+		return true
+	}
+
+	return false
+}
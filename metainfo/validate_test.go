@@ -0,0 +1,188 @@
+package metainfo_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/metainfo"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	torrent, _ := buildTorrent(t, true, nil)
+	noLayers, _ := buildTorrent(t, false, func(torrent, info map[string]interface{}) {
+		delete(torrent, "piece layers")
+	})
+
+	cases := []struct {
+		name   string
+		input  []byte
+		output []metainfo.Problem
+		fails  bool
+	}{
+		{
+			name:   "valid single file torrent",
+			input:  []byte("d8:announce35:http://example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi16384e6:pieces20:11111111111111111111ee"),
+			output: nil,
+		},
+		{
+			name:   "valid hybrid torrent",
+			input:  torrent,
+			output: nil,
+		},
+		{
+			name:  "pieces length not a multiple of 20",
+			input: []byte("d8:announce35:http://example.tracker.com/announce4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi16384e6:pieces21:111111111111111111111ee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "info.pieces", Message: "length 21 is not a multiple of 20"},
+			},
+		},
+		{
+			name:  "piece count not matching the content length",
+			input: []byte("d8:announce35:http://example.tracker.com/announce4:infod6:lengthi40000e4:name8:test.txt12:piece lengthi16384e6:pieces40:1111111111111111111122222222222222222222ee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "info.pieces", Message: "has 2 piece hashes, want 3 for 40000 bytes with 16384 byte pieces"},
+			},
+		},
+		{
+			name:  "both length and files",
+			input: []byte("d8:announce35:http://example.tracker.com/announce4:infod5:filesld6:lengthi1000e4:pathl7:bar.pngeee6:lengthi1000e4:name4:test12:piece lengthi16384e6:pieces20:11111111111111111111ee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "info", Message: "both length and files are set"},
+			},
+		},
+		{
+			name:  "invalid path components and duplicate paths",
+			input: []byte("d8:announce35:http://example.tracker.com/announce4:infod5:filesld6:lengthi1000e4:pathl2:..7:bar.pngeed6:lengthi1000e4:pathl0:eed6:lengthi1000e4:pathl3:foo7:baz.jpgeed6:lengthi1000e4:pathl3:foo7:baz.jpgeee4:name4:test12:piece lengthi16384e6:pieces20:11111111111111111111ee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "info.files[0].path[0]", Message: `path component ".." is not allowed`},
+				{Severity: metainfo.SeverityError, Field: "info.files[1].path[0]", Message: "empty path component"},
+				{Severity: metainfo.SeverityError, Field: "info.files[3].path", Message: `duplicate path "foo/baz.jpg"`},
+			},
+		},
+		{
+			name:  "file attributes",
+			input: []byte("d8:announce35:http://example.tracker.com/announce4:infod5:filesld4:attr1:p6:lengthi1000e4:pathl4:.pad4:1000eed4:attr1:p6:lengthi1000e4:pathl4:.pad4:1000eed4:attr2:lz6:lengthi0e4:pathl4:linke12:symlink pathl2:..3:etceee4:name4:test12:piece lengthi16384e6:pieces20:11111111111111111111ee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityWarning, Field: "info.files[2].attr", Message: "unknown attribute 'z'"},
+				{Severity: metainfo.SeverityError, Field: "info.files[2].symlink path[0]", Message: `path component ".." is not allowed`},
+			},
+		},
+		{
+			name:  "warnings on trackers, web seeds and piece length",
+			input: []byte("d13:announce-listll25:wss://tracker.example.comelee4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi10000e6:pieces20:11111111111111111111e8:url-list10:ftp://filee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityWarning, Field: "announce-list[0][0]", Message: `unsupported tracker url "wss://tracker.example.com"`},
+				{Severity: metainfo.SeverityWarning, Field: "announce-list[1]", Message: "empty tier"},
+				{Severity: metainfo.SeverityWarning, Field: "url-list[0]", Message: `unsupported web seed url "ftp://file"`},
+				{Severity: metainfo.SeverityWarning, Field: "info.piece length", Message: "10000 is not a power of two"},
+			},
+		},
+		{
+			name:  "optional fields with wrong types or format",
+			input: []byte("d8:announce35:http://example.tracker.com/announce8:encodingi8e4:infod6:lengthi10000e6:md5sum4:abcd4:name8:test.txt12:piece lengthi16384e6:pieces20:111111111111111111116:sourcei1eee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "encoding", Message: "must be a string"},
+				{Severity: metainfo.SeverityError, Field: "info.source", Message: "must be a string"},
+				{Severity: metainfo.SeverityWarning, Field: "info.md5sum", Message: `"abcd" is not a hex encoded MD5 hash`},
+			},
+		},
+		{
+			name:  "invalid dht nodes",
+			input: []byte("d4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi16384e6:pieces20:11111111111111111111e5:nodesll9:127.0.0.1i0eei5eee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "nodes[0]", Message: "port must be an integer between 1 and 65535"},
+				{Severity: metainfo.SeverityError, Field: "nodes[1]", Message: "must be a list of a host and a port"},
+			},
+		},
+		{
+			name:  "private torrent without trackers",
+			input: []byte("d4:infod6:lengthi10000e4:name8:test.txt12:piece lengthi16384e6:pieces20:111111111111111111117:privatei1eee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "announce", Message: "private torrent without trackers, its peers cannot be found"},
+			},
+		},
+		{
+			name:  "missing info dictionary",
+			input: []byte("d8:announce35:http://example.tracker.com/announcee"),
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "info", Message: "missing info dictionary"},
+			},
+		},
+		{
+			name:  "problems found by parse",
+			input: noLayers,
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Message: "missing piece layer for file [a a.bin]"},
+			},
+		},
+		{
+			name:  "error on invalid bencode",
+			input: []byte("foobar"),
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := metainfo.Validate(bytes.NewReader(tc.input))
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  []string
+		output []metainfo.Problem
+	}{
+		{
+			name:   "valid path",
+			input:  []string{"foo", "bar.txt"},
+			output: nil,
+		},
+		{
+			name:  "empty path",
+			input: []string{},
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "path", Message: "empty path"},
+			},
+		},
+		{
+			name:  "escaping components",
+			input: []string{"..", "foo/bar", ""},
+			output: []metainfo.Problem{
+				{Severity: metainfo.SeverityError, Field: "path[0]", Message: `path component ".." is not allowed`},
+				{Severity: metainfo.SeverityError, Field: "path[1]", Message: `path component "foo/bar" contains a path separator or a null byte`},
+				{Severity: metainfo.SeverityError, Field: "path[2]", Message: "empty path component"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := metainfo.ValidatePath("path", tc.input)
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
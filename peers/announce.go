@@ -0,0 +1,66 @@
+package peers
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultPort is the port announced to trackers
+const DefaultPort = 6881
+
+// Event tells trackers about a change of state of a download, its values
+// are those of the udp tracker protocol
+type Event uint32
+
+const (
+	EventNone Event = iota
+	EventCompleted
+	EventStarted
+	EventStopped
+)
+
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// AnnounceRequest holds the parameters of an announce
+type AnnounceRequest struct {
+	InfoHash   []byte
+	PeerID     []byte
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	// NumWant is the number of peers requested, zero leaves it to the tracker
+	NumWant int
+	// TrackerID is the tracker id returned by a previous announce to the same tracker
+	TrackerID string
+	// IPv4 and IPv6 are the public addresses of the client, if known
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+// AnnounceResponse is the answer of a tracker to an announce
+type AnnounceResponse struct {
+	Peers []Peer
+	// Interval is the time the tracker asks to wait between regular announces
+	Interval time.Duration
+	// MinInterval is the time the client must wait between announces, if set
+	MinInterval time.Duration
+	// TrackerID is sent back to the tracker on the next announces, if set
+	TrackerID string
+	Seeders   int
+	Leechers  int
+	// Warning is a message of the tracker about an announce which succeeded
+	Warning string
+}
@@ -0,0 +1,224 @@
+package peers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAnnounceInterval is used when a tracker does not give an interval
+	DefaultAnnounceInterval = 30 * time.Minute
+	// DefaultRetryInterval is the first wait after a failed announce, doubled on each failure
+	DefaultRetryInterval = 15 * time.Second
+)
+
+// TransferStats are the transfer counters of a download reported to trackers
+type TransferStats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// Announcer keeps a download announced to its trackers for its whole life:
+// it sends the started event, re-announces at the interval asked by the
+// tracker, then sends the completed and stopped events
+type Announcer struct {
+	trackers *TrackerList
+	infoHash []byte
+	peerID   []byte
+	port     uint16
+	stats    func() TransferStats
+	logger   *slog.Logger
+
+	// RetryInterval is the first wait after a failed announce, doubled up to the announce interval
+	RetryInterval time.Duration
+
+	mu      sync.Mutex
+	started bool
+	// tracker is the url of the tracker which answered the last announce
+	tracker     string
+	interval    time.Duration
+	minInterval time.Duration
+	last        time.Time
+	failures    int
+
+	completeOnce sync.Once
+	completed    chan struct{}
+}
+
+// NewAnnouncer creates an Announcer of the swarm of an info hash, the stats
+// function is called for the counters of every announce
+func NewAnnouncer(logger *slog.Logger, trackers *TrackerList, infoHash, peerID []byte, port uint16, stats func() TransferStats) *Announcer {
+	return &Announcer{
+		trackers:      trackers,
+		infoHash:      infoHash,
+		peerID:        peerID,
+		port:          port,
+		stats:         stats,
+		logger:        logger.With(slog.String("info_hash", fmt.Sprintf("%x", infoHash))),
+		RetryInterval: DefaultRetryInterval,
+		interval:      DefaultAnnounceInterval,
+		completed:     make(chan struct{}),
+	}
+}
+
+// Start sends the started event and returns the peers of the first tracker which answered
+func (a *Announcer) Start(ctx context.Context) ([]Peer, error) {
+	resp, err := a.announce(ctx, EventStarted)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Peers, nil
+}
+
+// Complete makes Run send the completed event, it must be called once the whole content is downloaded
+func (a *Announcer) Complete() {
+	a.completeOnce.Do(func() {
+		close(a.completed)
+	})
+}
+
+// Run re-announces until the context is done, the peers returned by the
+// trackers are sent to peerStream. The started event is sent first if Start
+// did not succeed, and the stopped event once the context is done
+func (a *Announcer) Run(ctx context.Context, peerStream chan<- []Peer) {
+	// the completed and stopped events are still sent once the context is done
+	stopCtx := context.WithoutCancel(ctx)
+	completed := a.completed
+	for {
+		timer := time.NewTimer(a.nextAnnounce())
+
+		var (
+			resp *AnnounceResponse
+			err  error
+		)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			a.stop(stopCtx, completed)
+			return
+		case <-completed:
+			timer.Stop()
+			completed = nil
+			resp, err = a.announce(stopCtx, EventCompleted)
+		case <-timer.C:
+			event := EventNone
+			if !a.isStarted() {
+				event = EventStarted
+			}
+			resp, err = a.announce(ctx, event)
+		}
+
+		if err != nil || len(resp.Peers) == 0 {
+			continue
+		}
+
+		select {
+		case peerStream <- resp.Peers:
+		case <-ctx.Done():
+			a.stop(stopCtx, completed)
+			return
+		}
+	}
+}
+
+// stop sends the completed event if it is still due, then the stopped event
+func (a *Announcer) stop(ctx context.Context, completed <-chan struct{}) {
+	if !a.isStarted() {
+		return
+	}
+
+	select {
+	case <-completed:
+		a.announce(ctx, EventCompleted)
+	default:
+	}
+
+	a.announce(ctx, EventStopped)
+}
+
+func (a *Announcer) isStarted() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.started
+}
+
+// nextAnnounce returns the time to wait until the next regular announce
+func (a *Announcer) nextAnnounce() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.last.IsZero() {
+		return 0
+	}
+
+	wait := a.interval
+	if a.failures > 0 {
+		wait = min(a.RetryInterval<<min(a.failures-1, 16), a.interval)
+	}
+
+	return max(wait, a.minInterval) - time.Since(a.last)
+}
+
+// announce sends an event, the events following the started one go to the
+// tracker which answered last so that it keeps track of the download
+func (a *Announcer) announce(ctx context.Context, event Event) (*AnnounceResponse, error) {
+	stats := a.stats()
+	ipv4, ipv6 := localAddrs(a.trackers.family)
+	req := AnnounceRequest{
+		InfoHash:   a.infoHash,
+		PeerID:     a.peerID,
+		Port:       a.port,
+		Uploaded:   stats.Uploaded,
+		Downloaded: stats.Downloaded,
+		Left:       stats.Left,
+		Event:      event,
+		IPv4:       ipv4,
+		IPv6:       ipv6,
+	}
+
+	a.mu.Lock()
+	tracker := a.tracker
+	a.mu.Unlock()
+
+	var (
+		resp *AnnounceResponse
+		err  error
+	)
+	if (event == EventStopped || event == EventCompleted) && len(tracker) > 0 {
+		resp, err = a.trackers.AnnounceTo(ctx, tracker, req)
+	} else {
+		resp, tracker, err = a.trackers.Announce(ctx, req)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.last = time.Now()
+	if err != nil {
+		a.failures++
+		a.logger.Error("failed to announce", slog.String("event", event.String()), "error", err)
+		return nil, err
+	}
+
+	a.logger.Info("announced", slog.String("tracker", tracker), slog.String("event", event.String()), slog.Int("peers", len(resp.Peers)))
+	if len(resp.Warning) > 0 {
+		a.logger.Warn("tracker warning", slog.String("tracker", tracker), slog.String("warning", resp.Warning))
+	}
+
+	a.failures = 0
+	a.started = event != EventStopped
+	a.tracker = tracker
+	a.interval = DefaultAnnounceInterval
+	if resp.Interval > 0 {
+		a.interval = resp.Interval
+	}
+	a.minInterval = resp.MinInterval
+
+	return resp, nil
+}
@@ -0,0 +1,172 @@
+package peers_test
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestAnnouncer(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	type announce struct {
+		event      string
+		trackerID  string
+		downloaded string
+		left       string
+	}
+
+	var (
+		mu        sync.Mutex
+		announces []announce
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		mu.Lock()
+		announces = append(announces, announce{q.Get("event"), q.Get("trackerid"), q.Get("downloaded"), q.Get("left")})
+		mu.Unlock()
+
+		// interval of 1 second, tracker id abc and a single peer 1.2.3.4:12345
+		w.Write([]byte("d8:intervali1e10:tracker id3:abc5:peers6:\x01\x02\x03\x04\x30\x39e"))
+	}))
+	defer srv.Close()
+
+	var (
+		statsMu    sync.Mutex
+		downloaded int64
+	)
+	stats := func() peers.TransferStats {
+		statsMu.Lock()
+		defer statsMu.Unlock()
+		return peers.TransferStats{Downloaded: downloaded, Left: 100 - downloaded}
+	}
+
+	trackers := peers.NewTrackerList([][]string{{srv.URL + "/announce"}}, peers.FamilyAny)
+	a := peers.NewAnnouncer(slog.Default(), trackers, infoHash, peerID, peers.DefaultPort, stats)
+
+	got, err := a.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []peers.Peer{{IP: "1.2.3.4", Port: 12345}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	peerStream := make(chan []peers.Peer)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, peerStream)
+		close(done)
+	}()
+
+	// the peers of the regular announce following the interval are fed to the stream
+	statsMu.Lock()
+	downloaded = 40
+	statsMu.Unlock()
+
+	select {
+	case got = <-peerStream:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no re-announce after the interval")
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	statsMu.Lock()
+	downloaded = 100
+	statsMu.Unlock()
+
+	a.Complete()
+	cancel()
+	<-done
+
+	wantAnnounces := []announce{
+		{"started", "", "0", "100"},
+		{"", "abc", "40", "60"},
+		{"completed", "abc", "100", "0"},
+		{"stopped", "abc", "100", "0"},
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !cmp.Equal(wantAnnounces, announces, cmp.AllowUnexported(announce{})) {
+		t.Error(cmp.Diff(wantAnnounces, announces, cmp.AllowUnexported(announce{})))
+	}
+}
+
+func TestAnnouncer_RetriesStarted(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, r.URL.Query().Get("event"))
+
+		// the first announce fails
+		if len(events) == 1 {
+			w.Write([]byte("d14:failure reason4:busye"))
+			return
+		}
+		w.Write([]byte("d8:intervali1800e5:peers6:\x01\x02\x03\x04\x30\x39e"))
+	}))
+	defer srv.Close()
+
+	stats := func() peers.TransferStats {
+		return peers.TransferStats{Left: 100}
+	}
+
+	trackers := peers.NewTrackerList([][]string{{srv.URL + "/announce"}}, peers.FamilyAny)
+	a := peers.NewAnnouncer(slog.Default(), trackers, infoHash, peerID, peers.DefaultPort, stats)
+	a.RetryInterval = 10 * time.Millisecond
+
+	if _, err := a.Start(context.Background()); err == nil {
+		t.Fatal("expect error, got nil")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	peerStream := make(chan []peers.Peer)
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx, peerStream)
+		close(done)
+	}()
+
+	select {
+	case <-peerStream:
+	case <-time.After(5 * time.Second):
+		t.Fatal("started announce was not retried")
+	}
+
+	cancel()
+	<-done
+
+	want := []string{"started", "started", "stopped"}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !cmp.Equal(want, events) {
+		t.Error(cmp.Diff(want, events))
+	}
+}
@@ -0,0 +1,106 @@
+package peers
+
+import (
+	"fmt"
+	"net"
+)
+
+// Family restricts the trackers and peers to an address family, it lets
+// clients run on hosts lacking either IPv4 or IPv6 connectivity
+type Family int
+
+const (
+	FamilyAny Family = iota
+	FamilyIPv4
+	FamilyIPv6
+)
+
+// ParseFamily parses any, ipv4 or ipv6
+func ParseFamily(s string) (Family, error) {
+	switch s {
+	case "any":
+		return FamilyAny, nil
+	case "ipv4":
+		return FamilyIPv4, nil
+	case "ipv6":
+		return FamilyIPv6, nil
+	default:
+		return FamilyAny, fmt.Errorf("unknown address family %q, must be one of any, ipv4 or ipv6", s)
+	}
+}
+
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4:
+		return "ipv4"
+	case FamilyIPv6:
+		return "ipv6"
+	default:
+		return "any"
+	}
+}
+
+// Network returns the network of the family to dial, such as tcp6 for tcp
+func (f Family) Network(network string) string {
+	switch f {
+	case FamilyIPv4:
+		return network + "4"
+	case FamilyIPv6:
+		return network + "6"
+	default:
+		return network
+	}
+}
+
+// Allows reports whether an ip address belongs to the family, host names are allowed
+func (f Family) Allows(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil || f == FamilyAny {
+		return true
+	}
+
+	if ip.To4() != nil {
+		return f == FamilyIPv4
+	}
+
+	return f == FamilyIPv6
+}
+
+// Filter returns the peers reachable with the family
+func (f Family) Filter(peers []Peer) []Peer {
+	var allowed []Peer
+	for _, p := range peers {
+		if f.Allows(p.IP) {
+			allowed = append(allowed, p)
+		}
+	}
+
+	return allowed
+}
+
+// localAddrs returns the public addresses of the host announced to trackers
+// so that they can hand out both of them, as described in BEP 7. Only the
+// addresses of the family are returned
+func localAddrs(family Family) (ipv4, ipv6 net.IP) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, nil
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsPrivate() {
+			continue
+		}
+
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			if ipv4 == nil && family != FamilyIPv6 {
+				ipv4 = ip4
+			}
+		} else if ipv6 == nil && family != FamilyIPv4 {
+			ipv6 = ipNet.IP
+		}
+	}
+
+	return ipv4, ipv6
+}
@@ -0,0 +1,68 @@
+package peers_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestFamilyFilter(t *testing.T) {
+	t.Parallel()
+
+	input := []peers.Peer{
+		{IP: "1.2.3.4", Port: 1},
+		{IP: "2001:db8::1", Port: 2},
+		{IP: "::ffff:5.6.7.8", Port: 3},
+		{IP: "peer.example.com", Port: 4},
+	}
+
+	cases := []struct {
+		name   string
+		input  string
+		output []peers.Peer
+		fails  bool
+	}{
+		{
+			name:   "any keeps every peer",
+			input:  "any",
+			output: input,
+		},
+		{
+			name:   "ipv4 keeps IPv4 and IPv4-mapped addresses",
+			input:  "ipv4",
+			output: []peers.Peer{input[0], input[2], input[3]},
+		},
+		{
+			name:   "ipv6 keeps IPv6 addresses",
+			input:  "ipv6",
+			output: []peers.Peer{input[1], input[3]},
+		},
+		{
+			name:  "error on unknown family",
+			input: "ipx",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := peers.ParseFamily(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := f.Filter(input)
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
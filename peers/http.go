@@ -0,0 +1,258 @@
+package peers
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kanowfy/btor/bencode"
+)
+
+const (
+	// DefaultTrackerTimeout bounds the time of a request to an http tracker
+	DefaultTrackerTimeout = 30 * time.Second
+	// maxTrackerRedirects bounds the redirects followed by a request to an http tracker
+	maxTrackerRedirects = 5
+	// maxTrackerResponse bounds the decoded size of a response of an http tracker
+	maxTrackerResponse = 4 << 20 // 4MiB
+)
+
+var (
+	ErrTrackerResponseTooLarge = errors.New("tracker response too large")
+)
+
+// HTTPTracker is a tracker reached over http or https, as described in BEP 3
+type HTTPTracker struct {
+	announceUrl string
+	// Client sends the requests to the tracker
+	Client *http.Client
+}
+
+// NewHTTPTracker creates the tracker of an http announce url, its client
+// times out after DefaultTrackerTimeout and dials with the address family
+func NewHTTPTracker(announceUrl string, family Family) *HTTPTracker {
+	return &HTTPTracker{
+		announceUrl: announceUrl,
+		Client:      trackerHTTPClient(family),
+	}
+}
+
+func newHTTPTracker(trackerUrl string, family Family) (Tracker, error) {
+	return NewHTTPTracker(trackerUrl, family), nil
+}
+
+// Announce sends a GET request to the announce url and parses the response
+func (t *HTTPTracker) Announce(ctx context.Context, ar AnnounceRequest) (*AnnounceResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.announceUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := req.URL.Query()
+
+	q.Add("info_hash", string(ar.InfoHash))
+	q.Add("peer_id", string(ar.PeerID))
+	q.Add("port", strconv.Itoa(int(ar.Port)))
+	q.Add("uploaded", strconv.FormatInt(ar.Uploaded, 10))
+	q.Add("downloaded", strconv.FormatInt(ar.Downloaded, 10))
+	q.Add("left", strconv.FormatInt(ar.Left, 10))
+	q.Add("compact", "1")
+	if ar.Event != EventNone {
+		q.Add("event", ar.Event.String())
+	}
+	if ar.NumWant > 0 {
+		q.Add("numwant", strconv.Itoa(ar.NumWant))
+	}
+	if len(ar.TrackerID) > 0 {
+		q.Add("trackerid", ar.TrackerID)
+	}
+	if ar.IPv4 != nil {
+		q.Add("ipv4", ar.IPv4.String())
+	}
+	if ar.IPv6 != nil {
+		q.Add("ipv6", ar.IPv6.String())
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	var tr struct {
+		FailureReason  string             `bencode:"failure reason"`
+		WarningMessage string             `bencode:"warning message"`
+		Interval       int                `bencode:"interval"`
+		MinInterval    int                `bencode:"min interval"`
+		TrackerID      string             `bencode:"tracker id"`
+		Complete       int                `bencode:"complete"`
+		Incomplete     int                `bencode:"incomplete"`
+		Peers          bencode.RawMessage `bencode:"peers"`
+		// Peers6 are the compact IPv6 peers, as described in BEP 7
+		Peers6 string `bencode:"peers6"`
+	}
+	if err = t.do(req, &tr); err != nil {
+		return nil, err
+	}
+
+	if len(tr.FailureReason) > 0 {
+		return nil, &ErrTrackerFailure{Reason: tr.FailureReason}
+	}
+
+	var peers []Peer
+
+	// some tracker will not return compact peer representation, this will support both compact and non-compact ones
+	switch {
+	case len(tr.Peers) > 0 && tr.Peers[0] == 'l':
+		if err = bencode.Unmarshal(tr.Peers, &peers); err != nil {
+			return nil, fmt.Errorf("failed to parse peers: %v", err)
+		}
+	case len(tr.Peers) > 0:
+		var compact string
+		if err = bencode.Unmarshal(tr.Peers, &compact); err != nil {
+			return nil, fmt.Errorf("invalid peer representation")
+		}
+
+		peers, err = parseCompactPeers([]byte(compact))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse peers: %v", err)
+		}
+	case ar.Event != EventStopped && len(tr.Peers6) == 0:
+		// trackers may leave out the peers of a stopped announce
+		return nil, fmt.Errorf("invalid peer representation")
+	}
+
+	peers6, err := parseCompactPeers6([]byte(tr.Peers6))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peers: %v", err)
+	}
+	peers = append(peers, peers6...)
+
+	return &AnnounceResponse{
+		Peers:       peers,
+		Interval:    time.Duration(tr.Interval) * time.Second,
+		MinInterval: time.Duration(tr.MinInterval) * time.Second,
+		TrackerID:   tr.TrackerID,
+		Seeders:     tr.Complete,
+		Leechers:    tr.Incomplete,
+		Warning:     tr.WarningMessage,
+	}, nil
+}
+
+// Scrape sends GET requests with the info hashes to the scrape url derived
+// from the announce url, in batches
+func (t *HTTPTracker) Scrape(ctx context.Context, infoHashes [][]byte) ([]ScrapeResult, error) {
+	scrapeUrl, err := ScrapeURL(t.announceUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return scrapeBatches(infoHashes, httpScrapeBatch, func(batch [][]byte) ([]ScrapeResult, error) {
+		return t.scrape(ctx, scrapeUrl, batch)
+	})
+}
+
+func (t *HTTPTracker) scrape(ctx context.Context, scrapeUrl string, infoHashes [][]byte) ([]ScrapeResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scrapeUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	q := req.URL.Query()
+	for _, infoHash := range infoHashes {
+		q.Add("info_hash", string(infoHash))
+	}
+	req.URL.RawQuery = q.Encode()
+
+	var sr struct {
+		Files         map[string]ScrapeResult `bencode:"files"`
+		FailureReason string                  `bencode:"failure reason"`
+	}
+	if err = t.do(req, &sr); err != nil {
+		var statusErr *ErrTrackerStatus
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return nil, ErrScrapeUnsupported
+		}
+		return nil, err
+	}
+
+	if len(sr.FailureReason) > 0 {
+		return nil, &ErrTrackerFailure{Reason: sr.FailureReason}
+	}
+
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		results[i] = sr.Files[string(infoHash)]
+		results[i].InfoHash = infoHash
+	}
+
+	return results, nil
+}
+
+// do sends a request to the tracker and decodes the bencoded dictionary of
+// the response into v, the response may be gzip compressed
+func (t *HTTPTracker) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// failure reasons may come with an error status
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return &ErrTrackerStatus{StatusCode: resp.StatusCode}
+	}
+
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// compressed responses may expand to far more than their length
+	b, err := io.ReadAll(io.LimitReader(body, maxTrackerResponse+1))
+	if err != nil {
+		return err
+	}
+
+	if len(b) > maxTrackerResponse {
+		return ErrTrackerResponseTooLarge
+	}
+
+	if len(b) == 0 || b[0] != 'd' {
+		if resp.StatusCode != http.StatusOK {
+			return &ErrTrackerStatus{StatusCode: resp.StatusCode}
+		}
+		return fmt.Errorf("invalid tracker response: expected dictionary")
+	}
+
+	return bencode.Unmarshal(b, v)
+}
+
+// trackerHTTPClient dials http trackers with the address family
+func trackerHTTPClient(family Family) *http.Client {
+	dialer := &net.Dialer{Timeout: DefaultTrackerTimeout}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, family.Network("tcp"), addr)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   DefaultTrackerTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxTrackerRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxTrackerRedirects)
+			}
+			return nil
+		},
+	}
+}
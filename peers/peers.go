@@ -1,78 +1,117 @@
 package peers
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"net"
-	"net/http"
 	"strconv"
+)
 
-	"github.com/jackpal/bencode-go"
-	"github.com/mitchellh/mapstructure"
+var (
+	ErrUnsupportedTracker = errors.New("unsupported tracker protocol")
 )
 
 type Peer struct {
-	ID   string `mapstructure:"peer id"`
-	IP   string `mapstructure:"ip"`
-	Port uint16 `mapstructure:"port"`
+	ID   string `bencode:"peer id"`
+	IP   string `bencode:"ip"`
+	Port uint16 `bencode:"port"`
+}
+
+// Addr returns the address of the peer, with IPv6 addresses enclosed in brackets
+func (p Peer) Addr() string {
+	return net.JoinHostPort(p.IP, strconv.Itoa(int(p.Port)))
 }
 
-// Fetch sends a GET request to a tracker endpoint, parses the response and returns the peers
-func Fetch(trackerUrl string, infoHash []byte, length int, peerID []byte) ([]Peer, error) {
-	req, err := http.NewRequest(http.MethodGet, trackerUrl, nil)
+// Fetch announces to a tracker and returns the peers, the tracker protocol
+// is selected by the scheme of the tracker url
+func Fetch(trackerUrl string, family Family, infoHash []byte, length int, peerID []byte) ([]Peer, error) {
+	resp, err := Announce(trackerUrl, family, AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   peerID,
+		Port:     DefaultPort,
+		Left:     int64(length),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	q := req.URL.Query()
-
-	q.Add("info_hash", string(infoHash))
-	q.Add("peer_id", string(peerID))
-	q.Add("port", "6881")
-	q.Add("uploaded", "0")
-	q.Add("downloaded", "0")
-	q.Add("left", strconv.Itoa(length))
-	q.Add("compact", "1")
-
-	req.URL.RawQuery = q.Encode()
+	return resp.Peers, nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+// Announce sends an announce request to a tracker, the tracker transport is
+// selected by the scheme of the tracker url. Only the peers of the address
+// family are returned
+func Announce(trackerUrl string, family Family, req AnnounceRequest) (*AnnounceResponse, error) {
+	t, err := NewTracker(trackerUrl, family)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	decoded, err := bencode.Decode(resp.Body)
+	resp, err := t.Announce(context.Background(), req)
 	if err != nil {
 		return nil, err
 	}
 
-	var peers []Peer
+	resp.Peers = family.Filter(resp.Peers)
+	return resp, nil
+}
+
+// ParseCompact parses peers in the compact format, 6 byte IPv4 or 18 byte
+// IPv6 address and port entries as described in BEP 7
+func ParseCompact(b []byte, ipv6 bool) ([]Peer, error) {
+	if ipv6 {
+		return parseCompactPeers6(b)
+	}
+
+	return parseCompactPeers(b)
+}
 
-	// some tracker will not return compact peer representation, this will support both compact and non-compact ones
-	peerResp := decoded.(map[string]interface{})["peers"]
-	switch v := peerResp.(type) {
-	case string:
-		peers, err = parseCompactPeers([]byte(v))
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse peers: %v", err)
+// Compact encodes the peers of an address family in the compact format, the
+// peers of the other family and the peers given by host name are left out
+func Compact(peerList []Peer, ipv6 bool) []byte {
+	var b []byte
+	for _, p := range peerList {
+		ip := net.ParseIP(p.IP)
+		if ip == nil || (ip.To4() == nil) != ipv6 {
+			continue
 		}
-	case []interface{}:
-		mapstructure.Decode(v, &peers)
-	default:
-		return nil, fmt.Errorf("invalid peer representation")
+
+		if !ipv6 {
+			ip = ip.To4()
+		}
+
+		b = append(b, ip...)
+		b = binary.BigEndian.AppendUint16(b, p.Port)
 	}
 
-	return peers, nil
+	return b
 }
 
+// parseCompactPeers parses 6 byte IPv4 address and port entries
 func parseCompactPeers(peerString []byte) ([]Peer, error) {
-	numPeers := len(peerString) / 6
+	return parseCompact(peerString, net.IPv4len)
+}
+
+// parseCompactPeers6 parses 18 byte IPv6 address and port entries
+func parseCompactPeers6(peerString []byte) ([]Peer, error) {
+	return parseCompact(peerString, net.IPv6len)
+}
+
+func parseCompact(peerString []byte, ipLen int) ([]Peer, error) {
+	size := ipLen + 2
+	if len(peerString)%size != 0 {
+		return nil, fmt.Errorf("compact peers length %d is not a multiple of %d", len(peerString), size)
+	}
+
+	numPeers := len(peerString) / size
 	peers := make([]Peer, numPeers)
 	for i := range numPeers {
+		entry := peerString[i*size : (i+1)*size]
 		peers[i] = Peer{
-			IP:   net.IP(peerString[i*6 : i*6+4]).String(),
-			Port: binary.BigEndian.Uint16(peerString[i*6+4 : i*6+6]),
+			IP:   net.IP(entry[:ipLen]).String(),
+			Port: binary.BigEndian.Uint16(entry[ipLen:]),
 		}
 	}
 	return peers, nil
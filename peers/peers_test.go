@@ -2,6 +2,7 @@ package peers_test
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
@@ -53,6 +54,21 @@ func TestFetch(t *testing.T) {
 			},
 			fails: false,
 		},
+		{
+			name:     "correctly fetch and decode compact IPv6 peers",
+			response: append(append([]byte("d8:intervali5e5:peers6:\x01\x02\x03\x04\x30\x396:peers618:"), net.ParseIP("2001:db8::1")...), []byte("\xdd\xd5e")...),
+			output: []peers.Peer{
+				{
+					IP:   "1.2.3.4",
+					Port: 12345,
+				},
+				{
+					IP:   "2001:db8::1",
+					Port: 56789,
+				},
+			},
+			fails: false,
+		},
 		{
 			name:     "error on invalid bencode response",
 			response: []byte("d8:intervali5e5:peersld7:peer id20:123456789012345678902:ip7:1.2.3.44:porti12345eed7:peer id20:001122334455667788992:ip7:5.6.7.84:porti56789"),
@@ -80,7 +96,7 @@ func TestFetch(t *testing.T) {
 
 	for i, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			peers, err := peers.Fetch(fmt.Sprintf("%s/%d", srv.URL, i), infoHash, 1000, peerID)
+			peers, err := peers.Fetch(fmt.Sprintf("%s/%d", srv.URL, i), peers.FamilyAny, infoHash, 1000, peerID)
 			if tc.fails {
 				if err == nil {
 					t.Fatal("expect error, got nil")
@@ -99,3 +115,32 @@ func TestFetch(t *testing.T) {
 	}
 
 }
+
+func TestPeerAddr(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  peers.Peer
+		output string
+	}{
+		{
+			name:   "IPv4",
+			input:  peers.Peer{IP: "1.2.3.4", Port: 6881},
+			output: "1.2.3.4:6881",
+		},
+		{
+			name:   "IPv6 is enclosed in brackets",
+			input:  peers.Peer{IP: "2001:db8::1", Port: 6881},
+			output: "[2001:db8::1]:6881",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.input.Addr(); got != tc.output {
+				t.Errorf("want %s, got %s", tc.output, got)
+			}
+		})
+	}
+}
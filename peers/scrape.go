@@ -0,0 +1,77 @@
+package peers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+const (
+	// httpScrapeBatch keeps the query string of http scrapes at a reasonable length
+	httpScrapeBatch = 50
+	// udpScrapeBatch is the number of info hashes fitting in a udp scrape response, as described in BEP 15
+	udpScrapeBatch = 74
+)
+
+var (
+	ErrScrapeUnsupported = errors.New("tracker does not support scrape")
+)
+
+// ScrapeResult is the state of a swarm reported by a tracker
+type ScrapeResult struct {
+	InfoHash []byte `bencode:"-"`
+	// Seeders is the number of peers having the complete content
+	Seeders int `bencode:"complete"`
+	// Leechers is the number of peers still downloading
+	Leechers int `bencode:"incomplete"`
+	// Completed is the number of times the content was downloaded entirely
+	Completed int `bencode:"downloaded"`
+}
+
+// ScrapeURL returns the scrape url of an http tracker, which is derived from
+// the announce url by replacing the announce of its last path segment with scrape
+func ScrapeURL(announceUrl string) (string, error) {
+	u, err := url.Parse(announceUrl)
+	if err != nil {
+		return "", err
+	}
+
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", ErrScrapeUnsupported
+	}
+
+	u.Path = u.Path[:i+1] + "scrape" + strings.TrimPrefix(u.Path[i+1:], "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// Scrape requests the state of the swarms of the info hashes from a tracker,
+// the tracker transport is selected by the scheme of the tracker url. The
+// results are in the order of the info hashes, those the tracker does not know
+// about are reported with no peers
+func Scrape(ctx context.Context, trackerUrl string, family Family, infoHashes [][]byte) ([]ScrapeResult, error) {
+	t, err := NewTracker(trackerUrl, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Scrape(ctx, infoHashes)
+}
+
+// scrapeBatches scrapes the info hashes in batches of at most size
+func scrapeBatches(infoHashes [][]byte, size int, scrape func(batch [][]byte) ([]ScrapeResult, error)) ([]ScrapeResult, error) {
+	results := make([]ScrapeResult, 0, len(infoHashes))
+	for start := 0; start < len(infoHashes); start += size {
+		end := min(start+size, len(infoHashes))
+		r, err := scrape(infoHashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r...)
+	}
+
+	return results, nil
+}
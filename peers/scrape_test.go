@@ -0,0 +1,184 @@
+package peers_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestScrapeURL(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		input  string
+		output string
+		fails  bool
+	}{
+		{
+			name:   "announce is replaced by scrape",
+			input:  "http://example.com/announce",
+			output: "http://example.com/scrape",
+		},
+		{
+			name:   "suffix and query are kept",
+			input:  "http://example.com/x/announce.php?passkey=abc",
+			output: "http://example.com/x/scrape.php?passkey=abc",
+		},
+		{
+			name:  "error when the last segment does not start with announce",
+			input: "http://example.com/a/announce/x",
+			fails: true,
+		},
+		{
+			name:  "error on path without announce",
+			input: "http://example.com/",
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := peers.ScrapeURL(tc.input)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tc.output {
+				t.Errorf("want %s, got %s", tc.output, got)
+			}
+		})
+	}
+}
+
+func testInfoHashes(n int) [][]byte {
+	hashes := make([][]byte, n)
+	for i := range hashes {
+		hashes[i] = make([]byte, 20)
+		binary.BigEndian.PutUint32(hashes[i], uint32(i+1))
+	}
+
+	return hashes
+}
+
+func TestScrape_HTTP(t *testing.T) {
+	t.Parallel()
+
+	infoHashes := testInfoHashes(60)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" {
+			http.NotFound(w, r)
+			return
+		}
+		requests.Add(1)
+
+		// the tracker only knows the odd info hashes
+		files := make(map[string]interface{})
+		for _, h := range r.URL.Query()["info_hash"] {
+			if n := binary.BigEndian.Uint32([]byte(h)); n%2 == 1 {
+				files[h] = map[string]int{"complete": int(n), "incomplete": 1, "downloaded": 2}
+			}
+		}
+
+		data, err := bencode.Marshal(map[string]interface{}{"files": files})
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(data)
+	}))
+	defer srv.Close()
+
+	got, err := peers.Scrape(context.Background(), srv.URL+"/announce", peers.FamilyAny, infoHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("want 2 requests, got %d", n)
+	}
+
+	want := make([]peers.ScrapeResult, len(infoHashes))
+	for i, h := range infoHashes {
+		want[i].InfoHash = h
+		if i%2 == 0 {
+			want[i].Seeders = i + 1
+			want[i].Leechers = 1
+			want[i].Completed = 2
+		}
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestScrape_HTTPFailure(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d14:failure reason12:unregisterede"))
+	}))
+	defer srv.Close()
+
+	_, err := peers.Scrape(context.Background(), srv.URL+"/announce", peers.FamilyAny, testInfoHashes(1))
+	if err == nil || !strings.Contains(err.Error(), "unregistered") {
+		t.Errorf("want tracker error, got %v", err)
+	}
+}
+
+func TestScrape_UDP(t *testing.T) {
+	t.Parallel()
+
+	infoHashes := testInfoHashes(80)
+
+	tr := newUDPTracker(t)
+	var scrapes atomic.Int32
+	tr.respond = func(req []byte) (uint32, []byte) {
+		scrapes.Add(1)
+
+		var body []byte
+		for h := req[16:]; len(h) >= 20; h = h[20:] {
+			n := binary.BigEndian.Uint32(h)
+			body = binary.BigEndian.AppendUint32(body, n) // seeders
+			body = binary.BigEndian.AppendUint32(body, 3) // completed
+			body = binary.BigEndian.AppendUint32(body, 4) // leechers
+		}
+		return 2, body
+	}
+	go tr.serve()
+
+	got, err := peers.Scrape(context.Background(), tr.URL(), peers.FamilyAny, infoHashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := scrapes.Load(); n != 2 {
+		t.Errorf("want 2 scrapes, got %d", n)
+	}
+
+	want := make([]peers.ScrapeResult, len(infoHashes))
+	for i, h := range infoHashes {
+		want[i] = peers.ScrapeResult{InfoHash: h, Seeders: i + 1, Leechers: 4, Completed: 3}
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
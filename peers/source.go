@@ -0,0 +1,41 @@
+package peers
+
+// Source is the mechanism through which a peer was discovered
+type Source int
+
+const (
+	// SourceTracker peers are returned by the trackers of the torrent
+	SourceTracker Source = iota
+	// SourceManual peers are given by the user, such as the peer addresses of a magnet link
+	SourceManual
+	// SourceDHT peers are found on the mainline DHT, as described in BEP 5
+	SourceDHT
+	// SourcePEX peers are sent by connected peers, as described in BEP 11
+	SourcePEX
+	// SourceLSD peers announce themselves on the local network, as described in BEP 14
+	SourceLSD
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceTracker:
+		return "tracker"
+	case SourceManual:
+		return "manual"
+	case SourceDHT:
+		return "dht"
+	case SourcePEX:
+		return "pex"
+	case SourceLSD:
+		return "lsd"
+	default:
+		return "unknown"
+	}
+}
+
+// Allowed reports whether peers discovered through the source may be used to
+// download a torrent. As described in BEP 27, private torrents only get their
+// peers from their own trackers
+func (s Source) Allowed(private bool) bool {
+	return !private || s == SourceTracker
+}
@@ -0,0 +1,69 @@
+package peers_test
+
+import (
+	"testing"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestSourceAllowed(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		source  peers.Source
+		private bool
+		output  bool
+	}{
+		{
+			name:    "tracker peers of a public torrent",
+			source:  peers.SourceTracker,
+			private: false,
+			output:  true,
+		},
+		{
+			name:    "manual peers of a public torrent",
+			source:  peers.SourceManual,
+			private: false,
+			output:  true,
+		},
+		{
+			name:    "tracker peers of a private torrent",
+			source:  peers.SourceTracker,
+			private: true,
+			output:  true,
+		},
+		{
+			name:    "manual peers of a private torrent",
+			source:  peers.SourceManual,
+			private: true,
+			output:  false,
+		},
+		{
+			name:    "dht peers of a private torrent",
+			source:  peers.SourceDHT,
+			private: true,
+			output:  false,
+		},
+		{
+			name:    "pex peers of a private torrent",
+			source:  peers.SourcePEX,
+			private: true,
+			output:  false,
+		},
+		{
+			name:    "lsd peers of a private torrent",
+			source:  peers.SourceLSD,
+			private: true,
+			output:  false,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.source.Allowed(tc.private); got != tc.output {
+				t.Errorf("want %v, got %v", tc.output, got)
+			}
+		})
+	}
+}
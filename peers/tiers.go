@@ -0,0 +1,173 @@
+package peers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultAnnounceTimeout bounds the announce to a single tracker, it leaves
+// room for two udp retransmissions before moving on to the next tracker
+const DefaultAnnounceTimeout = time.Minute
+
+// TrackerList holds tiers of tracker urls and implements the multitracker
+// semantics described in BEP 12
+type TrackerList struct {
+	mu    sync.Mutex
+	tiers [][]string
+	// trackerIDs are the tracker ids returned by the trackers, keyed by url
+	trackerIDs map[string]string
+	// trackers are the transports of the trackers announced to, keyed by url
+	trackers map[string]Tracker
+	// family is the address family the trackers and their peers are reached with
+	family Family
+
+	// Timeout bounds the announce to each tracker
+	Timeout time.Duration
+}
+
+// NewTrackerList creates a TrackerList from tiers of tracker urls reached
+// with the address family, the urls within each tier are shuffled
+func NewTrackerList(tiers [][]string, family Family) *TrackerList {
+	shuffled := make([][]string, len(tiers))
+	for i, tier := range tiers {
+		shuffled[i] = append([]string(nil), tier...)
+		rand.Shuffle(len(shuffled[i]), func(a, b int) {
+			shuffled[i][a], shuffled[i][b] = shuffled[i][b], shuffled[i][a]
+		})
+	}
+
+	return &TrackerList{
+		tiers:      shuffled,
+		trackerIDs: make(map[string]string),
+		trackers:   make(map[string]Tracker),
+		family:     family,
+		Timeout:    DefaultAnnounceTimeout,
+	}
+}
+
+// Tiers returns a copy of the current tracker order
+func (tl *TrackerList) Tiers() [][]string {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tiers := make([][]string, len(tl.tiers))
+	for i, tier := range tl.tiers {
+		tiers[i] = append([]string(nil), tier...)
+	}
+
+	return tiers
+}
+
+// Fetch tries the trackers tier by tier in order and returns the peers from
+// the first tracker that responds. The successful tracker is moved to the
+// front of its tier so that it is tried first on the next announce
+func (tl *TrackerList) Fetch(ctx context.Context, infoHash []byte, length int, peerID []byte) ([]Peer, error) {
+	resp, _, err := tl.Announce(ctx, AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   peerID,
+		Port:     DefaultPort,
+		Left:     int64(length),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Peers, nil
+}
+
+// Announce sends the announce to the trackers tier by tier in order like Fetch,
+// and returns the response along with the url of the tracker which sent it.
+// The tracker id of each tracker is kept and sent back on the next announces
+func (tl *TrackerList) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, string, error) {
+	var errs []error
+	for i, tier := range tl.Tiers() {
+		for _, trackerUrl := range tier {
+			if err := ctx.Err(); err != nil {
+				return nil, "", err
+			}
+
+			resp, err := tl.AnnounceTo(ctx, trackerUrl, req)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", trackerUrl, err))
+				continue
+			}
+
+			tl.promote(i, trackerUrl)
+			return resp, trackerUrl, nil
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("no tracker to announce to")
+	}
+
+	return nil, "", errors.Join(errs...)
+}
+
+// AnnounceTo sends the announce to a single tracker with its tracker id, giving up after Timeout
+func (tl *TrackerList) AnnounceTo(ctx context.Context, trackerUrl string, req AnnounceRequest) (*AnnounceResponse, error) {
+	t, err := tl.tracker(trackerUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	tl.mu.Lock()
+	req.TrackerID = tl.trackerIDs[trackerUrl]
+	tl.mu.Unlock()
+
+	if tl.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tl.Timeout)
+		defer cancel()
+	}
+
+	resp, err := t.Announce(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Peers = tl.family.Filter(resp.Peers)
+
+	if len(resp.TrackerID) > 0 {
+		tl.mu.Lock()
+		tl.trackerIDs[trackerUrl] = resp.TrackerID
+		tl.mu.Unlock()
+	}
+
+	return resp, nil
+}
+
+// tracker returns the transport of a tracker, which keeps its state across announces
+func (tl *TrackerList) tracker(trackerUrl string) (Tracker, error) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if t, ok := tl.trackers[trackerUrl]; ok {
+		return t, nil
+	}
+
+	t, err := NewTracker(trackerUrl, tl.family)
+	if err != nil {
+		return nil, err
+	}
+	tl.trackers[trackerUrl] = t
+
+	return t, nil
+}
+
+func (tl *TrackerList) promote(tierIndex int, trackerUrl string) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	tier := tl.tiers[tierIndex]
+	for j, u := range tier {
+		if u == trackerUrl {
+			copy(tier[1:j+1], tier[:j])
+			tier[0] = trackerUrl
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package peers_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestTrackerList_Fetch(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{100, 56, 58, 105, 110, 116, 101, 114, 118, 97, 108, 105, 53, 101, 53, 58, 112, 101, 101, 114, 115, 54, 58, 1, 2, 3, 4, 48, 57, 101})
+	}))
+	defer alive.Close()
+
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("garbage"))
+	}))
+	defer dead.Close()
+
+	want := []peers.Peer{
+		{
+			IP:   "1.2.3.4",
+			Port: 12345,
+		},
+	}
+
+	cases := []struct {
+		name  string
+		tiers [][]string
+		order [][]string
+		fails bool
+	}{
+		{
+			name:  "falls back to the next tier",
+			tiers: [][]string{{dead.URL}, {alive.URL}},
+			order: [][]string{{dead.URL}, {alive.URL}},
+			fails: false,
+		},
+		{
+			name:  "promotes the responding tracker within its tier",
+			tiers: [][]string{{dead.URL + "/a", alive.URL, dead.URL + "/b"}},
+			order: nil,
+			fails: false,
+		},
+		{
+			name:  "error when every tracker fails",
+			tiers: [][]string{{dead.URL}, {dead.URL + "/a"}},
+			order: [][]string{{dead.URL}, {dead.URL + "/a"}},
+			fails: true,
+		},
+		{
+			name:  "error on empty list",
+			tiers: nil,
+			order: [][]string{},
+			fails: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := peers.NewTrackerList(tc.tiers, peers.FamilyAny)
+
+			got, err := tl.Fetch(context.Background(), infoHash, 1000, peerID)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !cmp.Equal(got, want) {
+					t.Error(cmp.Diff(got, want))
+				}
+
+				// the responding tracker is always first in its tier afterwards
+				if tl.Tiers()[len(tl.Tiers())-1][0] != alive.URL {
+					t.Errorf("want %s promoted, got order %v", alive.URL, tl.Tiers())
+				}
+			}
+
+			if tc.order != nil && !cmp.Equal(tl.Tiers(), tc.order) {
+				t.Error(cmp.Diff(tl.Tiers(), tc.order))
+			}
+		})
+	}
+}
+
+func TestNewTrackerList_KeepsTiers(t *testing.T) {
+	t.Parallel()
+
+	tiers := [][]string{{"udp://a", "udp://b", "udp://c"}, {"http://d"}}
+	tl := peers.NewTrackerList(tiers, peers.FamilyAny)
+
+	got := tl.Tiers()
+	if len(got) != 2 || len(got[0]) != 3 || len(got[1]) != 1 || got[1][0] != "http://d" {
+		t.Errorf("unexpected tiers after shuffle: %v", got)
+	}
+
+	// the original tiers must not be modified by shuffling
+	if !cmp.Equal(tiers, [][]string{{"udp://a", "udp://b", "udp://c"}, {"http://d"}}) {
+		t.Errorf("input tiers modified: %v", tiers)
+	}
+}
+
+func TestTrackerList_Timeout(t *testing.T) {
+	t.Parallel()
+
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("d8:intervali5e5:peers6:\x01\x02\x03\x04\x30\x39e"))
+	}))
+	defer alive.Close()
+
+	// the silent tracker is given up on and the next tier is tried
+	tl := peers.NewTrackerList([][]string{{"udp://" + silent.LocalAddr().String()}, {alive.URL}}, peers.FamilyAny)
+	tl.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	got, err := tl.Fetch(context.Background(), make([]byte, 20), 1000, make([]byte, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []peers.Peer{{IP: "1.2.3.4", Port: 12345}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetch returned after %v, want the silent tracker to time out", elapsed)
+	}
+}
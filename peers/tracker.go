@@ -0,0 +1,77 @@
+package peers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Tracker is a transport to a tracker
+type Tracker interface {
+	// Announce sends an announce and returns the response of the tracker
+	Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error)
+	// Scrape requests the state of the swarms of the info hashes, the results
+	// are in the order of the info hashes
+	Scrape(ctx context.Context, infoHashes [][]byte) ([]ScrapeResult, error)
+}
+
+// TrackerFactory creates the Tracker of a tracker url, reached with the address family
+type TrackerFactory func(trackerUrl string, family Family) (Tracker, error)
+
+// ErrTrackerFailure is returned when a tracker rejects a request with a failure reason
+type ErrTrackerFailure struct {
+	Reason string
+}
+
+func (e *ErrTrackerFailure) Error() string {
+	return fmt.Sprintf("tracker error: %s", e.Reason)
+}
+
+// ErrTrackerStatus is returned when an http tracker responds with an unexpected status
+type ErrTrackerStatus struct {
+	StatusCode int
+}
+
+func (e *ErrTrackerStatus) Error() string {
+	return fmt.Sprintf("tracker responded with status %d", e.StatusCode)
+}
+
+// trackerRegistry holds the tracker factories keyed by url scheme
+var trackerRegistry = struct {
+	sync.RWMutex
+	factories map[string]TrackerFactory
+}{factories: map[string]TrackerFactory{
+	"http":  newHTTPTracker,
+	"https": newHTTPTracker,
+	"udp":   newUDPTracker,
+}}
+
+// RegisterTracker makes a tracker transport available for the urls of a
+// scheme, replacing the transport already registered for it
+func RegisterTracker(scheme string, factory TrackerFactory) {
+	trackerRegistry.Lock()
+	defer trackerRegistry.Unlock()
+
+	trackerRegistry.factories[strings.ToLower(scheme)] = factory
+}
+
+// NewTracker creates the tracker of a url with the transport registered for
+// its scheme, reached with the address family
+func NewTracker(trackerUrl string, family Family) (Tracker, error) {
+	u, err := url.Parse(trackerUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	trackerRegistry.RLock()
+	factory, ok := trackerRegistry.factories[strings.ToLower(u.Scheme)]
+	trackerRegistry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedTracker, u.Scheme)
+	}
+
+	return factory(trackerUrl, family)
+}
@@ -0,0 +1,230 @@
+package peers_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/peers"
+)
+
+func TestHTTPTracker_Announce(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		response []byte
+		status   int
+		gzip     bool
+		redirect bool
+		output   *peers.AnnounceResponse
+		failure  string
+		err      error
+		fails    bool
+	}{
+		{
+			name:     "warning message of a successful announce",
+			response: []byte("d8:intervali60e5:peers6:\x01\x02\x03\x04\x30\x3915:warning message9:slow downe"),
+			output: &peers.AnnounceResponse{
+				Peers:    []peers.Peer{{IP: "1.2.3.4", Port: 12345}},
+				Interval: time.Minute,
+				Warning:  "slow down",
+			},
+			fails: false,
+		},
+		{
+			name:     "gzip compressed response",
+			response: []byte("d8:intervali60e5:peers6:\x01\x02\x03\x04\x30\x39e"),
+			gzip:     true,
+			output: &peers.AnnounceResponse{
+				Peers:    []peers.Peer{{IP: "1.2.3.4", Port: 12345}},
+				Interval: time.Minute,
+			},
+			fails: false,
+		},
+		{
+			name:     "redirect to another announce url",
+			response: []byte("d8:intervali60e5:peers6:\x01\x02\x03\x04\x30\x39e"),
+			redirect: true,
+			output: &peers.AnnounceResponse{
+				Peers:    []peers.Peer{{IP: "1.2.3.4", Port: 12345}},
+				Interval: time.Minute,
+			},
+			fails: false,
+		},
+		{
+			name:     "failure reason with an error status",
+			response: []byte("d14:failure reason12:unregisterede"),
+			status:   http.StatusBadRequest,
+			failure:  "unregistered",
+			fails:    true,
+		},
+		{
+			name:     "error on a response other than a dictionary",
+			response: []byte("li1ei2ee"),
+			fails:    true,
+		},
+		{
+			name:     "error on a gzip response expanding past the limit",
+			response: append([]byte("d8:intervali60e5:peers"), bytes.Repeat([]byte("0"), 5<<20)...),
+			gzip:     true,
+			err:      peers.ErrTrackerResponseTooLarge,
+			fails:    true,
+		},
+		{
+			name:     "error on server error",
+			response: []byte("internal error"),
+			status:   http.StatusInternalServerError,
+			fails:    true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/old" {
+					http.Redirect(w, r, "/announce?"+r.URL.RawQuery, http.StatusFound)
+					return
+				}
+
+				if tc.gzip {
+					w.Header().Set("Content-Encoding", "gzip")
+				}
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+
+				if tc.gzip {
+					gz := gzip.NewWriter(w)
+					gz.Write(tc.response)
+					gz.Close()
+					return
+				}
+				w.Write(tc.response)
+			}))
+			defer srv.Close()
+
+			announceUrl := srv.URL + "/announce"
+			if tc.redirect {
+				announceUrl = srv.URL + "/old"
+			}
+
+			got, err := peers.NewHTTPTracker(announceUrl, peers.FamilyAny).Announce(context.Background(), peers.AnnounceRequest{
+				InfoHash: make([]byte, 20),
+				PeerID:   make([]byte, 20),
+				Port:     peers.DefaultPort,
+			})
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+
+				var failure *peers.ErrTrackerFailure
+				if tc.failure != "" && (!errors.As(err, &failure) || failure.Reason != tc.failure) {
+					t.Errorf("want failure reason %q, got %v", tc.failure, err)
+				}
+
+				if tc.err != nil && !errors.Is(err, tc.err) {
+					t.Errorf("want %v, got %v", tc.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.output, got) {
+				t.Error(cmp.Diff(tc.output, got))
+			}
+		})
+	}
+}
+
+// fakeTracker is a tracker transport of a custom scheme
+type fakeTracker struct {
+	peers []peers.Peer
+}
+
+func (ft *fakeTracker) Announce(ctx context.Context, req peers.AnnounceRequest) (*peers.AnnounceResponse, error) {
+	return &peers.AnnounceResponse{Peers: ft.peers, Interval: time.Minute}, nil
+}
+
+func (ft *fakeTracker) Scrape(ctx context.Context, infoHashes [][]byte) ([]peers.ScrapeResult, error) {
+	results := make([]peers.ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		results[i] = peers.ScrapeResult{InfoHash: infoHash, Seeders: len(ft.peers)}
+	}
+
+	return results, nil
+}
+
+func TestRegisterTracker(t *testing.T) {
+	t.Parallel()
+
+	want := []peers.Peer{{IP: "1.2.3.4", Port: 6881}}
+	peers.RegisterTracker("fake", func(trackerUrl string, family peers.Family) (peers.Tracker, error) {
+		return &fakeTracker{peers: want}, nil
+	})
+
+	infoHash := make([]byte, 20)
+	peerID := make([]byte, 20)
+
+	got, err := peers.NewTrackerList([][]string{{"fake://tracker.example.com"}}, peers.FamilyAny).Fetch(context.Background(), infoHash, 1000, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	results, err := peers.Scrape(context.Background(), "fake://tracker.example.com", peers.FamilyAny, [][]byte{infoHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Seeders != 1 {
+		t.Errorf("want one result with 1 seeder, got %v", results)
+	}
+
+	_, err = peers.NewTracker("unknown://tracker.example.com", peers.FamilyAny)
+	if !errors.Is(err, peers.ErrUnsupportedTracker) {
+		t.Errorf("want %v, got %v", peers.ErrUnsupportedTracker, err)
+	}
+}
+
+func TestUDPTracker_ContextDone(t *testing.T) {
+	t.Parallel()
+
+	silent, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	tr, err := peers.NewUDPTracker("udp://"+silent.LocalAddr().String(), peers.FamilyAny)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = tr.Announce(ctx, peers.AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20)})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("announce returned after %v, want it to end with the context", elapsed)
+	}
+}
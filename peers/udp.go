@@ -0,0 +1,373 @@
+package peers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// UDP tracker protocol as described in BEP 15
+const (
+	udpProtocolID     uint64 = 0x41727101980
+	udpActionConnect  uint32 = 0
+	udpActionAnnounce uint32 = 1
+	udpActionScrape   uint32 = 2
+	udpActionError    uint32 = 3
+
+	// a connection ID can be used for one minute after it was received
+	udpConnIDLifetime = time.Minute
+	// the request is retransmitted after 15 * 2 ^ n seconds, with n up to 8
+	// counted across the connect and the request it precedes
+	DefaultUDPRetransmitTimeout = 15 * time.Second
+	udpMaxRetransmits           = 8
+
+	udpMaxPacketSize = 2048
+)
+
+var (
+	ErrUDPTimeout = errors.New("udp tracker did not respond")
+)
+
+// connIDCache keeps the connection IDs obtained from udp trackers keyed by
+// tracker address, so subsequent announces can skip the connect step
+var connIDCache = struct {
+	sync.Mutex
+	ids map[string]cachedConnID
+}{ids: make(map[string]cachedConnID)}
+
+type cachedConnID struct {
+	id       uint64
+	obtained time.Time
+}
+
+// udpKey identifies this client to udp trackers across announces
+var udpKey = func() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}()
+
+// UDPTracker is a tracker reached over udp, as described in BEP 15. The
+// connection IDs it returns are kept across announces
+type UDPTracker struct {
+	host   string
+	family Family
+
+	// RetransmitTimeout is the wait for the first response, doubled after
+	// every retransmission
+	RetransmitTimeout time.Duration
+}
+
+// NewUDPTracker creates the tracker of a udp announce url, reached with the address family
+func NewUDPTracker(announceUrl string, family Family) (*UDPTracker, error) {
+	u, err := url.Parse(announceUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UDPTracker{
+		host:              u.Host,
+		family:            family,
+		RetransmitTimeout: DefaultUDPRetransmitTimeout,
+	}, nil
+}
+
+func newUDPTracker(trackerUrl string, family Family) (Tracker, error) {
+	return NewUDPTracker(trackerUrl, family)
+}
+
+// Announce announces to the tracker, retransmitting the requests until the
+// tracker responds or the context is done
+func (t *UDPTracker) Announce(ctx context.Context, req AnnounceRequest) (*AnnounceResponse, error) {
+	conn, err := dialUDPTracker(ctx, t.host, t.family, t.RetransmitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	return conn.announce(req)
+}
+
+// Scrape requests the state of the swarms of the info hashes from the tracker, in batches
+func (t *UDPTracker) Scrape(ctx context.Context, infoHashes [][]byte) ([]ScrapeResult, error) {
+	conn, err := dialUDPTracker(ctx, t.host, t.family, t.RetransmitTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.close()
+
+	return scrapeBatches(infoHashes, udpScrapeBatch, conn.scrape)
+}
+
+type udpTrackerConn struct {
+	conn *net.UDPConn
+	addr string
+	// ipv6 trackers reached over IPv6 return IPv6 peers
+	ipv6 bool
+	// timeout is the wait for the first response, doubled after every retransmission
+	timeout time.Duration
+
+	ctx  context.Context
+	stop func() bool
+}
+
+func dialUDPTracker(ctx context.Context, host string, family Family, timeout time.Duration) (*udpTrackerConn, error) {
+	network := family.Network("udp")
+	raddr, err := net.ResolveUDPAddr(network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialUDP(network, nil, raddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// a pending read ends as soon as the context is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+
+	return &udpTrackerConn{
+		conn:    conn,
+		addr:    raddr.String(),
+		ipv6:    raddr.IP.To4() == nil,
+		timeout: timeout,
+		ctx:     ctx,
+		stop:    stop,
+	}, nil
+}
+
+func (t *udpTrackerConn) close() error {
+	t.stop()
+	return t.conn.Close()
+}
+
+func (t *udpTrackerConn) announce(ar AnnounceRequest) (*AnnounceResponse, error) {
+	if len(ar.InfoHash) != 20 || len(ar.PeerID) != 20 {
+		return nil, fmt.Errorf("info hash and peer id must be 20 bytes long")
+	}
+
+	numWant := uint32(0xFFFFFFFF) // default
+	if ar.NumWant > 0 {
+		numWant = uint32(ar.NumWant)
+	}
+
+	// the request follows the connection ID, action and transaction ID
+	req := make([]byte, 82)
+	copy(req[0:20], ar.InfoHash)
+	copy(req[20:40], ar.PeerID)
+	binary.BigEndian.PutUint64(req[40:48], uint64(ar.Downloaded))
+	binary.BigEndian.PutUint64(req[48:56], uint64(ar.Left))
+	binary.BigEndian.PutUint64(req[56:64], uint64(ar.Uploaded))
+	binary.BigEndian.PutUint32(req[64:68], uint32(ar.Event))
+	binary.BigEndian.PutUint32(req[68:72], 0) // ip: the source address of the request
+	binary.BigEndian.PutUint32(req[72:76], udpKey)
+	binary.BigEndian.PutUint32(req[76:80], numWant)
+	binary.BigEndian.PutUint16(req[80:82], ar.Port)
+
+	resp, err := t.request(udpActionAnnounce, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// interval, leechers and seeders precede the peers
+	if len(resp) < 12 {
+		return nil, fmt.Errorf("announce response too short: %d bytes", len(resp))
+	}
+
+	parse := parseCompactPeers
+	if t.ipv6 {
+		parse = parseCompactPeers6
+	}
+
+	peers, err := parse(resp[12:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnnounceResponse{
+		Peers:    peers,
+		Interval: time.Duration(binary.BigEndian.Uint32(resp[0:4])) * time.Second,
+		Leechers: int(binary.BigEndian.Uint32(resp[4:8])),
+		Seeders:  int(binary.BigEndian.Uint32(resp[8:12])),
+	}, nil
+}
+
+func (t *udpTrackerConn) scrape(infoHashes [][]byte) ([]ScrapeResult, error) {
+	if len(infoHashes) > udpScrapeBatch {
+		return nil, fmt.Errorf("at most %d info hashes can be scraped at once", udpScrapeBatch)
+	}
+
+	req := make([]byte, 0, 20*len(infoHashes))
+	for _, infoHash := range infoHashes {
+		if len(infoHash) != 20 {
+			return nil, fmt.Errorf("info hash must be 20 bytes long")
+		}
+		req = append(req, infoHash...)
+	}
+
+	resp, err := t.request(udpActionScrape, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// seeders, completed and leechers of every info hash in order
+	if len(resp) < 12*len(infoHashes) {
+		return nil, fmt.Errorf("scrape response too short: %d bytes", len(resp))
+	}
+
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		entry := resp[i*12 : (i+1)*12]
+		results[i] = ScrapeResult{
+			InfoHash:  infoHash,
+			Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+			Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+			Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+		}
+	}
+
+	return results, nil
+}
+
+// request sends a request of an action with the body following its header,
+// retransmitting it until the tracker responds. A single retransmission
+// counter is shared by the request and the connects it needs, so a tracker
+// is given up on after the same number of transmissions either way
+func (t *udpTrackerConn) request(action uint32, body []byte) ([]byte, error) {
+	for n := 0; n <= udpMaxRetransmits; n++ {
+		// the connection ID may expire while waiting for a response, so
+		// it is obtained again for every transmission
+		connID, err := t.connectionID(n)
+		if errors.Is(err, ErrUDPTimeout) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		tid := newTransactionID()
+		req := make([]byte, 16, 16+len(body))
+		binary.BigEndian.PutUint64(req[0:8], connID)
+		binary.BigEndian.PutUint32(req[8:12], action)
+		binary.BigEndian.PutUint32(req[12:16], tid)
+		req = append(req, body...)
+
+		resp, err := t.exchange(req, tid, action, n)
+		if errors.Is(err, ErrUDPTimeout) {
+			continue
+		}
+
+		return resp, err
+	}
+
+	return nil, ErrUDPTimeout
+}
+
+// connectionID returns a cached connection ID for the tracker if it is still
+// valid, otherwise it performs the connect exchange as the n-th transmission
+func (t *udpTrackerConn) connectionID(n int) (uint64, error) {
+	connIDCache.Lock()
+	cached, ok := connIDCache.ids[t.addr]
+	connIDCache.Unlock()
+
+	if ok && time.Since(cached.obtained) < udpConnIDLifetime {
+		return cached.id, nil
+	}
+
+	tid := newTransactionID()
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:8], udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:12], udpActionConnect)
+	binary.BigEndian.PutUint32(req[12:16], tid)
+
+	resp, err := t.exchange(req, tid, udpActionConnect, n)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(resp) < 8 {
+		return 0, fmt.Errorf("connect response too short: %d bytes", len(resp))
+	}
+
+	id := binary.BigEndian.Uint64(resp[0:8])
+
+	connIDCache.Lock()
+	connIDCache.ids[t.addr] = cachedConnID{
+		id:       id,
+		obtained: time.Now(),
+	}
+	connIDCache.Unlock()
+
+	return id, nil
+}
+
+// exchange sends a request and waits for the matching response for the
+// duration of the n-th retransmission timeout. It returns the response body
+// following the action and transaction ID, or ErrUDPTimeout if no matching
+// response arrived in time
+func (t *udpTrackerConn) exchange(req []byte, tid uint32, action uint32, n int) ([]byte, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := t.conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(t.timeout << n)
+	if d, ok := t.ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := t.conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		nr, err := t.conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if err := t.ctx.Err(); err != nil {
+					return nil, err
+				}
+				return nil, ErrUDPTimeout
+			}
+			return nil, err
+		}
+
+		// responses that are too short or belong to another transaction are ignored
+		if nr < 8 || binary.BigEndian.Uint32(buf[4:8]) != tid {
+			continue
+		}
+
+		respAction := binary.BigEndian.Uint32(buf[0:4])
+		body := buf[8:nr]
+		switch respAction {
+		case action:
+			return body, nil
+		case udpActionError:
+			// a connection ID the tracker rejected should not be reused
+			connIDCache.Lock()
+			delete(connIDCache.ids, t.addr)
+			connIDCache.Unlock()
+			return nil, &ErrTrackerFailure{Reason: string(body)}
+		default:
+			return nil, fmt.Errorf("unexpected action in tracker response: want %d, got %d", action, respAction)
+		}
+	}
+}
+
+func newTransactionID() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint32(b[:])
+}
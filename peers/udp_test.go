@@ -0,0 +1,348 @@
+package peers_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/peers"
+)
+
+// udpTracker is a local stand-in for a BEP 15 tracker
+type udpTracker struct {
+	conn     net.PacketConn
+	connects atomic.Int32
+	connID   uint64
+	// respond produces the announce or scrape response body following the action
+	// and transaction ID
+	respond func(req []byte) (action uint32, body []byte)
+	// sendStale sends a response with a wrong transaction ID before each real one
+	sendStale bool
+	// drop ignores the packets it reports true for, by order of arrival
+	drop     func(packet int) bool
+	received atomic.Int32
+}
+
+func newUDPTracker(t *testing.T) *udpTracker {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &udpTracker{
+		conn:   conn,
+		connID: 0xDEADBEEF,
+	}
+}
+
+func (tr *udpTracker) URL() string {
+	return "udp://" + tr.conn.LocalAddr().String() + "/announce"
+}
+
+func (tr *udpTracker) serve() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := tr.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req := buf[:n]
+		if packet := int(tr.received.Add(1)) - 1; tr.drop != nil && tr.drop(packet) {
+			continue
+		}
+		if n < 16 {
+			continue
+		}
+
+		action := binary.BigEndian.Uint32(req[8:12])
+		tid := binary.BigEndian.Uint32(req[12:16])
+
+		var respAction uint32
+		var body []byte
+		switch action {
+		case 0:
+			if binary.BigEndian.Uint64(req[0:8]) != 0x41727101980 {
+				continue
+			}
+			tr.connects.Add(1)
+			respAction = 0
+			body = binary.BigEndian.AppendUint64(nil, tr.connID)
+		case 1, 2:
+			if binary.BigEndian.Uint64(req[0:8]) != tr.connID {
+				respAction = 3
+				body = []byte("invalid connection id")
+				break
+			}
+			respAction, body = tr.respond(req)
+		}
+
+		if tr.sendStale {
+			stale := binary.BigEndian.AppendUint32(nil, respAction)
+			stale = binary.BigEndian.AppendUint32(stale, tid+1)
+			tr.conn.WriteTo(append(stale, body...), addr)
+		}
+
+		resp := binary.BigEndian.AppendUint32(nil, respAction)
+		resp = binary.BigEndian.AppendUint32(resp, tid)
+		tr.conn.WriteTo(append(resp, body...), addr)
+	}
+}
+
+func TestFetchUDP(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	announceOK := func(req []byte) (uint32, []byte) {
+		body := []byte{
+			0, 0, 7, 8, // interval
+			0, 0, 0, 1, // leechers
+			0, 0, 0, 1, // seeders
+			1, 2, 3, 4, 48, 57,
+			5, 6, 7, 8, 221, 213,
+		}
+		return 1, body
+	}
+
+	cases := []struct {
+		name      string
+		respond   func(req []byte) (uint32, []byte)
+		sendStale bool
+		output    []peers.Peer
+		fails     bool
+	}{
+		{
+			name:    "correctly announces and decodes peers",
+			respond: announceOK,
+			output: []peers.Peer{
+				{
+					IP:   "1.2.3.4",
+					Port: 12345,
+				},
+				{
+					IP:   "5.6.7.8",
+					Port: 56789,
+				},
+			},
+			fails: false,
+		},
+		{
+			name:      "ignores responses with a different transaction id",
+			respond:   announceOK,
+			sendStale: true,
+			output: []peers.Peer{
+				{
+					IP:   "1.2.3.4",
+					Port: 12345,
+				},
+				{
+					IP:   "5.6.7.8",
+					Port: 56789,
+				},
+			},
+			fails: false,
+		},
+		{
+			name: "sends announce fields at the offsets described by the spec",
+			respond: func(req []byte) (uint32, []byte) {
+				if len(req) != 98 || !cmp.Equal(req[16:36], infoHash) || !cmp.Equal(req[36:56], peerID) ||
+					binary.BigEndian.Uint64(req[64:72]) != 1000 || binary.BigEndian.Uint16(req[96:98]) != 6881 {
+					return 3, []byte("malformed announce")
+				}
+				return announceOK(req)
+			},
+			output: []peers.Peer{
+				{
+					IP:   "1.2.3.4",
+					Port: 12345,
+				},
+				{
+					IP:   "5.6.7.8",
+					Port: 56789,
+				},
+			},
+			fails: false,
+		},
+		{
+			name: "error on tracker error action",
+			respond: func(req []byte) (uint32, []byte) {
+				return 3, []byte("torrent not registered")
+			},
+			output: nil,
+			fails:  true,
+		},
+		{
+			name: "error on truncated announce response",
+			respond: func(req []byte) (uint32, []byte) {
+				return 1, []byte{0, 0, 7, 8}
+			},
+			output: nil,
+			fails:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newUDPTracker(t)
+			tr.respond = tc.respond
+			tr.sendStale = tc.sendStale
+			go tr.serve()
+
+			got, err := peers.Fetch(tr.URL(), peers.FamilyAny, infoHash, 1000, peerID)
+			if tc.fails {
+				if err == nil {
+					t.Fatal("expect error, got nil")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !cmp.Equal(got, tc.output) {
+					t.Error(cmp.Diff(got, tc.output))
+				}
+			}
+		})
+	}
+}
+
+func TestFetchUDP_IPv6(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	tr := &udpTracker{conn: conn, connID: 0xDEADBEEF}
+	tr.respond = func(req []byte) (uint32, []byte) {
+		body := []byte{
+			0, 0, 7, 8, // interval
+			0, 0, 0, 0, // leechers
+			0, 0, 0, 1, // seeders
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 48, 57,
+		}
+		return 1, body
+	}
+	go tr.serve()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	// trackers reached over IPv6 return 18 byte peer entries
+	got, err := peers.Fetch(tr.URL(), peers.FamilyAny, infoHash, 1000, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []peers.Peer{{IP: "2001:db8::1", Port: 12345}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestFetchUDP_ReusesConnectionID(t *testing.T) {
+	t.Parallel()
+
+	infoHash := []byte{214, 159, 145, 230, 178, 174, 76, 84, 36, 104, 209, 7, 58, 113, 212, 234, 19, 135, 154, 127}
+	peerID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+
+	tr := newUDPTracker(t)
+	tr.respond = func(req []byte) (uint32, []byte) {
+		return 1, make([]byte, 12)
+	}
+	go tr.serve()
+
+	for range 3 {
+		if _, err := peers.Fetch(tr.URL(), peers.FamilyAny, infoHash, 1000, peerID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := tr.connects.Load(); got != 1 {
+		t.Errorf("want 1 connect request, got %d", got)
+	}
+}
+
+func TestUDPTracker_Retransmit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		drop func(packet int) bool
+		// packets is the number of packets the tracker receives
+		packets int32
+		err     error
+	}{
+		{
+			name:    "retransmit the connect",
+			drop:    func(packet int) bool { return packet < 2 },
+			packets: 4,
+		},
+		{
+			name:    "retransmit the announce after the connect",
+			drop:    func(packet int) bool { return packet == 1 || packet == 2 },
+			packets: 4,
+		},
+		{
+			// the connect times out twice, leaving the announce 7 transmissions
+			name:    "error after the timeouts shared by connect and announce",
+			drop:    func(packet int) bool { return packet != 2 },
+			packets: 10,
+			err:     peers.ErrUDPTimeout,
+		},
+		{
+			name:    "error when the connect is never answered",
+			drop:    func(packet int) bool { return true },
+			packets: 9,
+			err:     peers.ErrUDPTimeout,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := newUDPTracker(t)
+			tr.drop = tc.drop
+			tr.respond = func(req []byte) (uint32, []byte) {
+				return 1, make([]byte, 12)
+			}
+			go tr.serve()
+
+			ut, err := peers.NewUDPTracker(tr.URL(), peers.FamilyAny)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ut.RetransmitTimeout = 2 * time.Millisecond
+
+			_, err = ut.Announce(context.Background(), peers.AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20), Port: 6881})
+			if !errors.Is(err, tc.err) {
+				t.Errorf("want error %v, got %v", tc.err, err)
+			}
+
+			if got := tr.received.Load(); got != tc.packets {
+				t.Errorf("want %d packets, got %d", tc.packets, got)
+			}
+		})
+	}
+}
+
+func TestFetch_UnsupportedScheme(t *testing.T) {
+	t.Parallel()
+
+	_, err := peers.Fetch("wss://tracker.example.com/announce", peers.FamilyAny, make([]byte, 20), 1000, make([]byte, 20))
+	if !errors.Is(err, peers.ErrUnsupportedTracker) {
+		t.Errorf("unexpected error, want %v got %v", peers.ErrUnsupportedTracker, err)
+	}
+}
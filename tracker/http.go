@@ -0,0 +1,170 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/peers"
+)
+
+// ServeHTTP serves the announces on /announce, the scrapes on /scrape and the
+// stats of the tracker as JSON on /stats
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/announce":
+		s.serveAnnounce(w, r)
+	case "/scrape":
+		s.serveScrape(w, r)
+	case "/stats":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Stats())
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveAnnounce(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	ip, err := remoteIP(r)
+	if err != nil {
+		s.writeFailure(w, "invalid remote address")
+		return
+	}
+
+	port, err := strconv.ParseUint(q.Get("port"), 10, 16)
+	if err != nil {
+		s.writeFailure(w, "invalid port")
+		return
+	}
+
+	var left int64
+	if q.Has("left") {
+		if left, err = strconv.ParseInt(q.Get("left"), 10, 64); err != nil || left < 0 {
+			s.writeFailure(w, "invalid left")
+			return
+		}
+	}
+
+	var numWant int
+	if q.Has("numwant") {
+		if numWant, err = strconv.Atoi(q.Get("numwant")); err != nil {
+			s.writeFailure(w, "invalid numwant")
+			return
+		}
+	}
+
+	event, err := parseEvent(q.Get("event"))
+	if err != nil {
+		s.writeFailure(w, err.Error())
+		return
+	}
+
+	resp, err := s.Announce(AnnounceRequest{
+		InfoHash: []byte(q.Get("info_hash")),
+		PeerID:   []byte(q.Get("peer_id")),
+		IP:       ip,
+		Port:     uint16(port),
+		Left:     left,
+		Event:    event,
+		NumWant:  numWant,
+	})
+	if err != nil {
+		s.writeFailure(w, err.Error())
+		return
+	}
+
+	body := map[string]interface{}{
+		"interval":     int(s.Interval.Seconds()),
+		"min interval": int(s.Interval.Seconds() / 2),
+		"complete":     resp.Seeders,
+		"incomplete":   resp.Leechers,
+	}
+
+	// compact peers are returned unless the client asks otherwise, as described in BEP 23
+	if q.Get("compact") == "0" {
+		list := make([]map[string]interface{}, 0, len(resp.Peers))
+		for _, p := range resp.Peers {
+			entry := map[string]interface{}{"ip": p.IP, "port": int(p.Port)}
+			if q.Get("no_peer_id") != "1" {
+				entry["peer id"] = p.ID
+			}
+			list = append(list, entry)
+		}
+		body["peers"] = list
+	} else {
+		body["peers"] = string(peers.Compact(resp.Peers, false))
+		if peers6 := peers.Compact(resp.Peers, true); len(peers6) > 0 {
+			body["peers6"] = string(peers6)
+		}
+	}
+
+	s.writeBencode(w, body)
+}
+
+func (s *Server) serveScrape(w http.ResponseWriter, r *http.Request) {
+	var infoHashes [][]byte
+	for _, infoHash := range r.URL.Query()["info_hash"] {
+		if len(infoHash) != 20 {
+			s.writeFailure(w, "info hash must be 20 bytes long")
+			return
+		}
+		infoHashes = append(infoHashes, []byte(infoHash))
+	}
+
+	files := make(map[string]interface{})
+	for _, result := range s.Scrape(infoHashes) {
+		files[string(result.InfoHash)] = map[string]interface{}{
+			"complete":   result.Seeders,
+			"incomplete": result.Leechers,
+			"downloaded": result.Completed,
+		}
+	}
+
+	s.writeBencode(w, map[string]interface{}{"files": files})
+}
+
+// parseEvent parses the event of an http announce
+func parseEvent(s string) (peers.Event, error) {
+	for _, e := range []peers.Event{peers.EventNone, peers.EventCompleted, peers.EventStarted, peers.EventStopped} {
+		if s == e.String() {
+			return e, nil
+		}
+	}
+
+	return peers.EventNone, ErrInvalidEvent
+}
+
+// remoteIP returns the address an http request came from
+func remoteIP(r *http.Request) (net.IP, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: host}
+	}
+
+	return ip, nil
+}
+
+func (s *Server) writeFailure(w http.ResponseWriter, reason string) {
+	s.writeBencode(w, map[string]interface{}{"failure reason": reason})
+}
+
+func (s *Server) writeBencode(w http.ResponseWriter, v interface{}) {
+	b, err := bencode.Marshal(v)
+	if err != nil {
+		s.logger.Error("failed to encode tracker response", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write(b)
+}
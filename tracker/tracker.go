@@ -0,0 +1,327 @@
+// Package tracker implements a BitTorrent tracker serving the announces and
+// scrapes of the http protocol described in BEP 3, with the compact peers of
+// BEP 23 and BEP 7, and of the udp protocol described in BEP 15. The swarms
+// are kept in memory and the peers which stop announcing expire
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+const (
+	// DefaultInterval is the time peers are asked to wait between announces
+	DefaultInterval = 30 * time.Minute
+	// DefaultNumWant is the number of peers returned when an announce does not tell
+	DefaultNumWant = 50
+	// DefaultMaxSwarms is the default number of info hashes the tracker keeps swarms for
+	DefaultMaxSwarms = 100000
+	// maxNumWant bounds the number of peers returned by an announce
+	maxNumWant = 200
+	// expireInterval is the time between two removals of the expired peers
+	expireInterval = time.Minute
+)
+
+var (
+	ErrNotAllowed    = errors.New("torrent not allowed on this tracker")
+	ErrInvalidEvent  = errors.New("invalid event")
+	ErrTooManySwarms = errors.New("tracker is full, try again later")
+)
+
+// AnnounceRequest is an announce received by the tracker
+type AnnounceRequest struct {
+	InfoHash []byte
+	PeerID   []byte
+	// IP is the address the announce came from
+	IP   net.IP
+	Port uint16
+	Left int64
+	// Event is the event of the announce, as defined by the udp protocol
+	Event peers.Event
+	// NumWant is the number of peers requested, DefaultNumWant when not positive
+	NumWant int
+}
+
+// AnnounceResponse is the answer of the tracker to an announce
+type AnnounceResponse struct {
+	Peers    []peers.Peer
+	Seeders  int
+	Leechers int
+}
+
+// Stats are the counters of the tracker
+type Stats struct {
+	Torrents  int   `json:"torrents"`
+	Seeders   int   `json:"seeders"`
+	Leechers  int   `json:"leechers"`
+	Announces int64 `json:"announces"`
+	Scrapes   int64 `json:"scrapes"`
+}
+
+// swarm holds the peers of an info hash, keyed by address
+type swarm struct {
+	peers     map[string]*swarmPeer
+	completed int
+}
+
+type swarmPeer struct {
+	peer     peers.Peer
+	left     int64
+	lastSeen time.Time
+}
+
+// Server is a tracker
+type Server struct {
+	logger *slog.Logger
+
+	// Interval is the time peers are asked to wait between announces, peers
+	// which did not announce for twice the interval expire
+	Interval time.Duration
+	// MaxSwarms bounds the number of swarms, the announces of new info hashes
+	// fail once it is reached
+	MaxSwarms int
+
+	mu        sync.Mutex
+	swarms    map[string]*swarm
+	allowed   map[string]bool
+	announces int64
+	scrapes   int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New creates a tracker serving every info hash until some are allowed with Allow
+func New(logger *slog.Logger) *Server {
+	s := &Server{
+		logger:    logger,
+		Interval:  DefaultInterval,
+		MaxSwarms: DefaultMaxSwarms,
+		swarms:    make(map[string]*swarm),
+		allowed:   make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	go s.maintain()
+
+	return s
+}
+
+// Allow adds info hashes to the allowlist, once it is not empty the tracker
+// only serves the info hashes it holds
+func (s *Server) Allow(infoHashes ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, infoHash := range infoHashes {
+		s.allowed[string(infoHash)] = true
+	}
+}
+
+// Close stops the removal of expired peers
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+
+	return nil
+}
+
+// Announce records the peer of an announce in the swarm of its info hash and
+// returns other peers of the swarm, in random order
+func (s *Server) Announce(req AnnounceRequest) (*AnnounceResponse, error) {
+	if len(req.InfoHash) != 20 || len(req.PeerID) != 20 {
+		return nil, fmt.Errorf("info hash and peer id must be 20 bytes long")
+	}
+
+	if req.Port == 0 {
+		return nil, fmt.Errorf("invalid port")
+	}
+
+	if req.Event > peers.EventStopped {
+		return nil, ErrInvalidEvent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isAllowed(req.InfoHash) {
+		return nil, ErrNotAllowed
+	}
+	s.announces++
+
+	sw, ok := s.swarms[string(req.InfoHash)]
+	if !ok {
+		// a stopped peer does not join the swarm, which would be left empty
+		if req.Event == peers.EventStopped {
+			return &AnnounceResponse{}, nil
+		}
+		if len(s.swarms) >= s.MaxSwarms {
+			return nil, ErrTooManySwarms
+		}
+		sw = &swarm{peers: make(map[string]*swarmPeer)}
+		s.swarms[string(req.InfoHash)] = sw
+	}
+
+	peer := peers.Peer{ID: string(req.PeerID), IP: req.IP.String(), Port: req.Port}
+	switch req.Event {
+	case peers.EventStopped:
+		delete(sw.peers, peer.Addr())
+	case peers.EventCompleted:
+		sw.completed++
+		fallthrough
+	default:
+		sw.peers[peer.Addr()] = &swarmPeer{peer: peer, left: req.Left, lastSeen: time.Now()}
+	}
+
+	numWant := req.NumWant
+	if numWant <= 0 {
+		numWant = DefaultNumWant
+	}
+	numWant = min(numWant, maxNumWant)
+
+	now := time.Now()
+	resp := &AnnounceResponse{}
+	for addr, sp := range sw.peers {
+		if s.expired(sp, now) {
+			delete(sw.peers, addr)
+			continue
+		}
+
+		if sp.left == 0 {
+			resp.Seeders++
+		} else {
+			resp.Leechers++
+		}
+
+		if addr != peer.Addr() {
+			resp.Peers = append(resp.Peers, sp.peer)
+		}
+	}
+
+	rand.Shuffle(len(resp.Peers), func(i, j int) {
+		resp.Peers[i], resp.Peers[j] = resp.Peers[j], resp.Peers[i]
+	})
+	resp.Peers = resp.Peers[:min(len(resp.Peers), numWant)]
+
+	if len(sw.peers) == 0 {
+		delete(s.swarms, string(req.InfoHash))
+	}
+
+	return resp, nil
+}
+
+// Scrape returns the state of the swarms of the info hashes, or of every swarm
+// when none is given. The info hashes the tracker does not serve are left out
+func (s *Server) Scrape(infoHashes [][]byte) []peers.ScrapeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scrapes++
+
+	if len(infoHashes) == 0 {
+		for infoHash := range s.swarms {
+			infoHashes = append(infoHashes, []byte(infoHash))
+		}
+	}
+
+	var results []peers.ScrapeResult
+	for _, infoHash := range infoHashes {
+		if !s.isAllowed(infoHash) {
+			continue
+		}
+
+		result := peers.ScrapeResult{InfoHash: infoHash}
+		if sw, ok := s.swarms[string(infoHash)]; ok {
+			for _, sp := range sw.peers {
+				if sp.left == 0 {
+					result.Seeders++
+				} else {
+					result.Leechers++
+				}
+			}
+			result.Completed = sw.completed
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Stats returns the counters of the tracker
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := Stats{
+		Torrents:  len(s.swarms),
+		Announces: s.announces,
+		Scrapes:   s.scrapes,
+	}
+	for _, sw := range s.swarms {
+		for _, sp := range sw.peers {
+			if sp.left == 0 {
+				stats.Seeders++
+			} else {
+				stats.Leechers++
+			}
+		}
+	}
+
+	return stats
+}
+
+// isAllowed reports whether the tracker serves an info hash, it must be called with mu held
+func (s *Server) isAllowed(infoHash []byte) bool {
+	return len(s.allowed) == 0 || s.allowed[string(infoHash)]
+}
+
+// expired reports whether a peer did not announce for twice the interval
+func (s *Server) expired(sp *swarmPeer, now time.Time) bool {
+	return now.Sub(sp.lastSeen) > 2*s.Interval
+}
+
+// maintain removes the expired peers until the tracker is closed
+func (s *Server) maintain() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.expire(time.Now())
+		}
+	}
+}
+
+// expire removes the peers which did not announce for twice the interval
+func (s *Server) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired int
+	for infoHash, sw := range s.swarms {
+		for addr, sp := range sw.peers {
+			if s.expired(sp, now) {
+				delete(sw.peers, addr)
+				expired++
+			}
+		}
+
+		if len(sw.peers) == 0 {
+			delete(s.swarms, infoHash)
+		}
+	}
+
+	if expired > 0 {
+		s.logger.Info("expired peers", slog.Int("count", expired))
+	}
+}
@@ -0,0 +1,284 @@
+package tracker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kanowfy/btor/bencode"
+	"github.com/kanowfy/btor/peers"
+	"github.com/kanowfy/btor/tracker"
+)
+
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+var (
+	infoHash = []byte("aaaaaaaaaaaaaaaaaaaa")
+	peerID1  = []byte("-XX0000-000000000001")
+	peerID2  = []byte("-XX0000-000000000002")
+)
+
+// announceFrom announces a peer listening on port to a tracker
+func announceFrom(t *testing.T, trackerUrl string, peerID []byte, port uint16, left int64, event peers.Event) *peers.AnnounceResponse {
+	t.Helper()
+
+	resp, err := peers.Announce(trackerUrl, peers.FamilyAny, peers.AnnounceRequest{
+		InfoHash: infoHash,
+		PeerID:   peerID,
+		Port:     port,
+		Left:     left,
+		Event:    event,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func TestServer_HTTP(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	announceUrl := srv.URL + "/announce"
+
+	if got := announceFrom(t, announceUrl, peerID1, 6881, 0, peers.EventStarted); len(got.Peers) != 0 {
+		t.Errorf("want no peers for the first peer, got %v", got.Peers)
+	}
+
+	got := announceFrom(t, announceUrl, peerID2, 6882, 1000, peers.EventStarted)
+	want := &peers.AnnounceResponse{
+		Peers:       []peers.Peer{{IP: "127.0.0.1", Port: 6881}},
+		Interval:    tracker.DefaultInterval,
+		MinInterval: tracker.DefaultInterval / 2,
+		Seeders:     1,
+		Leechers:    1,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	// clients asking for non compact peers get the peer ids
+	resp, err := http.Get(fmt.Sprintf("%s?info_hash=%s&peer_id=%s&port=6883&compact=0", announceUrl, url.QueryEscape(string(infoHash)), peerID2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var nonCompact struct {
+		Peers []peers.Peer `bencode:"peers"`
+	}
+	if err = bencode.NewDecoder(resp.Body).Decode(&nonCompact); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nonCompact.Peers) != 2 {
+		t.Fatalf("want 2 peers, got %v", nonCompact.Peers)
+	}
+	for _, p := range nonCompact.Peers {
+		if p.ID != string(peerID1) && p.ID != string(peerID2) {
+			t.Errorf("unexpected peer id %q", p.ID)
+		}
+	}
+
+	// a stopped peer leaves the swarm, a completed one is counted
+	announceFrom(t, announceUrl, peerID2, 6883, 0, peers.EventStopped)
+	announceFrom(t, announceUrl, peerID2, 6882, 0, peers.EventCompleted)
+
+	results, err := peers.Scrape(context.Background(), announceUrl, peers.FamilyAny, [][]byte{infoHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantResults := []peers.ScrapeResult{{InfoHash: infoHash, Seeders: 2, Completed: 1}}
+	if !cmp.Equal(wantResults, results) {
+		t.Error(cmp.Diff(wantResults, results))
+	}
+
+	wantStats := tracker.Stats{Torrents: 1, Seeders: 2, Announces: 5, Scrapes: 1}
+	if got := s.Stats(); got != wantStats {
+		t.Errorf("want stats %+v, got %+v", wantStats, got)
+	}
+}
+
+func TestServer_HTTPPeers6(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "[::]:0")
+	if err != nil {
+		t.Skipf("dual stack not available: %v", err)
+	}
+
+	s := tracker.New(logger)
+	defer s.Close()
+
+	srv := &http.Server{Handler: s}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	announce6 := fmt.Sprintf("http://[::1]:%d/announce", port)
+	announce4 := fmt.Sprintf("http://127.0.0.1:%d/announce", port)
+
+	if _, err := peers.Announce(announce6, peers.FamilyAny, peers.AnnounceRequest{InfoHash: infoHash, PeerID: peerID1, Port: 6881}); err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+
+	got := announceFrom(t, announce4, peerID2, 6882, 1000, peers.EventStarted)
+	want := []peers.Peer{{IP: "::1", Port: 6881}}
+	if !cmp.Equal(want, got.Peers) {
+		t.Error(cmp.Diff(want, got.Peers))
+	}
+}
+
+func TestServer_UDP(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go s.ServeUDP(conn)
+
+	announceUrl := "udp://" + conn.LocalAddr().String() + "/announce"
+
+	announceFrom(t, announceUrl, peerID1, 6881, 0, peers.EventStarted)
+	got := announceFrom(t, announceUrl, peerID2, 6882, 1000, peers.EventStarted)
+
+	want := &peers.AnnounceResponse{
+		Peers:    []peers.Peer{{IP: "127.0.0.1", Port: 6881}},
+		Interval: tracker.DefaultInterval,
+		Seeders:  1,
+		Leechers: 1,
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+
+	unknown := []byte("bbbbbbbbbbbbbbbbbbbb")
+	results, err := peers.Scrape(context.Background(), announceUrl, peers.FamilyAny, [][]byte{infoHash, unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantResults := []peers.ScrapeResult{
+		{InfoHash: infoHash, Seeders: 1, Leechers: 1},
+		{InfoHash: unknown},
+	}
+	if !cmp.Equal(wantResults, results) {
+		t.Error(cmp.Diff(wantResults, results))
+	}
+}
+
+func TestServer_Allow(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+	s.Allow([]byte("bbbbbbbbbbbbbbbbbbbb"))
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, err := peers.Announce(srv.URL+"/announce", peers.FamilyAny, peers.AnnounceRequest{InfoHash: infoHash, PeerID: peerID1, Port: 6881})
+
+	var failure *peers.ErrTrackerFailure
+	if !errors.As(err, &failure) || failure.Reason != tracker.ErrNotAllowed.Error() {
+		t.Errorf("want failure reason %q, got %v", tracker.ErrNotAllowed, err)
+	}
+}
+
+func TestServer_Expiry(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+	s.Interval = 50 * time.Millisecond
+
+	ip := net.ParseIP("127.0.0.1")
+	if _, err := s.Announce(tracker.AnnounceRequest{InfoHash: infoHash, PeerID: peerID1, IP: ip, Port: 6881, Left: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first peer did not announce for twice the interval
+	time.Sleep(150 * time.Millisecond)
+
+	got, err := s.Announce(tracker.AnnounceRequest{InfoHash: infoHash, PeerID: peerID2, IP: ip, Port: 6882, Left: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Peers) != 0 || got.Leechers != 1 {
+		t.Errorf("want the first peer expired, got %+v", got)
+	}
+}
+
+func TestServer_RemovesEmptySwarms(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+
+	ip := net.ParseIP("127.0.0.1")
+	announces := []tracker.AnnounceRequest{
+		{InfoHash: infoHash, PeerID: peerID1, IP: ip, Port: 6881, Event: peers.EventCompleted},
+		{InfoHash: infoHash, PeerID: peerID1, IP: ip, Port: 6881, Event: peers.EventStopped},
+		// stopping in a swarm which does not exist does not create it
+		{InfoHash: []byte("bbbbbbbbbbbbbbbbbbbb"), PeerID: peerID1, IP: ip, Port: 6881, Event: peers.EventStopped},
+	}
+	for _, req := range announces {
+		if _, err := s.Announce(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := s.Stats().Torrents; got != 0 {
+		t.Errorf("want no swarms, got %d", got)
+	}
+}
+
+func TestServer_MaxSwarms(t *testing.T) {
+	t.Parallel()
+
+	s := tracker.New(logger)
+	defer s.Close()
+	s.MaxSwarms = 2
+
+	ip := net.ParseIP("127.0.0.1")
+	announce := func(infoHash string) error {
+		_, err := s.Announce(tracker.AnnounceRequest{InfoHash: []byte(infoHash), PeerID: peerID1, IP: ip, Port: 6881, Left: 1})
+		return err
+	}
+
+	for _, infoHash := range []string{"aaaaaaaaaaaaaaaaaaaa", "bbbbbbbbbbbbbbbbbbbb"} {
+		if err := announce(infoHash); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := announce("cccccccccccccccccccc"); !errors.Is(err, tracker.ErrTooManySwarms) {
+		t.Errorf("want error %v, got %v", tracker.ErrTooManySwarms, err)
+	}
+
+	// the existing swarms are still served
+	if err := announce("aaaaaaaaaaaaaaaaaaaa"); err != nil {
+		t.Errorf("want no error on an existing swarm, got %v", err)
+	}
+}
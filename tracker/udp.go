@@ -0,0 +1,188 @@
+package tracker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/kanowfy/btor/peers"
+)
+
+// udp tracker protocol as described in BEP 15
+const (
+	udpProtocolID     uint64 = 0x41727101980
+	udpActionConnect  uint32 = 0
+	udpActionAnnounce uint32 = 1
+	udpActionScrape   uint32 = 2
+	udpActionError    uint32 = 3
+
+	// connIDWindow is the lifetime of a connection ID, those of the previous
+	// window are still accepted so clients can use them for a whole window
+	connIDWindow = time.Minute
+	// maxUDPScrape is the number of info hashes fitting in a scrape response
+	maxUDPScrape = 74
+
+	udpMaxPacketSize = 2048
+)
+
+// ServeUDP serves the udp tracker protocol on a connection until it is closed
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	buf := make([]byte, udpMaxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || n < 16 {
+			continue
+		}
+
+		resp := s.handleUDP(buf[:n], udpAddr, secret)
+		if resp == nil {
+			continue
+		}
+
+		if _, err := conn.WriteTo(resp, addr); err != nil {
+			s.logger.Error("failed to send udp tracker response", slog.String("addr", addr.String()), "error", err)
+		}
+	}
+}
+
+// handleUDP returns the response to a request, nil when it must be ignored
+func (s *Server) handleUDP(req []byte, addr *net.UDPAddr, secret []byte) []byte {
+	connID := binary.BigEndian.Uint64(req[0:8])
+	action := binary.BigEndian.Uint32(req[8:12])
+	tid := binary.BigEndian.Uint32(req[12:16])
+
+	if action == udpActionConnect {
+		if connID != udpProtocolID {
+			return nil
+		}
+
+		resp := udpHeader(udpActionConnect, tid)
+		return binary.BigEndian.AppendUint64(resp, newConnID(secret, addr, time.Now()))
+	}
+
+	// requests with an unknown connection ID are ignored to prevent spoofing
+	if !validConnID(secret, addr, connID, time.Now()) {
+		return nil
+	}
+
+	switch action {
+	case udpActionAnnounce:
+		return s.handleUDPAnnounce(req, addr, tid)
+	case udpActionScrape:
+		return s.handleUDPScrape(req, tid)
+	default:
+		return udpError(tid, "unknown action")
+	}
+}
+
+func (s *Server) handleUDPAnnounce(req []byte, addr *net.UDPAddr, tid uint32) []byte {
+	if len(req) < 98 {
+		return udpError(tid, "announce request too short")
+	}
+
+	numWant := int(int32(binary.BigEndian.Uint32(req[92:96])))
+	resp, err := s.Announce(AnnounceRequest{
+		InfoHash: req[16:36],
+		PeerID:   req[36:56],
+		IP:       addr.IP,
+		Port:     binary.BigEndian.Uint16(req[96:98]),
+		Left:     int64(binary.BigEndian.Uint64(req[64:72])),
+		Event:    peers.Event(binary.BigEndian.Uint32(req[80:84])),
+		NumWant:  numWant,
+	})
+	if err != nil {
+		return udpError(tid, err.Error())
+	}
+
+	b := udpHeader(udpActionAnnounce, tid)
+	b = binary.BigEndian.AppendUint32(b, uint32(s.Interval.Seconds()))
+	b = binary.BigEndian.AppendUint32(b, uint32(resp.Leechers))
+	b = binary.BigEndian.AppendUint32(b, uint32(resp.Seeders))
+
+	// the peers are those of the address family the request came from
+	ipv6 := addr.IP.To4() == nil
+	compact := peers.Compact(resp.Peers, ipv6)
+
+	// the response must fit in a packet, with whole peer entries
+	entrySize := net.IPv4len + 2
+	if ipv6 {
+		entrySize = net.IPv6len + 2
+	}
+	fit := (udpMaxPacketSize - len(b)) / entrySize * entrySize
+
+	return append(b, compact[:min(len(compact), fit)]...)
+}
+
+func (s *Server) handleUDPScrape(req []byte, tid uint32) []byte {
+	body := req[16:]
+	if len(body)%20 != 0 || len(body)/20 > maxUDPScrape {
+		return udpError(tid, "invalid scrape request")
+	}
+
+	var infoHashes [][]byte
+	for i := 0; i < len(body); i += 20 {
+		infoHashes = append(infoHashes, body[i:i+20])
+	}
+
+	// the results are in the order of the request, those not served are reported empty
+	results := make(map[string]peers.ScrapeResult)
+	for _, result := range s.Scrape(infoHashes) {
+		results[string(result.InfoHash)] = result
+	}
+
+	b := udpHeader(udpActionScrape, tid)
+	for _, infoHash := range infoHashes {
+		result := results[string(infoHash)]
+		b = binary.BigEndian.AppendUint32(b, uint32(result.Seeders))
+		b = binary.BigEndian.AppendUint32(b, uint32(result.Completed))
+		b = binary.BigEndian.AppendUint32(b, uint32(result.Leechers))
+	}
+
+	return b
+}
+
+func udpHeader(action, tid uint32) []byte {
+	b := make([]byte, 8, 20)
+	binary.BigEndian.PutUint32(b[0:4], action)
+	binary.BigEndian.PutUint32(b[4:8], tid)
+
+	return b
+}
+
+func udpError(tid uint32, message string) []byte {
+	return append(udpHeader(udpActionError, tid), message...)
+}
+
+// newConnID derives the connection ID of an address for the window of now,
+// clients may use it from other ports of the same ip
+func newConnID(secret []byte, addr *net.UDPAddr, now time.Time) uint64 {
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(addr.IP.To16())
+	binary.Write(mac, binary.BigEndian, now.Unix()/int64(connIDWindow.Seconds()))
+
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// validConnID reports whether a connection ID was given to an address during
+// the current or the previous window
+func validConnID(secret []byte, addr *net.UDPAddr, connID uint64, now time.Time) bool {
+	return connID == newConnID(secret, addr, now) || connID == newConnID(secret, addr, now.Add(-connIDWindow))
+}